@@ -6,16 +6,26 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"sales_service/internal/apikey"
+	"sales_service/internal/export"
+	"sales_service/internal/organization"
 	"sales_service/internal/platform/database"
+	"sales_service/internal/product"
+	"sales_service/internal/role"
 	"sales_service/internal/schema"
 	"sales_service/internal/user"
 
 	"github.com/go-faster/errors"
+	"github.com/jmoiron/sqlx"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 
@@ -96,11 +106,62 @@ func run() error {
 		err = seed(dbConfig)
 
 	case "useradd":
-		err = useradd(dbConfig, cfg.Args[1], cfg.Args[2])
+		if len(cfg.Args) < 3 {
+			return errors.New("usage: useradd <email> <password> [org id]")
+		}
+		orgID := organization.DefaultID
+		if len(cfg.Args) > 3 {
+			orgID = cfg.Args[3]
+		}
+		err = useradd(dbConfig, cfg.Args[1], cfg.Args[2], orgID, []string{auth.RoleAdmin, auth.RoleUser})
+
+	case "superuseradd":
+		if len(cfg.Args) < 3 {
+			return errors.New("usage: superuseradd <email> <password>")
+		}
+		roles := []string{auth.RoleSuperAdmin, auth.RoleAdmin, auth.RoleUser}
+		err = useradd(dbConfig, cfg.Args[1], cfg.Args[2], organization.DefaultID, roles)
+
+	case "orgadd":
+		if len(cfg.Args) < 2 {
+			return errors.New("usage: orgadd <name>")
+		}
+		err = orgadd(dbConfig, cfg.Args[1])
 
 	case "keygen":
 		err = keygen(cfg.Args[1])
 
+	case "apikey-add":
+		if len(cfg.Args) < 4 {
+			return errors.New("usage: apikey-add <email> <name> <ROLE[,ROLE]>")
+		}
+		err = apikeyAdd(dbConfig, cfg.Args[1], cfg.Args[2], strings.Split(cfg.Args[3], ","))
+
+	case "apikey-list":
+		if len(cfg.Args) < 2 {
+			return errors.New("usage: apikey-list <email>")
+		}
+		err = apikeyList(dbConfig, cfg.Args[1])
+
+	case "apikey-revoke":
+		if len(cfg.Args) < 3 {
+			return errors.New("usage: apikey-revoke <email> <key id>")
+		}
+		err = apikeyRevoke(dbConfig, cfg.Args[1], cfg.Args[2])
+
+	case "import":
+		if len(cfg.Args) < 3 {
+			return errors.New("usage: import <email> <file.csv|file.ndjson> [--dry-run]")
+		}
+		dryRun := len(cfg.Args) > 3 && cfg.Args[3] == "--dry-run"
+		err = importProducts(dbConfig, cfg.Args[1], cfg.Args[2], dryRun)
+
+	case "export":
+		if len(cfg.Args) < 4 {
+			return errors.New("usage: export <email> <products|sales> <file.csv|file.ndjson|file.xlsx> [-columns a,b] [-from date] [-to date]")
+		}
+		err = exportTable(dbConfig, cfg.Args[1], cfg.Args[2], cfg.Args[3], cfg.Args[4:])
+
 	default:
 		err = errors.New("invalid command")
 	}
@@ -136,7 +197,7 @@ func seed(cfg database.Config) error {
 	return nil
 }
 
-func useradd(cfg database.Config, email, password string) error {
+func useradd(cfg database.Config, email, password, orgID string, roles []string) error {
 	db, err := database.OpenDB(cfg)
 	if err != nil {
 		return err
@@ -147,7 +208,8 @@ func useradd(cfg database.Config, email, password string) error {
 		return errors.New("email or password is empty")
 	}
 
-	fmt.Printf("Admin user will be created with email: %s and password: %s\n", email, password)
+	fmt.Printf("User with roles %s will be created in organization %s with email: %s and password: %s\n",
+		strings.Join(roles, ","), orgID, email, password)
 	fmt.Print("Do you want to continue? [1/0]: ")
 
 	var confirm bool
@@ -168,10 +230,11 @@ func useradd(cfg database.Config, email, password string) error {
 		Email:           email,
 		Password:        password,
 		PasswordConfirm: password,
-		Roles:           []string{auth.RoleAdmin, auth.RoleUser},
+		Roles:           roles,
+		OrgID:           orgID,
 	}
 
-	u, err := user.Create(ctx, db, nu, time.Now())
+	u, err := user.Create(ctx, db, adminClaims, nu, time.Now())
 	if err != nil {
 		return err
 	}
@@ -179,6 +242,290 @@ func useradd(cfg database.Config, email, password string) error {
 	return nil
 }
 
+// adminClaims are used by the admin tool when calling packages that check
+// permissions. The tool is not limited to an organization.
+var adminClaims = auth.Claims{
+	Roles:       []string{auth.RoleSuperAdmin},
+	OrgID:       organization.DefaultID,
+	Permissions: []string{auth.Any(auth.PermAPIKeyManage)},
+}
+
+// orgadd creates an organization and prints its id.
+func orgadd(cfg database.Config, name string) error {
+	db, err := database.OpenDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	o, err := organization.Create(context.Background(), db, organization.NewOrganization{Name: name}, time.Now())
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Organization was created with id:", o.ID)
+	return nil
+}
+
+// apikeyAdd creates an API key for the user with the given email and prints
+// its secret. The secret cannot be shown again.
+func apikeyAdd(cfg database.Config, email, name string, roles []string) error {
+	db, err := database.OpenDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	u, err := user.RetrieveByEmail(ctx, db, email)
+	if err != nil {
+		return err
+	}
+
+	nk := apikey.NewKey{Name: name, Roles: roles}
+	k, err := apikey.Create(ctx, db, adminClaims, u.ID, nk, time.Now())
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("API key was created with id:", k.ID)
+	fmt.Println("Secret (store it now, it will not be shown again):", k.Secret)
+	return nil
+}
+
+// apikeyList prints the API keys of the user with the given email.
+func apikeyList(cfg database.Config, email string) error {
+	db, err := database.OpenDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	u, err := user.RetrieveByEmail(ctx, db, email)
+	if err != nil {
+		return err
+	}
+
+	keys, err := apikey.List(ctx, db, adminClaims, u.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		lastUsed, revoked := "never", "active"
+		if k.DateLastUsed != nil {
+			lastUsed = k.DateLastUsed.Format(time.RFC3339)
+		}
+		if k.DateRevoked != nil {
+			revoked = "revoked " + k.DateRevoked.Format(time.RFC3339)
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\tlast used: %s\t%s\n",
+			k.ID, k.Prefix, k.Name, strings.Join(k.Roles, ","), lastUsed, revoked)
+	}
+	return nil
+}
+
+// apikeyRevoke revokes an API key of the user with the given email.
+func apikeyRevoke(cfg database.Config, email, keyID string) error {
+	db, err := database.OpenDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	u, err := user.RetrieveByEmail(ctx, db, email)
+	if err != nil {
+		return err
+	}
+
+	if err := apikey.Revoke(ctx, db, adminClaims, u.ID, keyID, time.Now()); err != nil {
+		return err
+	}
+
+	fmt.Println("API key was revoked:", keyID)
+	return nil
+}
+
+// importProducts imports the products of a CSV or NDJSON file, chosen by its
+// extension, on behalf of the user with the given email, and prints the
+// report. The file is streamed, so it may be larger than memory.
+func importProducts(cfg database.Config, email, path string, dryRun bool) error {
+	var format string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		format = product.FormatCSV
+	case ".ndjson", ".jsonl":
+		format = product.FormatNDJSON
+	default:
+		return errors.New("file must end in .csv, .ndjson or .jsonl")
+	}
+
+	db, err := database.OpenDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+
+	// The import runs with the permissions of the user, like an import
+	// through the API would.
+	claims, err := userClaims(ctx, db, email, now)
+	if err != nil {
+		return err
+	}
+	if !claims.HasPermission(auth.PermProductCreate) {
+		return errors.Errorf("%s may not create products", email)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "opening import")
+	}
+	defer f.Close()
+
+	report, err := product.Import(ctx, db, claims, f, format, dryRun, now)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range report.Errors {
+		fmt.Printf("line %d: %s\n", e.Line, e.Error)
+		for _, fe := range e.Fields {
+			fmt.Printf("\t%s: %s\n", fe.Field, fe.Error)
+		}
+	}
+	if report.Failed > len(report.Errors) {
+		fmt.Printf("... and %d more failed rows\n", report.Failed-len(report.Errors))
+	}
+
+	if dryRun {
+		fmt.Print("Dry run, nothing was saved. ")
+	}
+	fmt.Printf("Rows: %d, created: %d, updated: %d, unchanged: %d, failed: %d\n",
+		report.Rows, report.Created, report.Updated, report.Unchanged, report.Failed)
+	return nil
+}
+
+// exportTable writes the products or sales visible to the user with the
+// given email to a CSV, NDJSON or XLSX file, chosen by its extension. Rows
+// are written as they are read, so the table may be larger than memory.
+func exportTable(cfg database.Config, email, table, path string, args []string) error {
+	var fn func(context.Context, *sqlx.DB, auth.Claims, io.Writer, export.Options) (int, error)
+	var perm string
+	switch table {
+	case "products":
+		fn, perm = export.Products, auth.PermProductRead
+	case "sales":
+		fn, perm = export.Sales, auth.PermSaleRead
+	default:
+		return errors.New("table must be products or sales")
+	}
+
+	var opts export.Options
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		opts.Format = export.FormatCSV
+	case ".ndjson", ".jsonl":
+		opts.Format = export.FormatNDJSON
+	case ".xlsx":
+		opts.Format = export.FormatXLSX
+	default:
+		return errors.New("file must end in .csv, .ndjson, .jsonl or .xlsx")
+	}
+
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	columns := flags.String("columns", "", "columns to write, separated by commas")
+	from := flags.String("from", "", "only rows created at or after this date")
+	to := flags.String("to", "", "only rows created before this date")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *columns != "" {
+		opts.Columns = strings.Split(*columns, ",")
+	}
+	for _, d := range []struct {
+		value string
+		dst   **time.Time
+	}{{*from, &opts.From}, {*to, &opts.To}} {
+		if d.value == "" {
+			continue
+		}
+		t, err := parseDate(d.value)
+		if err != nil {
+			return err
+		}
+		*d.dst = &t
+	}
+
+	db, err := database.OpenDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	claims, err := userClaims(ctx, db, email, time.Now())
+	if err != nil {
+		return err
+	}
+	if !claims.HasPermission(perm) {
+		return errors.Errorf("%s may not read %s", email, table)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "creating export")
+	}
+	defer f.Close()
+
+	n, err := fn(ctx, db, claims, f, opts)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "closing export")
+	}
+
+	fmt.Printf("Exported %d %s to %s\n", n, table, path)
+	return nil
+}
+
+// parseDate reads a date given either as RFC 3339 or as a plain date, which
+// is taken as midnight UTC.
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("%q must be an RFC 3339 time or a YYYY-MM-DD date", s)
+}
+
+// userClaims builds the claims of the user with the given email, with the
+// permissions of their roles, so commands acting on behalf of a user are
+// held to what the user could do through the API.
+func userClaims(ctx context.Context, db *sqlx.DB, email string, now time.Time) (auth.Claims, error) {
+	u, err := user.RetrieveByEmail(ctx, db, email)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	claims := auth.NewClaims(u.ID, u.OrgID, u.Roles, now, time.Hour)
+	if claims.Permissions, err = role.PermissionsFor(ctx, db, u.Roles); err != nil {
+		return auth.Claims{}, err
+	}
+	return claims, nil
+}
+
 // keygen generates a new RSA private key and writes it to the specified file path.
 func keygen(path string) error {
 	// Check if the file path is empty.
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sales_service/internal/apikey"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/web"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// APIKeys has handlers for the API keys of users.
+type APIKeys struct {
+	DB *sqlx.DB
+}
+
+// Create issues a new API key for a user. The secret is only sent in this
+// response.
+func (k *APIKeys) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.apikey.Create")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	var nk apikey.NewKey
+	if err := web.Decode(r, &nk); err != nil {
+		return errors.Wrap(err, "decode new api key")
+	}
+
+	key, err := apikey.Create(ctx, k.DB, claims, chi.URLParam(r, "id"), nk, time.Now())
+	if err != nil {
+		return apiKeyError(err, "creating api key")
+	}
+
+	return web.Respond(ctx, w, key, http.StatusCreated)
+}
+
+// List sends every API key of a user.
+func (k *APIKeys) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.apikey.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	keys, err := apikey.List(ctx, k.DB, claims, chi.URLParam(r, "id"))
+	if err != nil {
+		return apiKeyError(err, "listing api keys")
+	}
+
+	return web.Respond(ctx, w, keys, http.StatusOK)
+}
+
+// Unused sends the active API keys of all users that were not used since the
+// time given by the since parameter, 90 days ago by default.
+func (k *APIKeys) Unused(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.apikey.Unused")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	since, err := parseTime(r.URL.Query(), "since")
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	if since == nil {
+		t := time.Now().AddDate(0, 0, -90)
+		since = &t
+	}
+
+	keys, err := apikey.ListUnused(ctx, k.DB, claims, *since)
+	if err != nil {
+		return errors.Wrap(err, "listing unused api keys")
+	}
+
+	return web.Respond(ctx, w, keys, http.StatusOK)
+}
+
+// Revoke disables an API key of a user.
+func (k *APIKeys) Revoke(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.apikey.Revoke")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	err := apikey.Revoke(ctx, k.DB, claims, chi.URLParam(r, "id"), chi.URLParam(r, "keyID"), time.Now())
+	if err != nil {
+		return apiKeyError(err, "revoking api key")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// apiKeyError maps errors from the apikey package to responses.
+func apiKeyError(err error, msg string) error {
+	switch {
+	case errors.Is(err, apikey.ErrNotFound), errors.Is(err, apikey.ErrUserNotFound):
+		return web.NewRequestError(err, http.StatusNotFound)
+	case errors.Is(err, apikey.ErrInvalidID), errors.Is(err, apikey.ErrRoleNotHeld):
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case errors.Is(err, apikey.ErrForbidden):
+		return web.NewRequestError(err, http.StatusForbidden)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sales_service/internal/audit"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/web"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Audit has handlers for reading the audit log.
+type Audit struct {
+	DB *sqlx.DB
+}
+
+// List sends the audit entries of the caller's organization, newest first.
+// They can be filtered by actor, resource, resource_id and a from/to range.
+func (a *Audit) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Audit.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	q := r.URL.Query()
+
+	f := audit.Filter{
+		ActorID:    q.Get("actor"),
+		Resource:   q.Get("resource"),
+		ResourceID: q.Get("resource_id"),
+	}
+
+	var err error
+	if f.From, err = parseTime(q, "from"); err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	if f.To, err = parseTime(q, "to"); err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	if f.Limit, err = parseInt(q, "limit"); err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	if f.Offset, err = parseInt(q, "offset"); err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	list, err := audit.List(ctx, a.DB, claims, f)
+	if err != nil {
+		switch {
+		case errors.Is(err, audit.ErrInvalidActor):
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "listing audit entries")
+		}
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
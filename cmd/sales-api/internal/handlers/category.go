@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sales_service/internal/category"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/web"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Categories has handlers for the category tree products are browsed by.
+type Categories struct {
+	DB *sqlx.DB
+}
+
+// List sends every category of the caller's organization.
+func (cg *Categories) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Categories.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	list, err := category.List(ctx, cg.DB, claims)
+	if err != nil {
+		return errors.Wrap(err, "listing categories")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Retrieve sends a single category.
+func (cg *Categories) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Categories.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	c, err := category.Retrieve(ctx, cg.DB, claims, chi.URLParam(r, "id"))
+	if err != nil {
+		return categoryError(err, "retrieving category")
+	}
+
+	return web.Respond(ctx, w, c, http.StatusOK)
+}
+
+// Create adds a category.
+func (cg *Categories) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Categories.Create")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	var nc category.NewCategory
+	if err := web.Decode(r, &nc); err != nil {
+		return errors.Wrap(err, "decode new category")
+	}
+
+	c, err := category.Create(ctx, cg.DB, claims, nc, time.Now())
+	if err != nil {
+		return categoryError(err, "creating category")
+	}
+
+	return web.Respond(ctx, w, c, http.StatusCreated)
+}
+
+// Update renames a category or moves it under another parent.
+func (cg *Categories) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Categories.Update")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	var upd category.UpdateCategory
+	if err := web.Decode(r, &upd); err != nil {
+		return errors.Wrap(err, "decode category update")
+	}
+
+	c, err := category.Update(ctx, cg.DB, claims, chi.URLParam(r, "id"), upd, time.Now())
+	if err != nil {
+		return categoryError(err, "updating category")
+	}
+
+	return web.Respond(ctx, w, c, http.StatusOK)
+}
+
+// Delete removes a category without subcategories.
+func (cg *Categories) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Categories.Delete")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	if err := category.Delete(ctx, cg.DB, claims, chi.URLParam(r, "id"), time.Now()); err != nil {
+		return categoryError(err, "deleting category")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// categoryError maps errors from the category package to responses.
+func categoryError(err error, msg string) error {
+	switch {
+	case errors.Is(err, category.ErrNotFound):
+		return web.NewRequestError(err, http.StatusNotFound)
+	case errors.Is(err, category.ErrInvalidID), errors.Is(err, category.ErrParentNotFound),
+		errors.Is(err, category.ErrCycle):
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case errors.Is(err, category.ErrHasChildren):
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+)
+
+// etag formats a version as a strong entity tag.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// parseETag reads the version out of an If-Match header holding a single
+// entity tag produced by etag.
+func parseETag(header string) (int, bool) {
+	s := strings.TrimSpace(header)
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return 0, false
+	}
+	v, err := strconv.Atoi(s[1 : len(s)-1])
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// noneMatch reports whether an If-None-Match header matches tag, either
+// through one of its comma separated entity tags or through "*". Weak tags
+// match their strong form, as the weak comparison requires.
+func noneMatch(header, tag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sales_service/internal/export"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/web"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/jmoiron/sqlx"
+	"go.opencensus.io/trace"
+)
+
+// Export has handlers for dumping whole tables for accounting.
+type Export struct {
+	DB  *sqlx.DB
+	Log *log.Logger
+}
+
+// exportFunc is the signature shared by the functions of the export package.
+type exportFunc func(context.Context, *sqlx.DB, auth.Claims, io.Writer, export.Options) (int, error)
+
+// exportTypes are the Content-Type of every export format.
+var exportTypes = map[string]string{
+	export.FormatCSV:    "text/csv;charset=utf-8",
+	export.FormatNDJSON: "application/x-ndjson",
+	export.FormatXLSX:   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// Products streams every product of the caller's organization.
+func (e *Export) Products(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Export.Products")
+	defer span.End()
+
+	return e.stream(ctx, w, r, "products", export.Products)
+}
+
+// Sales streams every sale of the caller's organization.
+func (e *Export) Sales(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Export.Sales")
+	defer span.End()
+
+	return e.stream(ctx, w, r, "sales", export.Sales)
+}
+
+// stream sends an export as a download, written as the rows are read. The
+// format is csv, ndjson or xlsx, csv by default; columns picks the columns
+// by name, separated by commas; from and to limit the rows to those created
+// in between.
+func (e *Export) stream(ctx context.Context, w http.ResponseWriter, r *http.Request, name string, fn exportFunc) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return errors.New("web value missing from context")
+	}
+
+	q := r.URL.Query()
+
+	opts := export.Options{Format: q.Get("format")}
+	if opts.Format == "" {
+		opts.Format = export.FormatCSV
+	}
+	if cols := q.Get("columns"); cols != "" {
+		opts.Columns = strings.Split(cols, ",")
+	}
+
+	var err error
+	if opts.From, err = parseTime(q, "from"); err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	if opts.To, err = parseTime(q, "to"); err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	// An export can take longer than the server allows other responses.
+	// Clearing the deadline is not supported by every ResponseWriter, in
+	// which case the server's timeout stays.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	sw := &streamWriter{
+		w:           w,
+		contentType: exportTypes[opts.Format],
+		filename:    name + "." + opts.Format,
+	}
+	v.StatusCode = http.StatusOK
+
+	n, err := fn(ctx, e.DB, claims, sw, opts)
+	if err != nil {
+		// Once the body has started the status cannot change anymore. The
+		// client is left with a cut short export.
+		if sw.started {
+			e.Log.Printf("%s: export of %s failed after %d rows: %+v", v.TraceID, name, n, err)
+			return nil
+		}
+
+		switch {
+		case errors.Is(err, export.ErrUnknownFormat), errors.Is(err, export.ErrInvalidColumns),
+			errors.Is(err, export.ErrInvalidRange):
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "exporting %s", name)
+		}
+	}
+
+	// An export without any row may have written nothing, but is still a
+	// download.
+	sw.start()
+
+	return nil
+}
+
+// streamWriter holds the headers of a download back until the first byte of
+// it is written, so an export failing before that still sends an error.
+// Writes go to the client as they come, in chunks.
+type streamWriter struct {
+	w           http.ResponseWriter
+	contentType string
+	filename    string
+	started     bool
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	sw.start()
+	return sw.w.Write(p)
+}
+
+// start sends the headers of the download unless they were sent already.
+func (sw *streamWriter) start() {
+	if sw.started {
+		return
+	}
+	sw.started = true
+	sw.w.Header().Set("Content-Type", sw.contentType)
+	sw.w.Header().Set("Content-Disposition", `attachment; filename="`+sw.filename+`"`)
+	sw.w.WriteHeader(http.StatusOK)
+}
+
+// Flush sends what was written so far to the client.
+func (sw *streamWriter) Flush() {
+	if f, ok := sw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/web"
+)
+
+// JWKS publishes the public keys tokens are signed with so that other
+// services can verify them.
+type JWKS struct {
+	authenticator *auth.Authenticator
+}
+
+// Keys sends the public keys as a JSON Web Key Set.
+func (j *JWKS) Keys(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	// Let verifiers cache the set for a while; new keys are picked up on the
+	// next fetch.
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	return web.Respond(ctx, w, j.authenticator.JWKS(), http.StatusOK)
+}
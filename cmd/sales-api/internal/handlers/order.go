@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sales_service/internal/order"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/web"
+	"sales_service/internal/product"
+	"sales_service/internal/warehouse"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-faster/errors"
+	"github.com/jmoiron/sqlx"
+	"go.opencensus.io/trace"
+)
+
+// Order has methods for dealing with orders.
+type Order struct {
+	DB *sqlx.DB
+}
+
+// Create records a new order with all of its lines.
+func (o *Order) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Order.Create")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("claims missing from request context")
+	}
+
+	var no order.NewOrder
+	if err := web.Decode(r, &no); err != nil {
+		return errors.Wrap(err, "decode new order")
+	}
+
+	ord, err := order.Create(ctx, o.DB, claims, no, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, product.ErrInvalidID), errors.Is(err, product.ErrInvalidVariantID),
+			errors.Is(err, product.ErrVariantRequired), errors.Is(err, order.ErrMixedOrgs),
+			errors.Is(err, warehouse.ErrInvalidID), errors.Is(err, warehouse.ErrRequired):
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, product.ErrNotFound), errors.Is(err, product.ErrVariantNotFound), errors.Is(err, warehouse.ErrNotFound):
+			return web.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, product.ErrInsufficientStock), errors.Is(err, warehouse.ErrInsufficientStock):
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrap(err, "creating order")
+		}
+	}
+
+	return web.Respond(ctx, w, ord, http.StatusCreated)
+}
+
+// List sends a page of orders. Users allowed to read any order see every
+// order of their organization, other users only their own.
+func (o *Order) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Order.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("claims missing from request context")
+	}
+
+	q := r.URL.Query()
+	limit, err := parseInt(q, "limit")
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	offset, err := parseInt(q, "offset")
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	var userID string
+	if !claims.HasPermission(auth.Any(auth.PermOrderRead)) {
+		userID = claims.Subject
+	}
+
+	list, err := order.List(ctx, o.DB, claims, userID, limit, offset)
+	if err != nil {
+		return errors.Wrap(err, "listing orders")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Retrieve sends a single order with its lines.
+func (o *Order) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Order.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("claims missing from request context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	ord, err := order.Retrieve(ctx, o.DB, claims, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, order.ErrNotFound):
+			return web.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, order.ErrInvalidID):
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "looking up order %q", id)
+		}
+	}
+
+	if !claims.Can(auth.PermOrderRead, ord.UserID) {
+		return web.NewRequestError(order.ErrForbidden, http.StatusForbidden)
+	}
+
+	return web.Respond(ctx, w, ord, http.StatusOK)
+}
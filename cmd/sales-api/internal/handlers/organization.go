@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sales_service/internal/organization"
+	"sales_service/internal/platform/web"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Organizations has handlers for managing the tenants of the service.
+type Organizations struct {
+	DB *sqlx.DB
+}
+
+// Create adds an organization.
+func (og *Organizations) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.organization.Create")
+	defer span.End()
+
+	var no organization.NewOrganization
+	if err := web.Decode(r, &no); err != nil {
+		return errors.Wrap(err, "decode new organization")
+	}
+
+	org, err := organization.Create(ctx, og.DB, no, time.Now())
+	if err != nil {
+		return errors.Wrap(err, "creating organization")
+	}
+
+	return web.Respond(ctx, w, org, http.StatusCreated)
+}
+
+// List sends every organization.
+func (og *Organizations) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.organization.List")
+	defer span.End()
+
+	list, err := organization.List(ctx, og.DB)
+	if err != nil {
+		return errors.Wrap(err, "listing organizations")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Retrieve sends a single organization.
+func (og *Organizations) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.organization.Retrieve")
+	defer span.End()
+
+	id := chi.URLParam(r, "id")
+
+	org, err := organization.Retrieve(ctx, og.DB, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, organization.ErrNotFound):
+			return web.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, organization.ErrInvalidID):
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "looking up organization %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, org, http.StatusOK)
+}
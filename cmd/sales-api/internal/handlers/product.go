@@ -1,17 +1,24 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
-	"fmt"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
 	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/barcode"
 	"sales_service/internal/platform/web"
 	"sales_service/internal/product"
+	"sales_service/internal/warehouse"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-faster/errors"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"go.opencensus.io/trace"
 )
@@ -22,38 +29,171 @@ type Product struct {
 	Log *log.Logger
 }
 
-// List send all products as list
+// List sends a page of products matching the query string filters together
+// with the total count and a link to the next page.
 func (p *Product) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	
-	// The function starts a new span to trace the execution of the handler function.
-	// It then retrieves all products from the database using the product.List function and stores the result in the 'list' variable.
-	// If there is an error retrieving the products, it writes an error status code to the response and logs the error.
-	// Finally, it sends the list of products in the response using the web.Respond function.
-	// The function returns the error encountered during the execution of these steps.
-
 	ctx, span := trace.StartSpan(ctx, "handlers.Product.List")
 	defer span.End()
 
-	list, err := product.List(ctx, p.DB)
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
 
+	filter, err := parseListFilter(r.URL.Query())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		p.Log.Println("error query to db", err)
-		return err
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	page, err := product.List(ctx, p.DB, claims, filter)
+	if err != nil {
+		switch {
+		case errors.Is(err, product.ErrInvalidSort), errors.Is(err, product.ErrInvalidCursor):
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "listing products")
+		}
+	}
+
+	resp := struct {
+		*product.Page
+		Next string `json:"next,omitempty"`
+	}{Page: page}
+
+	// The next link repeats the caller's filters and replaces any offset with
+	// the cursor of the last row on this page.
+	if page.NextCursor != "" {
+		q := r.URL.Query()
+		q.Del("offset")
+		q.Set("cursor", page.NextCursor)
+		resp.Next = r.URL.Path + "?" + q.Encode()
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// Search sends the products best matching the q parameter, with the
+// matching words of their names highlighted.
+func (p *Product) Search(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Product.Search")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	q := r.URL.Query()
+
+	limit, err := parseInt(q, "limit")
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	list, err := product.Search(ctx, p.DB, claims, q.Get("q"), limit)
+	if err != nil {
+		if errors.Is(err, product.ErrEmptySearch) {
+			return web.NewRequestError(err, http.StatusBadRequest)
+		}
+		return errors.Wrap(err, "searching products")
 	}
 
 	return web.Respond(ctx, w, list, http.StatusOK)
 }
 
+// parseListFilter builds a product.ListFilter from the query string of a
+// listing request.
+func parseListFilter(q url.Values) (product.ListFilter, error) {
+	f := product.ListFilter{
+		Name:   q.Get("name"),
+		Cursor: q.Get("cursor"),
+		SortBy: q.Get("sort"),
+	}
+
+	switch strings.ToLower(q.Get("order")) {
+	case "", "asc":
+	case "desc":
+		f.Desc = true
+	default:
+		return f, errors.New("order must be asc or desc")
+	}
+
+	var err error
+	if f.Limit, err = parseInt(q, "limit"); err != nil {
+		return f, err
+	}
+	if f.Offset, err = parseInt(q, "offset"); err != nil {
+		return f, err
+	}
+
+	for _, c := range []struct {
+		key string
+		dst **int
+	}{{"min_cost", &f.MinCost}, {"max_cost", &f.MaxCost}} {
+		if q.Get(c.key) == "" {
+			continue
+		}
+		v, err := parseInt(q, c.key)
+		if err != nil {
+			return f, err
+		}
+		*c.dst = &v
+	}
+
+	if f.UserID = q.Get("user_id"); f.UserID != "" {
+		if _, err := uuid.Parse(f.UserID); err != nil {
+			return f, errors.New("user_id must be a UUID")
+		}
+	}
+
+	if f.CreatedFrom, err = parseTime(q, "created_from"); err != nil {
+		return f, err
+	}
+	if f.CreatedTo, err = parseTime(q, "created_to"); err != nil {
+		return f, err
+	}
+	if f.Archived, err = parseBool(q, "archived"); err != nil {
+		return f, err
+	}
+
+	if f.CategoryID = q.Get("category"); f.CategoryID != "" {
+		if _, err := uuid.Parse(f.CategoryID); err != nil {
+			return f, errors.New("category must be a UUID")
+		}
+	}
+
+	// Tags are given as repeated tag parameters, a comma separated list, or
+	// both.
+	for _, v := range q["tag"] {
+		f.Tags = append(f.Tags, strings.Split(v, ",")...)
+	}
+
+	return f, nil
+}
+
 func (p *Product) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
 
 	id := chi.URLParam(r, "id")
 
-	prod, err := product.Retrieve(ctx, p.DB, id)
+	var opts product.RetrieveOptions
+
+	var err error
+	if opts.Archived, err = parseBool(r.URL.Query(), "archived"); err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	if opts.AsOf, err = parseTime(r.URL.Query(), "as_of"); err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	prod, err := product.Retrieve(ctx, p.DB, claims, id, opts)
 
 	if err != nil {
 		switch {
-		case errors.Is(err, product.ErrNotFound):
+		case errors.Is(err, product.ErrNotFound), errors.Is(err, product.ErrNoPrice):
 			return web.NewRequestError(err, http.StatusNotFound)
 		case errors.Is(err, product.ErrInvalidID):
 			return web.NewRequestError(err, http.StatusBadRequest)
@@ -63,6 +203,19 @@ func (p *Product) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.R
 
 	}
 
+	// A past price is not the current version of the product.
+	if opts.AsOf != nil {
+		return web.Respond(ctx, w, prod, http.StatusOK)
+	}
+
+	// The version is the entity tag, so a client holding the current
+	// version gets no body back.
+	tag := etag(prod.Version)
+	w.Header().Set("ETag", tag)
+	if noneMatch(r.Header.Get("If-None-Match"), tag) {
+		return web.Respond(ctx, w, nil, http.StatusNotModified)
+	}
+
 	// Return the product
 	return web.Respond(ctx, w, prod, http.StatusOK)
 }
@@ -80,13 +233,18 @@ func (p *Product) Create(ctx context.Context, w http.ResponseWriter, r *http.Req
 		return err
 	}
 
-	fmt.Println("newProduct", newProduct)
-
 	prod, err := product.Create(ctx, p.DB, claims, newProduct, time.Now())
 	if err != nil {
+		switch {
+		case errors.Is(err, product.ErrCategoryNotFound):
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, product.ErrDuplicateSKU), errors.Is(err, product.ErrDuplicateBarcode):
+			return web.NewRequestError(err, http.StatusConflict)
+		}
 		return err
 	}
 
+	w.Header().Set("ETag", etag(prod.Version))
 	return web.Respond(ctx, w, prod, http.StatusCreated)
 
 }
@@ -104,29 +262,57 @@ func (p *Product) Update(ctx context.Context, w http.ResponseWriter, r *http.Req
 		return errors.New("claims missing from request context")
 	}
 
-	if err := product.Update(ctx, p.DB, claims, id, update, time.Now()); err != nil {
+	// Updates must be based on the version the client read, given back as
+	// the entity tag of the GET.
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		return web.NewRequestError(errors.New("If-Match header with the product ETag is required"), http.StatusPreconditionRequired)
+	}
+	version, ok := parseETag(match)
+	if !ok {
+		return web.NewRequestError(product.ErrVersionMismatch, http.StatusPreconditionFailed)
+	}
+
+	if err := product.Update(ctx, p.DB, claims, id, version, update, time.Now()); err != nil {
 		switch err {
 		case product.ErrNotFound:
 			return web.NewRequestError(err, http.StatusNotFound)
-		case product.ErrInvalidID:
+		case product.ErrInvalidID, product.ErrCategoryNotFound:
 			return web.NewRequestError(err, http.StatusBadRequest)
 		case product.ErrForbidden:
 			return web.NewRequestError(err, http.StatusForbidden)
+		case product.ErrVersionMismatch:
+			return web.NewRequestError(err, http.StatusPreconditionFailed)
+		case product.ErrDuplicateSKU, product.ErrDuplicateBarcode, product.ErrHasVariants, product.ErrStocked:
+			return web.NewRequestError(err, http.StatusConflict)
 		default:
 			return errors.Wrap(err, "updating product")
 		}
 	}
+
+	// The row was locked at the version given, so the update made the next
+	// one.
+	w.Header().Set("ETag", etag(version+1))
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
 }
 
 func (p *Product) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
 	id := chi.URLParam(r, "id")
 
-	err := product.Delete(ctx, p.DB, id)
+	err := product.Delete(ctx, p.DB, claims, id, time.Now())
 	if err != nil {
 		switch err {
 		case product.ErrInvalidID:
 			return web.NewRequestError(err, http.StatusBadRequest)
+		case product.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case product.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
 		default:
 			return errors.Wrap(err, "deleting product")
 		}
@@ -135,27 +321,543 @@ func (p *Product) Delete(ctx context.Context, w http.ResponseWriter, r *http.Req
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
 }
 
+// Import adds or updates products from the rows of a CSV or NDJSON body,
+// given by the format parameter or the Content-Type header, and sends a
+// report of what was done. With dry_run=true nothing is kept.
+func (p *Product) Import(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Product.Import")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	q := r.URL.Query()
+
+	format := q.Get("format")
+	if format == "" {
+		format = importFormat(r.Header.Get("Content-Type"))
+	}
+
+	dryRun, err := parseBool(q, "dry_run")
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	// The body is read as the rows are imported, never held in memory.
+	report, err := product.Import(ctx, p.DB, claims, r.Body, format, dryRun, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, product.ErrUnknownFormat), errors.Is(err, product.ErrImportHeader),
+			errors.Is(err, product.ErrMalformed):
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "importing products")
+		}
+	}
+
+	return web.Respond(ctx, w, newImportReport(report), http.StatusOK)
+}
+
+// importReport is the response to an import. The fields of failed rows are
+// sent as web.FieldError like any other validation error.
+type importReport struct {
+	product.ImportReport
+	Errors []importError `json:"errors"`
+}
+
+// importError shadows the fields of the embedded product.ImportError.
+type importError struct {
+	product.ImportError
+	Fields []web.FieldError `json:"fields,omitempty"`
+}
+
+// newImportReport translates an import report into its response.
+func newImportReport(report *product.ImportReport) importReport {
+	resp := importReport{ImportReport: *report, Errors: []importError{}}
+	for _, e := range report.Errors {
+		ie := importError{ImportError: e}
+		for _, f := range e.Fields {
+			ie.Fields = append(ie.Fields, web.FieldError(f))
+		}
+		resp.Errors = append(resp.Errors, ie)
+	}
+	return resp
+}
+
+// importFormat gives the import format matching a Content-Type, or nothing
+// when it does not match one.
+func importFormat(contentType string) string {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch mt {
+	case "text/csv":
+		return product.FormatCSV
+	case "application/x-ndjson", "application/jsonl", "application/x-jsonlines":
+		return product.FormatNDJSON
+	default:
+		return ""
+	}
+}
+
+// ByBarcode sends the product carrying the barcode in the URL, for
+// point-of-sale scanners.
+func (p *Product) ByBarcode(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Product.ByBarcode")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	code := chi.URLParam(r, "code")
+
+	prod, err := product.RetrieveByBarcode(ctx, p.DB, claims, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, product.ErrNotFound):
+			return web.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, product.ErrInvalidBarcode):
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "looking up barcode %q", code)
+		}
+	}
+
+	w.Header().Set("ETag", etag(prod.Version))
+	return web.Respond(ctx, w, prod, http.StatusOK)
+}
+
+// Label renders the barcode of a product as an SVG label captioned with the
+// product name, or with format=png as a PNG image. The scale parameter sets
+// how many pixels wide the thinnest bar is.
+func (p *Product) Label(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Product.Label")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	q := r.URL.Query()
+
+	scale, err := parseInt(q, "scale")
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	switch {
+	case scale == 0:
+		scale = 2
+	case scale > 10:
+		return web.NewRequestError(errors.New("scale must be at most 10"), http.StatusBadRequest)
+	}
+
+	id := chi.URLParam(r, "id")
+
+	prod, err := product.Retrieve(ctx, p.DB, claims, id, product.RetrieveOptions{})
+	if err != nil {
+		switch {
+		case errors.Is(err, product.ErrNotFound):
+			return web.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, product.ErrInvalidID):
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "looking up product %q", id)
+		}
+	}
+	if prod.Barcode == "" {
+		return web.NewRequestError(errors.New("product has no barcode"), http.StatusNotFound)
+	}
+
+	var buf bytes.Buffer
+	switch q.Get("format") {
+	case "", "svg":
+		if err := barcode.SVG(&buf, prod.Barcode, prod.Name, scale); err != nil {
+			return errors.Wrap(err, "rendering label")
+		}
+		return web.RespondBytes(ctx, w, buf.Bytes(), "image/svg+xml", http.StatusOK)
+	case "png":
+		if err := barcode.PNG(&buf, prod.Barcode, scale); err != nil {
+			return errors.Wrap(err, "rendering label")
+		}
+		return web.RespondBytes(ctx, w, buf.Bytes(), "image/png", http.StatusOK)
+	default:
+		return web.NewRequestError(errors.New("format must be svg or png"), http.StatusBadRequest)
+	}
+}
+
+// Tags sends every tag in use with the number of products carrying it.
+func (p *Product) Tags(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Product.Tags")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	list, err := product.ListTags(ctx, p.DB, claims)
+	if err != nil {
+		return errors.Wrap(err, "listing tags")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Restore brings back an archived product.
+func (p *Product) Restore(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Product.Restore")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	prod, err := product.Restore(ctx, p.DB, claims, chi.URLParam(r, "id"), time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, product.ErrInvalidID):
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, product.ErrNotArchived):
+			return web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return errors.Wrap(err, "restoring product")
+		}
+	}
+
+	return web.Respond(ctx, w, prod, http.StatusOK)
+}
+
+// Purge permanently removes the products archived for longer than the
+// retention period, and sends how many were removed.
+func (p *Product) Purge(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Product.Purge")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	now := time.Now()
+
+	n, err := product.Purge(ctx, p.DB, claims, now.Add(-product.Retention), now)
+	if err != nil {
+		return errors.Wrap(err, "purging products")
+	}
+
+	resp := struct {
+		Purged int `json:"purged"`
+	}{n}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// Prices sends the price history of a product, scheduled changes included.
+func (p *Product) Prices(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Product.Prices")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	list, err := product.ListPrices(ctx, p.DB, claims, chi.URLParam(r, "id"))
+	if err != nil {
+		return priceError(err, "listing prices")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// SchedulePrice changes the price of a product, right away or from a later
+// time.
+func (p *Product) SchedulePrice(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Product.SchedulePrice")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	var np product.NewPrice
+	if err := web.Decode(r, &np); err != nil {
+		return errors.Wrap(err, "decode new price")
+	}
+
+	price, err := product.SchedulePrice(ctx, p.DB, claims, chi.URLParam(r, "id"), np, time.Now())
+	if err != nil {
+		return priceError(err, "scheduling price")
+	}
+
+	return web.Respond(ctx, w, price, http.StatusCreated)
+}
+
+// CancelPrice removes a price change that has not taken effect yet.
+func (p *Product) CancelPrice(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Product.CancelPrice")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	err := product.CancelPrice(ctx, p.DB, claims, chi.URLParam(r, "id"), chi.URLParam(r, "priceID"), time.Now())
+	if err != nil {
+		return priceError(err, "cancelling price")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// priceError maps errors about product prices to responses.
+func priceError(err error, msg string) error {
+	switch {
+	case errors.Is(err, product.ErrInvalidID), errors.Is(err, product.ErrPastPrice):
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case errors.Is(err, product.ErrNotFound), errors.Is(err, product.ErrPriceNotFound):
+		return web.NewRequestError(err, http.StatusNotFound)
+	case errors.Is(err, product.ErrForbidden):
+		return web.NewRequestError(err, http.StatusForbidden)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
+
+// Variants sends the variants of a product with their sales totals.
+func (p *Product) Variants(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Product.Variants")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	list, err := product.ListVariants(ctx, p.DB, claims, chi.URLParam(r, "id"))
+	if err != nil {
+		return variantError(err, "listing variants")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// AddVariant adds a variant to a product, which is from then on sold by
+// variant.
+func (p *Product) AddVariant(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Product.AddVariant")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	var nv product.NewVariant
+	if err := web.Decode(r, &nv); err != nil {
+		return errors.Wrap(err, "decode new variant")
+	}
+
+	v, err := product.AddVariant(ctx, p.DB, claims, chi.URLParam(r, "id"), nv, time.Now())
+	if err != nil {
+		return variantError(err, "adding variant")
+	}
+
+	return web.Respond(ctx, w, v, http.StatusCreated)
+}
+
+// ModifyVariant changes the SKU, attributes, cost or quantity of a variant.
+func (p *Product) ModifyVariant(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Product.ModifyVariant")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	var upd product.UpdateVariant
+	if err := web.Decode(r, &upd); err != nil {
+		return errors.Wrap(err, "decode variant update")
+	}
+
+	v, err := product.ModifyVariant(ctx, p.DB, claims, chi.URLParam(r, "id"), chi.URLParam(r, "variantID"), upd, time.Now())
+	if err != nil {
+		return variantError(err, "modifying variant")
+	}
+
+	return web.Respond(ctx, w, v, http.StatusOK)
+}
+
+// RemoveVariant removes a variant that was never sold.
+func (p *Product) RemoveVariant(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Product.RemoveVariant")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	err := product.RemoveVariant(ctx, p.DB, claims, chi.URLParam(r, "id"), chi.URLParam(r, "variantID"), time.Now())
+	if err != nil {
+		return variantError(err, "removing variant")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// variantError maps errors about product variants to responses.
+func variantError(err error, msg string) error {
+	switch {
+	case errors.Is(err, product.ErrInvalidID), errors.Is(err, product.ErrInvalidVariantID):
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case errors.Is(err, product.ErrNotFound), errors.Is(err, product.ErrVariantNotFound):
+		return web.NewRequestError(err, http.StatusNotFound)
+	case errors.Is(err, product.ErrForbidden):
+		return web.NewRequestError(err, http.StatusForbidden)
+	case errors.Is(err, product.ErrDuplicateSKU), errors.Is(err, product.ErrVariantSold),
+		errors.Is(err, product.ErrVariantStocked), errors.Is(err, product.ErrStocked):
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
+
+// Revisions sends the history of the name, cost and quantity of a product.
+func (p *Product) Revisions(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Product.Revisions")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	list, err := product.ListRevisions(ctx, p.DB, claims, chi.URLParam(r, "id"))
+	if err != nil {
+		return revisionError(err, "listing product revisions")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Diff sends the fields changed between the revisions given by the from and
+// to parameters.
+func (p *Product) Diff(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Product.Diff")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	q := r.URL.Query()
+	if q.Get("from") == "" || q.Get("to") == "" {
+		return web.NewRequestError(errors.New("from and to revisions are required"), http.StatusBadRequest)
+	}
+
+	from, err := parseInt(q, "from")
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	to, err := parseInt(q, "to")
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	diff, err := product.DiffRevisions(ctx, p.DB, claims, chi.URLParam(r, "id"), from, to)
+	if err != nil {
+		return revisionError(err, "comparing product revisions")
+	}
+
+	return web.Respond(ctx, w, diff, http.StatusOK)
+}
+
+// Rollback sets a product back to an earlier revision.
+func (p *Product) Rollback(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Product.Rollback")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	version, err := strconv.Atoi(chi.URLParam(r, "version"))
+	if err != nil {
+		return web.NewRequestError(errors.New("revision must be a number"), http.StatusBadRequest)
+	}
+
+	prod, err := product.Rollback(ctx, p.DB, claims, chi.URLParam(r, "id"), version, time.Now())
+	if err != nil {
+		return revisionError(err, "rolling back product")
+	}
+
+	w.Header().Set("ETag", etag(prod.Version))
+	return web.Respond(ctx, w, prod, http.StatusOK)
+}
+
+// revisionError maps errors about product revisions to responses.
+func revisionError(err error, msg string) error {
+	switch {
+	case errors.Is(err, product.ErrInvalidID):
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case errors.Is(err, product.ErrNotFound), errors.Is(err, product.ErrRevisionNotFound):
+		return web.NewRequestError(err, http.StatusNotFound)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
+
+// AddSale records a sale of the product, refusing it when there is not enough
+// stock left.
 func (p *Product) AddSale(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	var newSale product.NewSale
 	if err := web.Decode(r, &newSale); err != nil {
 		return errors.Wrap(err, "decode new sale")
 	}
 
-	productID := chi.URLParam(r, "id")
-	fmt.Println("productID", productID)
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
 
-	sale, err := product.AddSale(ctx, p.DB, newSale, productID, time.Now())
+	productID := chi.URLParam(r, "id")
 
+	sale, err := product.AddSale(ctx, p.DB, claims, newSale, productID, time.Now())
 	if err != nil {
-		return errors.Wrap(err, "add sale")
+		switch {
+		case errors.Is(err, product.ErrInvalidID), errors.Is(err, product.ErrInvalidVariantID), errors.Is(err, product.ErrVariantRequired),
+			errors.Is(err, warehouse.ErrInvalidID), errors.Is(err, warehouse.ErrRequired):
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, product.ErrNotFound), errors.Is(err, product.ErrVariantNotFound), errors.Is(err, warehouse.ErrNotFound):
+			return web.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, product.ErrInsufficientStock), errors.Is(err, warehouse.ErrInsufficientStock):
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrap(err, "add sale")
+		}
 	}
 
 	return web.Respond(ctx, w, sale, http.StatusCreated)
 }
 
 func (p *Product) ListSales(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
 	productID := chi.URLParam(r, "id")
-	list, err := product.ListSales(ctx, p.DB, productID)
+	list, err := product.ListSales(ctx, p.DB, claims, productID)
 	if err != nil {
 		return errors.Wrap(err, " gettinglist sales")
 	}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+// parseInt reads a non-negative integer query parameter. A missing parameter
+// yields zero.
+func parseInt(q url.Values, key string) (int, error) {
+	s := q.Get(key)
+	if s == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		return 0, errors.Errorf("%s must be a non-negative integer", key)
+	}
+	return v, nil
+}
+
+// parseBool reads a boolean query parameter. A missing parameter yields
+// false.
+func parseBool(q url.Values, key string) (bool, error) {
+	s := q.Get(key)
+	if s == "" {
+		return false, nil
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, errors.Errorf("%s must be true or false", key)
+	}
+	return v, nil
+}
+
+// parseTime reads a query parameter given either as RFC 3339 or as a plain
+// date. A missing parameter yields nil.
+func parseTime(q url.Values, key string) (*time.Time, error) {
+	return parseTimeIn(q, key, time.UTC)
+}
+
+// parseTimeIn is like parseTime but reads plain dates as midnight in loc.
+func parseTimeIn(q url.Values, key string, loc *time.Location) (*time.Time, error) {
+	s := q.Get(key)
+	if s == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, errors.Errorf("%s must be an RFC 3339 time or a YYYY-MM-DD date", key)
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/web"
+	"sales_service/internal/report"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/jmoiron/sqlx"
+	"go.opencensus.io/trace"
+)
+
+// Report has methods for building sales reports.
+type Report struct {
+	DB *sqlx.DB
+}
+
+// csvReport is implemented by every report that can be sent as CSV.
+type csvReport interface {
+	CSV() [][]string
+}
+
+// Revenue sends revenue and units sold over time, bucketed by day, week or
+// month in the requested time zone.
+func (rp *Report) Revenue(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Report.Revenue")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	q := r.URL.Query()
+
+	loc := time.UTC
+	if tz := q.Get("tz"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return web.NewRequestError(errors.Errorf("unknown time zone %q", tz), http.StatusBadRequest)
+		}
+	}
+
+	from, to, err := parseRange(r, loc)
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	bucket := q.Get("bucket")
+	if bucket == "" {
+		bucket = report.Day
+	}
+
+	list, err := report.RevenueOverTime(ctx, rp.DB, claims, from, to, bucket, loc)
+	if err != nil {
+		return reportError(err)
+	}
+
+	return respondReport(ctx, w, r, list)
+}
+
+// TopProducts sends the best selling products ranked by revenue or units.
+func (rp *Report) TopProducts(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Report.TopProducts")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	from, to, err := parseRange(r, time.UTC)
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	q := r.URL.Query()
+
+	by := q.Get("by")
+	if by == "" {
+		by = report.Revenue
+	}
+
+	n, err := parseInt(q, "limit")
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	if n == 0 {
+		n = 10
+	}
+
+	list, err := report.TopProducts(ctx, rp.DB, claims, from, to, by, n)
+	if err != nil {
+		return reportError(err)
+	}
+
+	return respondReport(ctx, w, r, list)
+}
+
+// Owners sends revenue and units sold per product owner.
+func (rp *Report) Owners(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Report.Owners")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	from, to, err := parseRange(r, time.UTC)
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	list, err := report.RevenueByOwner(ctx, rp.DB, claims, from, to)
+	if err != nil {
+		return reportError(err)
+	}
+
+	return respondReport(ctx, w, r, list)
+}
+
+// Categories sends revenue and units sold per category, each including its
+// subcategories.
+func (rp *Report) Categories(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Report.Categories")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	from, to, err := parseRange(r, time.UTC)
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	list, err := report.RevenueByCategory(ctx, rp.DB, claims, from, to)
+	if err != nil {
+		return reportError(err)
+	}
+
+	return respondReport(ctx, w, r, list)
+}
+
+// parseRange reads the required from and to parameters of a report.
+func parseRange(r *http.Request, loc *time.Location) (time.Time, time.Time, error) {
+	q := r.URL.Query()
+
+	from, err := parseTimeIn(q, "from", loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to, err := parseTimeIn(q, "to", loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if from == nil || to == nil {
+		return time.Time{}, time.Time{}, errors.New("from and to are required")
+	}
+
+	return *from, *to, nil
+}
+
+// reportError maps errors from the report package to responses.
+func reportError(err error) error {
+	switch {
+	case errors.Is(err, report.ErrInvalidRange),
+		errors.Is(err, report.ErrInvalidBucket),
+		errors.Is(err, report.ErrInvalidMetric):
+		return web.NewRequestError(err, http.StatusBadRequest)
+	default:
+		return errors.Wrap(err, "building report")
+	}
+}
+
+// respondReport sends the report as CSV when asked for with format=csv or an
+// Accept header of text/csv, and as JSON otherwise.
+func respondReport(ctx context.Context, w http.ResponseWriter, r *http.Request, rep csvReport) error {
+	format := r.URL.Query().Get("format")
+	if format == "" && strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		format = "csv"
+	}
+
+	switch format {
+	case "", "json":
+		return web.Respond(ctx, w, rep, http.StatusOK)
+	case "csv":
+		return web.RespondCSV(ctx, w, rep.CSV(), http.StatusOK)
+	default:
+		return web.NewRequestError(errors.New("format must be json or csv"), http.StatusBadRequest)
+	}
+}
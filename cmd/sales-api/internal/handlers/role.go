@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sales_service/internal/platform/web"
+	"sales_service/internal/role"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Roles has handlers for managing roles and their permissions.
+type Roles struct {
+	DB *sqlx.DB
+}
+
+// Permissions sends every permission a role can be granted.
+func (rl *Roles) Permissions(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.role.Permissions")
+	defer span.End()
+
+	perms, err := role.ListPermissions(ctx, rl.DB)
+	if err != nil {
+		return errors.Wrap(err, "listing permissions")
+	}
+
+	return web.Respond(ctx, w, perms, http.StatusOK)
+}
+
+// List sends every role with its permissions.
+func (rl *Roles) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.role.List")
+	defer span.End()
+
+	roles, err := role.List(ctx, rl.DB)
+	if err != nil {
+		return errors.Wrap(err, "listing roles")
+	}
+
+	return web.Respond(ctx, w, roles, http.StatusOK)
+}
+
+// Retrieve sends a single role.
+func (rl *Roles) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.role.Retrieve")
+	defer span.End()
+
+	rol, err := role.Retrieve(ctx, rl.DB, chi.URLParam(r, "name"))
+	if err != nil {
+		return roleError(err, "looking up role")
+	}
+
+	return web.Respond(ctx, w, rol, http.StatusOK)
+}
+
+// Create adds a role.
+func (rl *Roles) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.role.Create")
+	defer span.End()
+
+	var nr role.NewRole
+	if err := web.Decode(r, &nr); err != nil {
+		return errors.Wrap(err, "decode new role")
+	}
+
+	rol, err := role.Create(ctx, rl.DB, nr, time.Now())
+	if err != nil {
+		return roleError(err, "creating role")
+	}
+
+	return web.Respond(ctx, w, rol, http.StatusCreated)
+}
+
+// Update changes the description or the permissions of a role.
+func (rl *Roles) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.role.Update")
+	defer span.End()
+
+	var upd role.UpdateRole
+	if err := web.Decode(r, &upd); err != nil {
+		return errors.Wrap(err, "decode role update")
+	}
+
+	if err := role.Update(ctx, rl.DB, chi.URLParam(r, "name"), upd, time.Now()); err != nil {
+		return roleError(err, "updating role")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Delete removes a role that nobody holds anymore.
+func (rl *Roles) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.role.Delete")
+	defer span.End()
+
+	if err := role.Delete(ctx, rl.DB, chi.URLParam(r, "name")); err != nil {
+		return roleError(err, "deleting role")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// roleError maps errors from the role package to responses.
+func roleError(err error, msg string) error {
+	switch {
+	case errors.Is(err, role.ErrNotFound):
+		return web.NewRequestError(err, http.StatusNotFound)
+	case errors.Is(err, role.ErrUnknownPermission):
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case errors.Is(err, role.ErrExists), errors.Is(err, role.ErrInUse), errors.Is(err, role.ErrBuiltIn):
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
@@ -17,33 +17,180 @@ func API(shutdown chan os.Signal, logger *log.Logger, db *sqlx.DB, authenticator
 	// Create a new web application with the logger
 	app := web.NewApp(shutdown, logger, mid.Logger(logger), mid.Errors(logger), mid.Metrics(), mid.Panics())
 
+	// Every protected route checks the bearer token or API key the same way
+	authenticate := mid.Authenticate(authenticator, db)
+
 	// Create a new Product with the database connection and logger
 	p := &Product{DB: db, Log: logger}
 	c := &Check{DB: db}
+	o := &Order{DB: db}
+	s := &Sale{DB: db}
+	rp := &Report{DB: db}
 
 	u := Users{DB: db, authenticator: authenticator}
-	app.Handle(http.MethodGet, "/v1/users/token", u.Token)
+	j := &JWKS{authenticator: authenticator}
+	k := &APIKeys{DB: db}
+	rl := &Roles{DB: db}
+	og := &Organizations{DB: db}
+	ad := &Audit{DB: db}
+	wh := &Webhooks{DB: db}
+	cg := &Categories{DB: db}
+	ex := &Export{DB: db, Log: logger}
+	wa := &Warehouses{DB: db}
+
+	// Tokens are only issued when the service holds a private key. A
+	// verify-only instance trusts tokens issued by another one.
+	if authenticator.CanSign() {
+		app.Handle(http.MethodGet, "/v1/users/token", u.Token)
+
+		// Exchange a refresh token for a new token pair, and revoke tokens
+		app.Handle(http.MethodPost, "/v1/users/token/refresh", u.Refresh)
+		app.Handle(http.MethodPost, "/v1/users/logout", u.Logout, authenticate)
+
+		// Publish the public keys for services verifying our tokens
+		app.Handle(http.MethodGet, "/.well-known/jwks.json", j.Keys)
+	}
+
+	// Organizations, managed by super-admins
+	app.Handle(http.MethodPost, "/v1/organizations", og.Create, authenticate, mid.RequirePermission(auth.PermOrgManage))
+	app.Handle(http.MethodGet, "/v1/organizations", og.List, authenticate, mid.RequirePermission(auth.PermOrgManage))
+	app.Handle(http.MethodGet, "/v1/organizations/{id}", og.Retrieve, authenticate, mid.RequirePermission(auth.PermOrgManage))
+
+	// Roles and the permissions they grant, shared by every organization
+	app.Handle(http.MethodGet, "/v1/permissions", rl.Permissions, authenticate, mid.RequirePermission(auth.PermRoleManage))
+	app.Handle(http.MethodGet, "/v1/roles", rl.List, authenticate, mid.RequirePermission(auth.PermRoleManage))
+	app.Handle(http.MethodPost, "/v1/roles", rl.Create, authenticate, mid.RequirePermission(auth.PermRoleManage))
+	app.Handle(http.MethodGet, "/v1/roles/{name}", rl.Retrieve, authenticate, mid.RequirePermission(auth.PermRoleManage))
+	app.Handle(http.MethodPut, "/v1/roles/{name}", rl.Update, authenticate, mid.RequirePermission(auth.PermRoleManage))
+	app.Handle(http.MethodDelete, "/v1/roles/{name}", rl.Delete, authenticate, mid.RequirePermission(auth.PermRoleManage))
+
+	// User management. Users with the :any permissions manage everyone,
+	// other users only themselves.
+	app.Handle(http.MethodPost, "/v1/users", u.Create, authenticate, mid.RequirePermission(auth.PermUserCreate))
+	app.Handle(http.MethodGet, "/v1/users", u.List, authenticate, mid.RequirePermission(auth.Any(auth.PermUserRead)))
+	app.Handle(http.MethodGet, "/v1/users/{id}", u.Retrieve, authenticate, mid.RequirePermission(auth.PermUserRead))
+	app.Handle(http.MethodPut, "/v1/users/{id}", u.Update, authenticate, mid.RequirePermission(auth.PermUserWrite))
+	app.Handle(http.MethodPut, "/v1/users/{id}/roles", u.UpdateRoles, authenticate, mid.RequirePermission(auth.PermUserRoles))
+	app.Handle(http.MethodDelete, "/v1/users/{id}", u.Delete, authenticate, mid.RequirePermission(auth.PermUserDelete))
+
+	// API keys for machine-to-machine access. Users manage their own keys,
+	// apikey:manage:any allows managing anyone's and finding keys nobody uses.
+	app.Handle(http.MethodPost, "/v1/users/{id}/apikeys", k.Create, authenticate, mid.RequirePermission(auth.PermAPIKeyManage))
+	app.Handle(http.MethodGet, "/v1/users/{id}/apikeys", k.List, authenticate, mid.RequirePermission(auth.PermAPIKeyManage))
+	app.Handle(http.MethodDelete, "/v1/users/{id}/apikeys/{keyID}", k.Revoke, authenticate, mid.RequirePermission(auth.PermAPIKeyManage))
+	app.Handle(http.MethodGet, "/v1/apikeys/unused", k.Unused, authenticate, mid.RequirePermission(auth.Any(auth.PermAPIKeyManage)))
 
 	// Register routes for retrieving all products
-	app.Handle(http.MethodGet, "/v1/products", p.List, mid.Authenticate(authenticator))
+	app.Handle(http.MethodGet, "/v1/products", p.List, authenticate, mid.RequirePermission(auth.PermProductRead))
+
+	// Search products by name and tags, forgiving typos
+	app.Handle(http.MethodGet, "/v1/products/search", p.Search, authenticate, mid.RequirePermission(auth.PermProductRead))
+
+	// Find the product a scanner read the barcode of
+	app.Handle(http.MethodGet, "/v1/products/by-barcode/{code}", p.ByBarcode, authenticate, mid.RequirePermission(auth.PermProductRead))
 
 	// Register route for retrieving a specific product
-	app.Handle(http.MethodGet, "/v1/products/{id}", p.Retrieve, mid.Authenticate(authenticator))
+	app.Handle(http.MethodGet, "/v1/products/{id}", p.Retrieve, authenticate, mid.RequirePermission(auth.PermProductRead))
 
 	// Register route for creating a new product
-	app.Handle(http.MethodPost, "/v1/products", p.Create, mid.Authenticate(authenticator))
+	app.Handle(http.MethodPost, "/v1/products", p.Create, authenticate, mid.RequirePermission(auth.PermProductCreate))
+
+	// Create or update many products at once from a CSV or NDJSON file
+	app.Handle(http.MethodPost, "/v1/products/import", p.Import, authenticate, mid.RequirePermission(auth.PermProductCreate))
 
 	// Add a new sale to an existing product
-	app.Handle(http.MethodPost, "/v1/products/{id}/sales", p.AddSale, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPost, "/v1/products/{id}/sales", p.AddSale, authenticate, mid.RequirePermission(auth.PermSaleCreate))
 
 	// List all sales for an existing product
-	app.Handle(http.MethodGet, "/v1/products/{id}/sales", p.ListSales, mid.Authenticate(authenticator))
+	app.Handle(http.MethodGet, "/v1/products/{id}/sales", p.ListSales, authenticate, mid.RequirePermission(auth.PermSaleRead))
 
 	// Register route for updating an existing product
-	app.Handle(http.MethodPut, "/v1/products/{id}", p.Update, mid.Authenticate(authenticator))
-
-	// Register route for deleting an existing product
-	app.Handle(http.MethodDelete, "/v1/products/{id}", p.Delete, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPut, "/v1/products/{id}", p.Update, authenticate, mid.RequirePermission(auth.PermProductWrite))
+
+	// Register route for archiving an existing product
+	app.Handle(http.MethodDelete, "/v1/products/{id}", p.Delete, authenticate, mid.RequirePermission(auth.PermProductDelete))
+
+	// A printable label with the barcode of a product
+	app.Handle(http.MethodGet, "/v1/products/{id}/barcode", p.Label, authenticate, mid.RequirePermission(auth.PermProductRead))
+
+	// Prices of a product over time, including scheduled changes
+	app.Handle(http.MethodGet, "/v1/products/{id}/prices", p.Prices, authenticate, mid.RequirePermission(auth.PermProductRead))
+	app.Handle(http.MethodPost, "/v1/products/{id}/prices", p.SchedulePrice, authenticate, mid.RequirePermission(auth.PermProductWrite))
+	app.Handle(http.MethodDelete, "/v1/products/{id}/prices/{priceID}", p.CancelPrice, authenticate, mid.RequirePermission(auth.PermProductWrite))
+
+	// Variants of a product, each with its own SKU, attributes, cost and stock
+	app.Handle(http.MethodGet, "/v1/products/{id}/variants", p.Variants, authenticate, mid.RequirePermission(auth.PermProductRead))
+	app.Handle(http.MethodPost, "/v1/products/{id}/variants", p.AddVariant, authenticate, mid.RequirePermission(auth.PermProductWrite))
+	app.Handle(http.MethodPut, "/v1/products/{id}/variants/{variantID}", p.ModifyVariant, authenticate, mid.RequirePermission(auth.PermProductWrite))
+	app.Handle(http.MethodDelete, "/v1/products/{id}/variants/{variantID}", p.RemoveVariant, authenticate, mid.RequirePermission(auth.PermProductWrite))
+
+	// The history of a product's name, cost and quantity
+	app.Handle(http.MethodGet, "/v1/products/{id}/revisions", p.Revisions, authenticate, mid.RequirePermission(auth.PermProductRead))
+	app.Handle(http.MethodGet, "/v1/products/{id}/revisions/diff", p.Diff, authenticate, mid.RequirePermission(auth.PermProductRead))
+	app.Handle(http.MethodPost, "/v1/products/{id}/revisions/{version}/rollback", p.Rollback, authenticate, mid.RequirePermission(auth.PermProductRollback))
+
+	// Bring back an archived product, or remove the long archived ones for good
+	app.Handle(http.MethodPost, "/v1/products/{id}/restore", p.Restore, authenticate, mid.RequirePermission(auth.PermProductRestore))
+	app.Handle(http.MethodPost, "/v1/products/purge", p.Purge, authenticate, mid.RequirePermission(auth.PermProductPurge))
+
+	// Categories products are browsed by, and the tags in use
+	app.Handle(http.MethodGet, "/v1/categories", cg.List, authenticate, mid.RequirePermission(auth.PermProductRead))
+	app.Handle(http.MethodPost, "/v1/categories", cg.Create, authenticate, mid.RequirePermission(auth.PermCategoryManage))
+	app.Handle(http.MethodGet, "/v1/categories/{id}", cg.Retrieve, authenticate, mid.RequirePermission(auth.PermProductRead))
+	app.Handle(http.MethodPut, "/v1/categories/{id}", cg.Update, authenticate, mid.RequirePermission(auth.PermCategoryManage))
+	app.Handle(http.MethodDelete, "/v1/categories/{id}", cg.Delete, authenticate, mid.RequirePermission(auth.PermCategoryManage))
+	app.Handle(http.MethodGet, "/v1/tags", p.Tags, authenticate, mid.RequirePermission(auth.PermProductRead))
+
+	// Warehouses with the stock kept at each, and transfers between them
+	app.Handle(http.MethodGet, "/v1/warehouses", wa.List, authenticate, mid.RequirePermission(auth.PermProductRead))
+	app.Handle(http.MethodPost, "/v1/warehouses", wa.Create, authenticate, mid.RequirePermission(auth.PermWarehouseManage))
+	app.Handle(http.MethodGet, "/v1/warehouses/transfers", wa.Transfers, authenticate, mid.RequirePermission(auth.PermProductRead))
+	app.Handle(http.MethodPost, "/v1/warehouses/transfers", wa.Ship, authenticate, mid.RequirePermission(auth.PermStockTransfer))
+	app.Handle(http.MethodPost, "/v1/warehouses/transfers/{id}/receive", wa.Receive, authenticate, mid.RequirePermission(auth.PermStockTransfer))
+	app.Handle(http.MethodGet, "/v1/warehouses/{id}", wa.Retrieve, authenticate, mid.RequirePermission(auth.PermProductRead))
+	app.Handle(http.MethodGet, "/v1/warehouses/{id}/stock", wa.Stock, authenticate, mid.RequirePermission(auth.PermProductRead))
+	app.Handle(http.MethodPut, "/v1/warehouses/{id}/stock/{productID}", wa.SetStock, authenticate, mid.RequirePermission(auth.PermWarehouseManage))
+	app.Handle(http.MethodGet, "/v1/products/{id}/stock", wa.ProductStock, authenticate, mid.RequirePermission(auth.PermProductRead))
+
+	// Full dumps of products and sales, streamed as CSV, NDJSON or XLSX
+	app.Handle(http.MethodGet, "/v1/export/products", ex.Products, authenticate, mid.RequirePermission(auth.PermProductRead))
+	app.Handle(http.MethodGet, "/v1/export/sales", ex.Sales, authenticate, mid.RequirePermission(auth.PermSaleRead))
+
+	// Register route for creating an order with many lines
+	app.Handle(http.MethodPost, "/v1/orders", o.Create, authenticate, mid.RequirePermission(auth.PermOrderCreate))
+
+	// List orders
+	app.Handle(http.MethodGet, "/v1/orders", o.List, authenticate, mid.RequirePermission(auth.PermOrderRead))
+
+	// Retrieve a single order with its lines
+	app.Handle(http.MethodGet, "/v1/orders/{id}", o.Retrieve, authenticate, mid.RequirePermission(auth.PermOrderRead))
+
+	// Refund all or part of a sale
+	app.Handle(http.MethodPost, "/v1/sales/{id}/refunds", s.AddRefund, authenticate, mid.RequirePermission(auth.PermSaleRefund))
+
+	// List the refunds of a sale
+	app.Handle(http.MethodGet, "/v1/sales/{id}/refunds", s.ListRefunds, authenticate, mid.RequirePermission(auth.PermSaleRead))
+
+	// Sales reports, available as JSON or CSV
+	app.Handle(http.MethodGet, "/v1/reports/revenue", rp.Revenue, authenticate, mid.RequirePermission(auth.PermReportRead))
+	app.Handle(http.MethodGet, "/v1/reports/top-products", rp.TopProducts, authenticate, mid.RequirePermission(auth.PermReportRead))
+	app.Handle(http.MethodGet, "/v1/reports/owners", rp.Owners, authenticate, mid.RequirePermission(auth.PermReportRead))
+	app.Handle(http.MethodGet, "/v1/reports/categories", rp.Categories, authenticate, mid.RequirePermission(auth.PermReportRead))
+
+	// Who changed what, filtered by actor, resource and time
+	app.Handle(http.MethodGet, "/v1/audit", ad.List, authenticate, mid.RequirePermission(auth.PermAuditRead))
+
+	// Webhooks notified of events of the caller's organization, with the
+	// deliveries that ran out of attempts and a way to try them again
+	app.Handle(http.MethodGet, "/v1/webhooks/events", wh.Events, authenticate, mid.RequirePermission(auth.PermWebhookManage))
+	app.Handle(http.MethodPost, "/v1/webhooks", wh.Create, authenticate, mid.RequirePermission(auth.PermWebhookManage))
+	app.Handle(http.MethodGet, "/v1/webhooks", wh.List, authenticate, mid.RequirePermission(auth.PermWebhookManage))
+	app.Handle(http.MethodGet, "/v1/webhooks/dead-letters", wh.DeadLetters, authenticate, mid.RequirePermission(auth.PermWebhookManage))
+	app.Handle(http.MethodPost, "/v1/webhooks/deliveries/{id}/replay", wh.ReplayDelivery, authenticate, mid.RequirePermission(auth.PermWebhookManage))
+	app.Handle(http.MethodGet, "/v1/webhooks/{id}", wh.Retrieve, authenticate, mid.RequirePermission(auth.PermWebhookManage))
+	app.Handle(http.MethodDelete, "/v1/webhooks/{id}", wh.Delete, authenticate, mid.RequirePermission(auth.PermWebhookManage))
+	app.Handle(http.MethodGet, "/v1/webhooks/{id}/deliveries", wh.Deliveries, authenticate, mid.RequirePermission(auth.PermWebhookManage))
+	app.Handle(http.MethodPost, "/v1/webhooks/{id}/replay", wh.Replay, authenticate, mid.RequirePermission(auth.PermWebhookManage))
 
 	// Register route for checking status of database
 	app.Handle(http.MethodGet, "/v1/health", c.Health)
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/web"
+	"sales_service/internal/product"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-faster/errors"
+	"github.com/jmoiron/sqlx"
+	"go.opencensus.io/trace"
+)
+
+// Sale has methods for dealing with recorded sales.
+type Sale struct {
+	DB *sqlx.DB
+}
+
+// AddRefund gives back all or part of a sale.
+func (s *Sale) AddRefund(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Sale.AddRefund")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("claims missing from request context")
+	}
+
+	var nr product.NewRefund
+	if err := web.Decode(r, &nr); err != nil {
+		return errors.Wrap(err, "decode new refund")
+	}
+
+	saleID := chi.URLParam(r, "id")
+
+	refund, err := product.AddRefund(ctx, s.DB, claims, saleID, nr, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, product.ErrInvalidSaleID), errors.Is(err, product.ErrInvalidRefund):
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, product.ErrSaleNotFound):
+			return web.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, product.ErrRefundTooLarge):
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrap(err, "adding refund")
+		}
+	}
+
+	return web.Respond(ctx, w, refund, http.StatusCreated)
+}
+
+// ListRefunds sends every refund recorded against a sale.
+func (s *Sale) ListRefunds(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Sale.ListRefunds")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	saleID := chi.URLParam(r, "id")
+
+	list, err := product.ListRefunds(ctx, s.DB, claims, saleID)
+	if err != nil {
+		switch {
+		case errors.Is(err, product.ErrInvalidSaleID):
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "listing refunds")
+		}
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
@@ -5,8 +5,11 @@ import (
 
 	"net/http"
 	"sales_service/internal/platform/web"
+	"sales_service/internal/role"
 	"sales_service/internal/user"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"go.opencensus.io/trace"
@@ -14,7 +17,7 @@ import (
 	"sales_service/internal/platform/auth"
 )
 
-// TODO: implement user handler
+// Users has methods for dealing with users and their tokens.
 type Users struct {
 	DB            *sqlx.DB
 	authenticator *auth.Authenticator
@@ -50,15 +53,260 @@ func (u *Users) Token(ctx context.Context, w http.ResponseWriter, r *http.Reques
 	}
 
 	// Generate a JWT token using the authenticator and the user's claims.
-	var tkn struct {
-		Token string `json:"token"`
+	var tkn tokenPair
+
+	tkn.Token, err = u.authenticator.GenerateToken(claims)
+	if err != nil {
+		return errors.Wrap(err, "generating token")
 	}
 
+	// Start a new refresh token family for this login.
+	tkn.RefreshToken, err = user.IssueRefreshToken(ctx, u.DB, claims.Subject, v.Start)
+	if err != nil {
+		return errors.Wrap(err, "issuing refresh token")
+	}
+
+	// Respond with the generated tokens.
+	return web.Respond(ctx, w, tkn, http.StatusOK)
+}
+
+// tokenPair is sent to clients after a successful login or refresh.
+type tokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// refreshRequest carries a refresh token in a request body.
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. The old refresh token cannot be used again.
+func (u *Users) Refresh(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.Refresh")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return errors.New("web value missing from context")
+	}
+
+	var req refreshRequest
+	if err := web.Decode(r, &req); err != nil {
+		return errors.Wrap(err, "decode refresh request")
+	}
+
+	claims, refresh, err := user.Refresh(ctx, u.DB, req.RefreshToken, v.Start)
+	if err != nil {
+		switch {
+		case errors.Is(err, user.ErrInvalidToken), errors.Is(err, user.ErrTokenReused):
+			return web.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return errors.Wrap(err, "refreshing token")
+		}
+	}
+
+	tkn := tokenPair{RefreshToken: refresh}
+
 	tkn.Token, err = u.authenticator.GenerateToken(claims)
 	if err != nil {
 		return errors.Wrap(err, "generating token")
 	}
 
-	// Respond with the generated token.
 	return web.Respond(ctx, w, tkn, http.StatusOK)
 }
+
+// Logout revokes the access token of the request. When the body carries a
+// refresh token, that token and every token rotated from it are revoked too.
+func (u *Users) Logout(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.Logout")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return errors.New("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	// The body is optional.
+	var req refreshRequest
+	if r.ContentLength != 0 {
+		if err := web.Decode(r, &req); err != nil {
+			return errors.Wrap(err, "decode logout request")
+		}
+	}
+
+	if err := user.Logout(ctx, u.DB, claims, req.RefreshToken, v.Start); err != nil {
+		switch {
+		case errors.Is(err, user.ErrInvalidToken):
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "logging out")
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Create adds a new user.
+func (u *Users) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.Create")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	var nu user.NewUser
+	if err := web.Decode(r, &nu); err != nil {
+		return errors.Wrap(err, "decode new user")
+	}
+
+	usr, err := user.Create(ctx, u.DB, claims, nu, time.Now())
+	if err != nil {
+		return userError(err, "creating user")
+	}
+
+	return web.Respond(ctx, w, usr, http.StatusCreated)
+}
+
+// List sends a page of users. The limit parameter is capped at
+// user.MaxLimit.
+func (u *Users) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	q := r.URL.Query()
+	limit, err := parseInt(q, "limit")
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	offset, err := parseInt(q, "offset")
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	users, err := user.List(ctx, u.DB, claims, limit, offset)
+	if err != nil {
+		return errors.Wrap(err, "listing users")
+	}
+
+	return web.Respond(ctx, w, users, http.StatusOK)
+}
+
+// Retrieve sends a single user. Callers holding user:read may only retrieve
+// themselves, those holding user:read:any anyone in their organization.
+func (u *Users) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	usr, err := user.Retrieve(ctx, u.DB, claims, id)
+	if err != nil {
+		return userError(err, "looking up user")
+	}
+
+	return web.Respond(ctx, w, usr, http.StatusOK)
+}
+
+// Update changes the profile of a user. Callers holding user:write may only
+// update themselves, those holding user:write:any anyone in their
+// organization.
+func (u *Users) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.Update")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	var upd user.UpdateUser
+	if err := web.Decode(r, &upd); err != nil {
+		return errors.Wrap(err, "decode update user")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	if err := user.Update(ctx, u.DB, claims, id, upd, time.Now()); err != nil {
+		return userError(err, "updating user")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// UpdateRoles replaces the roles of a user.
+func (u *Users) UpdateRoles(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.UpdateRoles")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	var upd user.UpdateUserRoles
+	if err := web.Decode(r, &upd); err != nil {
+		return errors.Wrap(err, "decode user roles")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	if err := user.UpdateRoles(ctx, u.DB, claims, id, upd, time.Now()); err != nil {
+		return userError(err, "updating user roles")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Delete removes a user.
+func (u *Users) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.user.Delete")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	if err := user.Delete(ctx, u.DB, claims, id, time.Now()); err != nil {
+		return userError(err, "deleting user")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// userError maps errors from the user package to responses.
+func userError(err error, msg string) error {
+	switch {
+	case errors.Is(err, user.ErrNotFound):
+		return web.NewRequestError(err, http.StatusNotFound)
+	case errors.Is(err, user.ErrInvalidID), errors.Is(err, user.ErrPasswordConfirm), errors.Is(err, role.ErrUnknownRole),
+		errors.Is(err, user.ErrUnknownOrg):
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case errors.Is(err, user.ErrForbidden):
+		return web.NewRequestError(err, http.StatusForbidden)
+	case errors.Is(err, user.ErrEmailTaken):
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
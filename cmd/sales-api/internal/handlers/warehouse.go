@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/web"
+	"sales_service/internal/warehouse"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-faster/errors"
+	"github.com/jmoiron/sqlx"
+	"go.opencensus.io/trace"
+)
+
+// Warehouses has handlers for the locations products are stocked at and the
+// transfers between them.
+type Warehouses struct {
+	DB *sqlx.DB
+}
+
+// List sends every warehouse of the caller's organization.
+func (wa *Warehouses) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Warehouses.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	list, err := warehouse.List(ctx, wa.DB, claims)
+	if err != nil {
+		return errors.Wrap(err, "listing warehouses")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Retrieve sends a single warehouse.
+func (wa *Warehouses) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Warehouses.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	wh, err := warehouse.Retrieve(ctx, wa.DB, claims, chi.URLParam(r, "id"))
+	if err != nil {
+		return warehouseError(err, "retrieving warehouse")
+	}
+
+	return web.Respond(ctx, w, wh, http.StatusOK)
+}
+
+// Create adds a warehouse.
+func (wa *Warehouses) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Warehouses.Create")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	var nw warehouse.NewWarehouse
+	if err := web.Decode(r, &nw); err != nil {
+		return errors.Wrap(err, "decode new warehouse")
+	}
+
+	wh, err := warehouse.Create(ctx, wa.DB, claims, nw, time.Now())
+	if err != nil {
+		return warehouseError(err, "creating warehouse")
+	}
+
+	return web.Respond(ctx, w, wh, http.StatusCreated)
+}
+
+// Stock sends the stock of every product kept at a warehouse.
+func (wa *Warehouses) Stock(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Warehouses.Stock")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	list, err := warehouse.ListStock(ctx, wa.DB, claims, chi.URLParam(r, "id"))
+	if err != nil {
+		return warehouseError(err, "listing stock")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// SetStock records the units of a product counted at a warehouse.
+func (wa *Warehouses) SetStock(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Warehouses.SetStock")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	var c warehouse.Count
+	if err := web.Decode(r, &c); err != nil {
+		return errors.Wrap(err, "decode stock count")
+	}
+
+	l, err := warehouse.SetStock(ctx, wa.DB, claims, chi.URLParam(r, "id"), chi.URLParam(r, "productID"), c, time.Now())
+	if err != nil {
+		return warehouseError(err, "setting stock")
+	}
+
+	return web.Respond(ctx, w, l, http.StatusOK)
+}
+
+// ProductStock sends the stock of a product at every warehouse and in total.
+func (wa *Warehouses) ProductStock(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Warehouses.ProductStock")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	s, err := warehouse.ProductStock(ctx, wa.DB, claims, chi.URLParam(r, "id"))
+	if err != nil {
+		return warehouseError(err, "retrieving product stock")
+	}
+
+	return web.Respond(ctx, w, s, http.StatusOK)
+}
+
+// Transfers sends the transfers of the caller's organization, only those
+// with the status given by the status parameter if any.
+func (wa *Warehouses) Transfers(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Warehouses.Transfers")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	list, err := warehouse.ListTransfers(ctx, wa.DB, claims, r.URL.Query().Get("status"))
+	if err != nil {
+		return warehouseError(err, "listing transfers")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Ship sends stock from one warehouse to another.
+func (wa *Warehouses) Ship(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Warehouses.Ship")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	var nt warehouse.NewTransfer
+	if err := web.Decode(r, &nt); err != nil {
+		return errors.Wrap(err, "decode new transfer")
+	}
+
+	t, err := warehouse.Ship(ctx, wa.DB, claims, nt, time.Now())
+	if err != nil {
+		return warehouseError(err, "shipping transfer")
+	}
+
+	return web.Respond(ctx, w, t, http.StatusCreated)
+}
+
+// Receive puts the stock of a shipped transfer into its destination.
+func (wa *Warehouses) Receive(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Warehouses.Receive")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	t, err := warehouse.Receive(ctx, wa.DB, claims, chi.URLParam(r, "id"), time.Now())
+	if err != nil {
+		return warehouseError(err, "receiving transfer")
+	}
+
+	return web.Respond(ctx, w, t, http.StatusOK)
+}
+
+// warehouseError maps errors from the warehouse package to responses.
+func warehouseError(err error, msg string) error {
+	switch {
+	case errors.Is(err, warehouse.ErrInvalidID), errors.Is(err, warehouse.ErrInvalidProductID),
+		errors.Is(err, warehouse.ErrInvalidTransferID), errors.Is(err, warehouse.ErrInvalidStatus),
+		errors.Is(err, warehouse.ErrInvalidVariantID), errors.Is(err, warehouse.ErrVariantRequired):
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case errors.Is(err, warehouse.ErrNotFound), errors.Is(err, warehouse.ErrProductNotFound),
+		errors.Is(err, warehouse.ErrTransferNotFound), errors.Is(err, warehouse.ErrVariantNotFound):
+		return web.NewRequestError(err, http.StatusNotFound)
+	case errors.Is(err, warehouse.ErrDuplicateName), errors.Is(err, warehouse.ErrInsufficientStock),
+		errors.Is(err, warehouse.ErrAlreadyReceived):
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sales_service/internal/event"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/web"
+	"sales_service/internal/webhook"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Webhooks has handlers for webhook subscriptions and their deliveries.
+type Webhooks struct {
+	DB *sqlx.DB
+}
+
+// Events sends the types of events a webhook can subscribe to.
+func (wh *Webhooks) Events(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Webhooks.Events")
+	defer span.End()
+
+	return web.Respond(ctx, w, event.Types, http.StatusOK)
+}
+
+// Create subscribes a URL to events. The signing secret is only sent in this
+// response.
+func (wh *Webhooks) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Webhooks.Create")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	var ns webhook.NewSubscription
+	if err := web.Decode(r, &ns); err != nil {
+		return errors.Wrap(err, "decode new webhook")
+	}
+
+	s, err := webhook.Create(ctx, wh.DB, claims, ns, time.Now())
+	if err != nil {
+		return webhookError(err, "creating webhook")
+	}
+
+	return web.Respond(ctx, w, s, http.StatusCreated)
+}
+
+// List sends every webhook of the caller's organization.
+func (wh *Webhooks) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Webhooks.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	list, err := webhook.List(ctx, wh.DB, claims)
+	if err != nil {
+		return errors.Wrap(err, "listing webhooks")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Retrieve sends a single webhook.
+func (wh *Webhooks) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Webhooks.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	s, err := webhook.Retrieve(ctx, wh.DB, claims, chi.URLParam(r, "id"))
+	if err != nil {
+		return webhookError(err, "retrieving webhook")
+	}
+
+	return web.Respond(ctx, w, s, http.StatusOK)
+}
+
+// Delete removes a webhook and its deliveries.
+func (wh *Webhooks) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Webhooks.Delete")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	if err := webhook.Delete(ctx, wh.DB, claims, chi.URLParam(r, "id")); err != nil {
+		return webhookError(err, "deleting webhook")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Deliveries sends the latest deliveries of a webhook, optionally only those
+// with the status given by the status parameter.
+func (wh *Webhooks) Deliveries(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Webhooks.Deliveries")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	q := r.URL.Query()
+
+	limit, err := parseInt(q, "limit")
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	offset, err := parseInt(q, "offset")
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	list, err := webhook.ListDeliveries(ctx, wh.DB, claims, chi.URLParam(r, "id"), q.Get("status"), limit, offset)
+	if err != nil {
+		return webhookError(err, "listing deliveries")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// DeadLetters sends the deliveries of every webhook that ran out of
+// attempts.
+func (wh *Webhooks) DeadLetters(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Webhooks.DeadLetters")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	q := r.URL.Query()
+
+	limit, err := parseInt(q, "limit")
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+	offset, err := parseInt(q, "offset")
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	list, err := webhook.ListFailed(ctx, wh.DB, claims, limit, offset)
+	if err != nil {
+		return errors.Wrap(err, "listing failed deliveries")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Replay schedules every failed delivery of a webhook again and sends how
+// many were scheduled.
+func (wh *Webhooks) Replay(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Webhooks.Replay")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	n, err := webhook.Replay(ctx, wh.DB, claims, chi.URLParam(r, "id"), time.Now())
+	if err != nil {
+		return webhookError(err, "replaying deliveries")
+	}
+
+	resp := struct {
+		Replayed int `json:"replayed"`
+	}{n}
+
+	return web.Respond(ctx, w, resp, http.StatusAccepted)
+}
+
+// ReplayDelivery schedules a single failed delivery again.
+func (wh *Webhooks) ReplayDelivery(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.Webhooks.ReplayDelivery")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from request context")
+	}
+
+	if err := webhook.ReplayDelivery(ctx, wh.DB, claims, chi.URLParam(r, "id"), time.Now()); err != nil {
+		return webhookError(err, "replaying delivery")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// webhookError maps errors from the webhook package to responses.
+func webhookError(err error, msg string) error {
+	switch {
+	case errors.Is(err, webhook.ErrNotFound), errors.Is(err, webhook.ErrDeliveryNotFound):
+		return web.NewRequestError(err, http.StatusNotFound)
+	case errors.Is(err, webhook.ErrInvalidID), errors.Is(err, webhook.ErrInvalidURL),
+		errors.Is(err, webhook.ErrUnknownHost), errors.Is(err, webhook.ErrPrivateURL),
+		errors.Is(err, webhook.ErrUnknownEvent), errors.Is(err, webhook.ErrInvalidStatus):
+		return web.NewRequestError(err, http.StatusBadRequest)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
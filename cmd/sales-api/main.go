@@ -12,12 +12,16 @@ import (
 	"sales_service/cmd/sales-api/internal/handlers"
 	"sales_service/internal/platform/auth"
 	"sales_service/internal/platform/database"
+	"sales_service/internal/product"
+	"sales_service/internal/webhook"
 	"syscall"
 	"time"
+	_ "time/tzdata" // embed time zones for reports
 
 	"contrib.go.opencensus.io/exporter/zipkin"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/go-faster/errors"
+	"github.com/jmoiron/sqlx"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 	openzipkin "github.com/openzipkin/zipkin-go"
@@ -50,6 +54,8 @@ func run() error {
 		}
 		Auth struct {
 			PrivateKeyFile string
+			KeysDir        string
+			JWKSURL        string
 			KeyID          string
 			Algorithm      string
 		}
@@ -58,6 +64,9 @@ func run() error {
 			Service     string
 			Probability float64
 		}
+		Webhook struct {
+			PollInterval time.Duration
+		}
 	}
 	log.Println("started")
 	defer log.Println("finished")
@@ -80,15 +89,47 @@ func run() error {
 	cfg.Auth.Algorithm = viper.GetString("auth.algorithm")
 	cfg.Auth.KeyID = viper.GetString("auth.keyID")
 	cfg.Auth.PrivateKeyFile = viper.GetString("auth.privateKeyFile")
+	cfg.Auth.KeysDir = viper.GetString("auth.keysDir")
+	cfg.Auth.JWKSURL = viper.GetString("auth.jwksURL")
 
-	authenticator, err := createAuth(
-		cfg.Auth.PrivateKeyFile,
-		cfg.Auth.KeyID,
-		cfg.Auth.Algorithm,
-	)
+	var authenticator *auth.Authenticator
+	var err error
 
-	if err != nil {
-		return errors.Wrap(err, "error creating authenticator")
+	// A JWKS URL makes this a verify-only instance trusting the keys of
+	// another one. A keys directory enables key rotation; otherwise a single
+	// key is used.
+	switch {
+	case cfg.Auth.JWKSURL != "":
+		client := http.Client{Timeout: 10 * time.Second}
+		lookup := auth.NewJWKSKeyLookupFunc(cfg.Auth.JWKSURL, &client, 15*time.Minute)
+
+		authenticator, err = auth.NewVerifier(cfg.Auth.Algorithm, lookup)
+		if err != nil {
+			return errors.Wrap(err, "error creating verifier")
+		}
+
+	case cfg.Auth.KeysDir != "":
+		ring, err := auth.NewKeyRing(cfg.Auth.KeysDir, cfg.Auth.KeyID)
+		if err != nil {
+			return errors.Wrap(err, "error loading keys")
+		}
+
+		go reloadKeys(log, ring)
+
+		authenticator, err = auth.NewKeyRingAuthenticator(ring, cfg.Auth.Algorithm)
+		if err != nil {
+			return errors.Wrap(err, "error creating authenticator")
+		}
+
+	default:
+		authenticator, err = createAuth(
+			cfg.Auth.PrivateKeyFile,
+			cfg.Auth.KeyID,
+			cfg.Auth.Algorithm,
+		)
+		if err != nil {
+			return errors.Wrap(err, "error creating authenticator")
+		}
 	}
 
 	db, err := database.OpenDB(database.Config{
@@ -128,6 +169,20 @@ func run() error {
 	// cfg.Web.Address = viper.GetString("web.address")
 	cfg.Web.Debug = viper.GetString("web.debug")
 
+	// Deliver the events of the outbox to webhooks until shutdown
+	cfg.Webhook.PollInterval = viper.GetDuration("webhook.pollinterval")
+	if cfg.Webhook.PollInterval <= 0 {
+		cfg.Webhook.PollInterval = 5 * time.Second
+	}
+
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	go webhook.NewDispatcher(db, log).Run(background, cfg.Webhook.PollInterval)
+
+	// Apply scheduled price changes as they become due
+	go applyPrices(background, log, db, time.Minute)
+
 	// start Debug Service
 	go func() {
 		log.Printf("Debug service started on %s", cfg.Web.Debug)
@@ -206,6 +261,52 @@ func createAuth(privateKeyFile, keyID, algorithm string) (*auth.Authenticator, e
 	return auth.NewAuthenticator(key, keyID, algorithm, public)
 }
 
+// reloadKeys reloads the key ring every time the process receives SIGHUP.
+// The active key ID is read again from the configuration, so keys can be
+// rotated without a restart.
+func reloadKeys(log *log.Logger, ring *auth.KeyRing) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		if err := viper.ReadInConfig(); err != nil {
+			log.Printf("reloading keys: reading config: %v", err)
+			continue
+		}
+
+		kid := viper.GetString("auth.keyID")
+		if err := ring.Load(kid); err != nil {
+			log.Printf("reloading keys: %v", err)
+			continue
+		}
+
+		log.Printf("keys reloaded, signing with key %q", kid)
+	}
+}
+
+// applyPrices brings product costs in line with their scheduled prices every
+// interval until ctx is cancelled.
+func applyPrices(ctx context.Context, log *log.Logger, db *sqlx.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := product.ApplyPrices(ctx, db, time.Now())
+		switch {
+		case err != nil && ctx.Err() == nil:
+			log.Printf("applying prices: %v", err)
+		case n > 0:
+			log.Printf("applied %d scheduled prices", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // registerTracer registers a Zipkin tracer with the provided service name, HTTP address, trace URL, and probability of sampling.
 // It returns a function to close the tracer and any error encountered.
 func registerTracer(service, httpAddr, traceURL string, probability float64) (func() error, error) {
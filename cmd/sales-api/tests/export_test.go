@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sales_service/cmd/sales-api/internal/handlers"
+	"sales_service/internal/organization"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/database/databasetest"
+	"sales_service/internal/schema"
+	"testing"
+	"time"
+)
+
+// TestEmptyExport checks that an export without any row is still sent as a
+// download.
+func TestEmptyExport(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	if err := schema.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+	log := log.New(os.Stderr, "TEST : ", log.LstdFlags|log.Lshortfile)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator, err := auth.NewAuthenticator(key, "1", "RS256", auth.NewSimpleKeyLookupFunc("1", &key.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin, auth.RoleUser}, time.Now(), time.Hour)
+	token, err := authenticator.GenerateToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shutdown := make(chan os.Signal, 1)
+	app := handlers.API(shutdown, log, db, authenticator)
+
+	req := httptest.NewRequest("GET", "/v1/export/sales?format=ndjson&from=2030-01-01", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+
+	app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected %d, actual %d: %s", http.StatusOK, resp.Code, resp.Body)
+	}
+	if ct := resp.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("expected an NDJSON response, got %q", ct)
+	}
+	if cd := resp.Header().Get("Content-Disposition"); cd != `attachment; filename="sales.ndjson"` {
+		t.Fatalf("expected a download, got %q", cd)
+	}
+	if resp.Body.Len() != 0 {
+		t.Fatalf("expected no rows, got %q", resp.Body)
+	}
+}
@@ -1,6 +1,8 @@
 package tests
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -8,10 +10,13 @@ import (
 	"net/http/httptest"
 	"os"
 	"sales_service/cmd/sales-api/internal/handlers"
+	"sales_service/internal/organization"
+	"sales_service/internal/platform/auth"
 	"sales_service/internal/platform/database/databasetest"
 	"sales_service/internal/schema"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -25,19 +30,41 @@ func TestProducts(t *testing.T) {
 	}
 	log := log.New(os.Stderr, "TEST : ", log.LstdFlags|log.Lshortfile)
 
-	tests := ProductTests{app: handlers.API(log, db)}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator, err := auth.NewAuthenticator(key, "1", "RS256", auth.NewSimpleKeyLookupFunc("1", &key.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin, auth.RoleUser}, time.Now(), time.Hour)
+	token, err := authenticator.GenerateToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shutdown := make(chan os.Signal, 1)
+	tests := ProductTests{
+		app:   handlers.API(shutdown, log, db, authenticator),
+		token: token,
+	}
 
 	t.Run("List", tests.List)
 	t.Run("ProductCRUD", tests.ProductCRUD)
 }
 
 type ProductTests struct {
-	app http.Handler
+	app   http.Handler
+	token string
 }
 
 func (p ProductTests) List(t *testing.T) {
 
 	req := httptest.NewRequest("GET", "/v1/products", nil)
+	req.Header.Set("Authorization", "Bearer "+p.token)
 	resp := httptest.NewRecorder()
 
 	p.app.ServeHTTP(resp, req)
@@ -46,30 +73,49 @@ func (p ProductTests) List(t *testing.T) {
 		t.Fatalf("expected %d, actual %d", http.StatusOK, resp.Code)
 	}
 
-	var list []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+	var page map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
 		t.Fatalf("decoding: %s", err)
 	}
 
-	want := []map[string]interface{}{
-		{
-			"id":           "a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a21",
-			"name":         "Lego City",
-			"cost":         float64(3000),
-			"quantity":     float64(56),
-			"date_created": "2024-05-05T12:12:12Z",
-			"date_updated": "2024-05-06T14:15:12Z",
-		},
-		{
-			"id":           "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11",
-			"name":         "Lego Chima",
-			"cost":         float64(2000),
-			"quantity":     float64(50),
-			"date_created": "2024-05-05T12:12:12Z",
-			"date_updated": "2024-05-06T14:15:12Z",
+	want := map[string]interface{}{
+		"total": float64(2),
+		"items": []interface{}{
+			map[string]interface{}{
+				"id":           "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11",
+				"name":         "Lego Chima",
+				"cost":         float64(2000),
+				"quantity":     float64(50),
+				"sold":         float64(1),
+				"revenue":      float64(2000),
+				"available":    float64(49),
+				"user_id":      "00000000-0000-0000-0000-000000000000",
+				"org_id":       organization.DefaultID,
+				"category_id":  nil,
+				"tags":         []interface{}{},
+				"version":      float64(1),
+				"date_created": "2024-05-05T12:12:12Z",
+				"date_updated": "2024-05-06T14:15:12Z",
+			},
+			map[string]interface{}{
+				"id":           "a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a21",
+				"name":         "Lego City",
+				"cost":         float64(3000),
+				"quantity":     float64(56),
+				"sold":         float64(3),
+				"revenue":      float64(9000),
+				"available":    float64(53),
+				"user_id":      "00000000-0000-0000-0000-000000000000",
+				"org_id":       organization.DefaultID,
+				"category_id":  nil,
+				"tags":         []interface{}{},
+				"version":      float64(1),
+				"date_created": "2024-05-05T12:12:12Z",
+				"date_updated": "2024-05-06T14:15:12Z",
+			},
 		},
 	}
-	if diff := cmp.Diff(want, list); diff != "" {
+	if diff := cmp.Diff(want, page); diff != "" {
 		t.Fatalf("mismatch (-want +got):\n%s", diff)
 	}
 }
@@ -82,6 +128,7 @@ func (p ProductTests) ProductCRUD(t *testing.T) {
 
 		req := httptest.NewRequest("POST", "/v1/products", body)
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+p.token)
 		resp := httptest.NewRecorder()
 
 		p.app.ServeHTTP(resp, req)
@@ -111,6 +158,14 @@ func (p ProductTests) ProductCRUD(t *testing.T) {
 			"name":         "test product3",
 			"cost":         float64(55),
 			"quantity":     float64(20),
+			"sold":         float64(0),
+			"revenue":      float64(0),
+			"available":    float64(20),
+			"user_id":      "a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03",
+			"org_id":       organization.DefaultID,
+			"category_id":  nil,
+			"tags":         []interface{}{},
+			"version":      float64(1),
 			"date_created": created["date_created"],
 			"date_updated": created["date_updated"],
 		}
@@ -124,6 +179,7 @@ func (p ProductTests) ProductCRUD(t *testing.T) {
 		url := fmt.Sprintf("/v1/products/%s", created["id"])
 		req := httptest.NewRequest("GET", url, nil)
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+p.token)
 		resp := httptest.NewRecorder()
 
 		p.app.ServeHTTP(resp, req)
@@ -140,5 +196,54 @@ func (p ProductTests) ProductCRUD(t *testing.T) {
 		if diff := cmp.Diff(created, got); diff != "" {
 			t.Fatalf("mismatch (-want +got):\n%s", diff)
 		}
+
+		if tag := resp.Header().Get("ETag"); tag != `"1"` {
+			t.Fatalf("expected ETag %q, actual %q", `"1"`, tag)
+		}
+	}
+
+	url := fmt.Sprintf("/v1/products/%s", created["id"])
+
+	// A client holding the current version gets nothing back.
+	{
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer "+p.token)
+		req.Header.Set("If-None-Match", `"1"`)
+		resp := httptest.NewRecorder()
+
+		p.app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusNotModified {
+			t.Fatalf("expected %d, actual %d", http.StatusNotModified, resp.Code)
+		}
+		if resp.Body.Len() != 0 {
+			t.Fatalf("expected no body, got %q", resp.Body.String())
+		}
+	}
+
+	// Updates need the version they are based on, and only the current one
+	// is accepted.
+	for _, c := range []struct {
+		ifMatch string
+		want    int
+	}{
+		{"", http.StatusPreconditionRequired},
+		{`"2"`, http.StatusPreconditionFailed},
+		{`"1"`, http.StatusNoContent},
+		{`"1"`, http.StatusPreconditionFailed},
+	} {
+		req := httptest.NewRequest("PUT", url, strings.NewReader(`{"cost": 60}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+p.token)
+		if c.ifMatch != "" {
+			req.Header.Set("If-Match", c.ifMatch)
+		}
+		resp := httptest.NewRecorder()
+
+		p.app.ServeHTTP(resp, req)
+
+		if resp.Code != c.want {
+			t.Fatalf("If-Match %q: expected %d, actual %d", c.ifMatch, c.want, resp.Code)
+		}
 	}
 }
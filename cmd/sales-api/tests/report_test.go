@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sales_service/cmd/sales-api/internal/handlers"
+	"sales_service/internal/organization"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/database/databasetest"
+	"sales_service/internal/schema"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReports(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	if err := schema.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+	log := log.New(os.Stderr, "TEST : ", log.LstdFlags|log.Lshortfile)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator, err := auth.NewAuthenticator(key, "1", "RS256", auth.NewSimpleKeyLookupFunc("1", &key.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin, auth.RoleUser}, time.Now(), time.Hour)
+	token, err := authenticator.GenerateToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shutdown := make(chan os.Signal, 1)
+	tests := ReportTests{
+		app:   handlers.API(shutdown, log, db, authenticator),
+		token: token,
+	}
+
+	t.Run("RevenueCSV", tests.RevenueCSV)
+	t.Run("TopProductsCSV", tests.TopProductsCSV)
+}
+
+type ReportTests struct {
+	app   http.Handler
+	token string
+}
+
+// get requests a report and checks it was sent as CSV.
+func (rt ReportTests) get(t *testing.T, target, accept string) string {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", target, nil)
+	req.Header.Set("Authorization", "Bearer "+rt.token)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp := httptest.NewRecorder()
+
+	rt.app.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected %d, actual %d: %s", http.StatusOK, resp.Code, resp.Body)
+	}
+	if ct := resp.Header().Get("Content-Type"); ct != "text/csv;charset=utf-8" {
+		t.Fatalf("expected a CSV response, got %q", ct)
+	}
+
+	return resp.Body.String()
+}
+
+func (rt ReportTests) RevenueCSV(t *testing.T) {
+	got := rt.get(t, "/v1/reports/revenue?from=2024-05-04&to=2024-05-07&format=csv", "")
+
+	want := "period,revenue,discount,units\n" +
+		"2024-05-04T00:00:00Z,0,0,0\n" +
+		"2024-05-05T00:00:00Z,11000,0,4\n" +
+		"2024-05-06T00:00:00Z,0,0,0\n"
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func (rt ReportTests) TopProductsCSV(t *testing.T) {
+	got := rt.get(t, "/v1/reports/top-products?from=2024-05-04&to=2024-05-07", "text/csv")
+
+	want := "product_id,name,revenue,discount,units\n" +
+		"a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a21,Lego City,9000,0,3\n" +
+		"a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11,Lego Chima,2000,0,1\n"
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+}
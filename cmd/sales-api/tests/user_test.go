@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sales_service/cmd/sales-api/internal/handlers"
+	"sales_service/internal/organization"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/database/databasetest"
+	"sales_service/internal/schema"
+	"testing"
+	"time"
+)
+
+// TestLogout checks that an access token is refused by every authenticated
+// route once it was used to log out, although it has not expired.
+func TestLogout(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	if err := schema.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+	log := log.New(os.Stderr, "TEST : ", log.LstdFlags|log.Lshortfile)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator, err := auth.NewAuthenticator(key, "1", "RS256", auth.NewSimpleKeyLookupFunc("1", &key.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const userID = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a04"
+
+	claims := auth.NewClaims(userID, organization.DefaultID, []string{auth.RoleUser}, time.Now(), time.Hour)
+	token, err := authenticator.GenerateToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shutdown := make(chan os.Signal, 1)
+	app := handlers.API(shutdown, log, db, authenticator)
+
+	send := func(method, target string, want int) {
+		t.Helper()
+
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+
+		app.ServeHTTP(resp, req)
+
+		if resp.Code != want {
+			t.Fatalf("%s %s: expected %d, actual %d", method, target, want, resp.Code)
+		}
+	}
+
+	send("GET", "/v1/users/"+userID, http.StatusOK)
+	send("POST", "/v1/users/logout", http.StatusNoContent)
+	send("GET", "/v1/users/"+userID, http.StatusUnauthorized)
+}
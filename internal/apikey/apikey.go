@@ -0,0 +1,231 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/role"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// secretPrefix marks API key secrets so they are easy to recognise and
+// cannot be mistaken for a JWT.
+const secretPrefix = "sk_"
+
+// lastUsedResolution limits how often using a key writes its last used time.
+const lastUsedResolution = time.Minute
+
+var (
+	ErrNotFound      = errors.New("api key not found")
+	ErrInvalidID     = errors.New("invalid api key ID format")
+	ErrForbidden     = errors.New("action not allowed")
+	ErrUserNotFound  = errors.New("user not found")
+	ErrRoleNotHeld   = errors.New("api key roles must be held by its owner and within the caller's rights")
+	ErrInvalidSecret = errors.New("api key is invalid or revoked")
+)
+
+// selectKeys lists the key columns in the order Key declares them.
+const selectKeys = `SELECT key_id, user_id, name, prefix, roles, date_created, date_last_used, date_revoked FROM api_keys`
+
+// Create issues a new API key for the user. Callers need the apikey:manage
+// permission for themselves or for any user. A key never carries roles its
+// owner does not have, nor rights beyond the caller's own, so a key cannot be
+// used to mint a more powerful one.
+func Create(ctx context.Context, db *sqlx.DB, claims auth.Claims, userID string, nk NewKey, now time.Time) (*Issued, error) {
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if !claims.Can(auth.PermAPIKeyManage, userID) {
+		return nil, ErrForbidden
+	}
+
+	var owned pq.StringArray
+
+	const q1 = `SELECT roles FROM users WHERE user_id = $1 AND ($2::UUID IS NULL OR org_id = $2)`
+	if err := db.GetContext(ctx, &owned, q1, userID, claims.OrgScope()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, errors.Wrap(err, "selecting owner roles")
+	}
+
+	for _, r := range nk.Roles {
+		if !contains(owned, r) {
+			return nil, ErrRoleNotHeld
+		}
+	}
+	within, err := role.Within(ctx, db, claims, nk.Roles)
+	if err != nil {
+		return nil, err
+	}
+	if !within {
+		return nil, ErrRoleNotHeld
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errors.Wrap(err, "generating api key")
+	}
+	secret := secretPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	k := Issued{
+		Key: Key{
+			ID:          uuid.New().String(),
+			UserID:      userID,
+			Name:        nk.Name,
+			Prefix:      secret[:len(secretPrefix)+8],
+			Roles:       nk.Roles,
+			DateCreated: now.UTC(),
+		},
+		Secret: secret,
+	}
+
+	const q = `INSERT INTO api_keys (key_id, user_id, name, prefix, key_hash, roles, date_created)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = db.ExecContext(ctx, q, k.ID, k.UserID, k.Name, k.Prefix, hashSecret(secret), k.Roles, k.DateCreated)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting api key")
+	}
+
+	return &k, nil
+}
+
+// List gives every key of a user, including revoked ones. Callers need the
+// apikey:manage permission for themselves or for any user.
+func List(ctx context.Context, db *sqlx.DB, claims auth.Claims, userID string) ([]Key, error) {
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if !claims.Can(auth.PermAPIKeyManage, userID) {
+		return nil, ErrForbidden
+	}
+
+	keys := []Key{}
+
+	const q = selectKeys + ` WHERE user_id = $1
+	AND user_id IN (SELECT user_id FROM users WHERE $2::UUID IS NULL OR org_id = $2)
+	ORDER BY date_created`
+	if err := db.SelectContext(ctx, &keys, q, userID, claims.OrgScope()); err != nil {
+		return nil, errors.Wrap(err, "selecting api keys")
+	}
+
+	return keys, nil
+}
+
+// ListUnused gives every active key of any user of the caller's organization
+// that has not been used since the given time, so stale keys can be found and
+// revoked.
+func ListUnused(ctx context.Context, db *sqlx.DB, claims auth.Claims, since time.Time) ([]Key, error) {
+	keys := []Key{}
+
+	const q = selectKeys + ` WHERE date_revoked IS NULL
+	AND COALESCE(date_last_used, date_created) < $1
+	AND user_id IN (SELECT user_id FROM users WHERE $2::UUID IS NULL OR org_id = $2)
+	ORDER BY COALESCE(date_last_used, date_created)`
+	if err := db.SelectContext(ctx, &keys, q, since.UTC(), claims.OrgScope()); err != nil {
+		return nil, errors.Wrap(err, "selecting unused api keys")
+	}
+
+	return keys, nil
+}
+
+// Revoke disables a key of the user. Callers need the apikey:manage
+// permission for themselves or for any user.
+func Revoke(ctx context.Context, db *sqlx.DB, claims auth.Claims, userID, keyID string, now time.Time) error {
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrInvalidID
+	}
+	if _, err := uuid.Parse(keyID); err != nil {
+		return ErrInvalidID
+	}
+	if !claims.Can(auth.PermAPIKeyManage, userID) {
+		return ErrForbidden
+	}
+
+	const q = `UPDATE api_keys SET date_revoked = COALESCE(date_revoked, $1)
+	WHERE key_id = $2 AND user_id = $3
+	AND user_id IN (SELECT user_id FROM users WHERE $4::UUID IS NULL OR org_id = $4)`
+
+	res, err := db.ExecContext(ctx, q, now.UTC(), keyID, userID, claims.OrgScope())
+	if err != nil {
+		return errors.Wrap(err, "revoking api key")
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Authenticate finds the active key with the given secret and returns claims
+// for its owner. The claims only carry the key's roles that the owner still
+// holds.
+func Authenticate(ctx context.Context, db *sqlx.DB, secret string, now time.Time) (auth.Claims, error) {
+	if !strings.HasPrefix(secret, secretPrefix) {
+		return auth.Claims{}, ErrInvalidSecret
+	}
+
+	var k struct {
+		Key
+		Owned pq.StringArray `db:"owned"`
+		OrgID string         `db:"org_id"`
+	}
+
+	const q = `SELECT k.key_id, k.user_id, k.name, k.prefix, k.roles, k.date_created,
+	k.date_last_used, k.date_revoked, u.roles AS owned, u.org_id
+	FROM api_keys AS k
+	JOIN users AS u ON u.user_id = k.user_id
+	WHERE k.key_hash = $1 AND k.date_revoked IS NULL`
+
+	if err := db.GetContext(ctx, &k, q, hashSecret(secret)); err != nil {
+		if err == sql.ErrNoRows {
+			return auth.Claims{}, ErrInvalidSecret
+		}
+		return auth.Claims{}, errors.Wrap(err, "selecting api key")
+	}
+
+	// Record the use, but only once per lastUsedResolution so busy keys do
+	// not cause a write on every request.
+	if k.DateLastUsed == nil || now.Sub(*k.DateLastUsed) >= lastUsedResolution {
+		const used = `UPDATE api_keys SET date_last_used = $1 WHERE key_id = $2`
+		if _, err := db.ExecContext(ctx, used, now.UTC(), k.ID); err != nil {
+			return auth.Claims{}, errors.Wrap(err, "recording api key use")
+		}
+	}
+
+	var roles []string
+	for _, role := range k.Roles {
+		if contains(k.Owned, role) {
+			roles = append(roles, role)
+		}
+	}
+
+	return auth.NewClaims(k.UserID, k.OrgID, roles, now, time.Minute), nil
+}
+
+// hashSecret returns the form of a key secret kept in the database.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// contains reports whether list holds s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
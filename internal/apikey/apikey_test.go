@@ -0,0 +1,104 @@
+package apikey
+
+import (
+	"context"
+	"sales_service/internal/organization"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/database/databasetest"
+	"sales_service/internal/role"
+	"sales_service/internal/schema"
+	"testing"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAPIKeys(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+
+	if err := schema.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		admin = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03"
+		usr   = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a04"
+	)
+
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	claims := auth.NewClaims(usr, organization.DefaultID, []string{auth.RoleUser}, now, time.Hour)
+
+	perms, err := role.PermissionsFor(ctx, db, claims.Roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims.Permissions = perms
+
+	// A user cannot hand out roles they do not have, nor manage someone else's keys.
+	if _, err := Create(ctx, db, claims, usr, NewKey{Name: "ci", Roles: []string{auth.RoleAdmin}}, now); !errors.Is(err, ErrRoleNotHeld) {
+		t.Fatalf("expected ErrRoleNotHeld, got %v", err)
+	}
+	if _, err := Create(ctx, db, claims, admin, NewKey{Name: "ci", Roles: []string{auth.RoleUser}}, now); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+
+	k, err := Create(ctx, db, claims, usr, NewKey{Name: "ci", Roles: []string{auth.RoleUser}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Authenticate(ctx, db, k.Secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Subject != usr {
+		t.Fatalf("expected subject %s, got %s", usr, got.Subject)
+	}
+	if diff := cmp.Diff([]string{auth.RoleUser}, got.Roles); diff != "" {
+		t.Fatalf("roles mismatch (-want +got):\n%s", diff)
+	}
+
+	// Using the key records when it was last used.
+	keys, err := List(ctx, db, claims, usr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].DateLastUsed == nil || !keys[0].DateLastUsed.Equal(now) {
+		t.Fatalf("expected one key last used at %v, got %+v", now, keys)
+	}
+
+	unused, err := ListUnused(ctx, db, claims, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unused) != 1 || unused[0].ID != k.ID {
+		t.Fatalf("expected key %s to be unused, got %+v", k.ID, unused)
+	}
+
+	if err := Revoke(ctx, db, claims, usr, k.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Authenticate(ctx, db, k.Secret, now); !errors.Is(err, ErrInvalidSecret) {
+		t.Fatalf("expected ErrInvalidSecret after revoke, got %v", err)
+	}
+	// A key carrying only USER cannot mint an ADMIN key, even for an owner
+	// holding ADMIN.
+	claims = auth.NewClaims(admin, organization.DefaultID, []string{auth.RoleUser}, now, time.Hour)
+	claims.Permissions = perms
+
+	if _, err := Create(ctx, db, claims, admin, NewKey{Name: "ci", Roles: []string{auth.RoleAdmin}}, now); !errors.Is(err, ErrRoleNotHeld) {
+		t.Fatalf("expected ErrRoleNotHeld, got %v", err)
+	}
+
+	claims = auth.NewClaims(admin, organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+	if claims.Permissions, err = role.PermissionsFor(ctx, db, claims.Roles); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Create(ctx, db, claims, admin, NewKey{Name: "ci", Roles: []string{auth.RoleAdmin}}, now); err != nil {
+		t.Fatal(err)
+	}
+}
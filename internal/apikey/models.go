@@ -0,0 +1,34 @@
+package apikey
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Key is an API key as stored in the database. The secret itself is never
+// stored, only its hash and a short prefix to tell keys apart.
+type Key struct {
+	ID           string         `db:"key_id" json:"id"`
+	UserID       string         `db:"user_id" json:"user_id"`
+	Name         string         `db:"name" json:"name"`
+	Prefix       string         `db:"prefix" json:"prefix"`
+	Roles        pq.StringArray `db:"roles" json:"roles"`
+	DateCreated  time.Time      `db:"date_created" json:"date_created"`
+	DateLastUsed *time.Time     `db:"date_last_used" json:"date_last_used,omitempty"`
+	DateRevoked  *time.Time     `db:"date_revoked" json:"date_revoked,omitempty"`
+}
+
+// Issued is a newly created key along with its secret. The secret is only
+// available at creation time.
+type Issued struct {
+	Key
+	Secret string `json:"secret"`
+}
+
+// NewKey is what we require from clients when creating an API key. The roles
+// must be a subset of the owner's roles.
+type NewKey struct {
+	Name  string   `json:"name" validate:"required"`
+	Roles []string `json:"roles" validate:"required,min=1,dive,required"`
+}
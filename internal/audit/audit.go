@@ -0,0 +1,157 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"sales_service/internal/platform/auth"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"go.opencensus.io/trace"
+)
+
+// ErrInvalidActor is returned when filtering by an actor that is not a UUID.
+var ErrInvalidActor = errors.New("invalid actor ID format")
+
+// Actions recorded in the log.
+const (
+	Create   = "create"
+	Update   = "update"
+	Delete   = "delete"
+	Restore  = "restore"
+	Purge    = "purge"
+	Rollback = "rollback"
+)
+
+// Resources recorded in the log.
+const (
+	Product   = "product"
+	Category  = "category"
+	Sale      = "sale"
+	Order     = "order"
+	Refund    = "refund"
+	User      = "user"
+	Variant   = "variant"
+	Warehouse = "warehouse"
+	Stock     = "stock"
+	Transfer  = "transfer"
+)
+
+const (
+	// DefaultLimit is the page size used when the caller does not ask for one.
+	DefaultLimit = 50
+
+	// MaxLimit is the largest page size a caller may request.
+	MaxLimit = 500
+)
+
+// Record writes an entry for a change made by actor. It is meant to be
+// called with the transaction making the change, so the change and its
+// entry are committed or rolled back together.
+func Record(ctx context.Context, tx sqlx.ExecerContext, actor auth.Claims, c Change, now time.Time) error {
+	before, err := marshal(c.Before)
+	if err != nil {
+		return errors.Wrap(err, "encoding audit before")
+	}
+	after, err := marshal(c.After)
+	if err != nil {
+		return errors.Wrap(err, "encoding audit after")
+	}
+
+	// Changes made outside of a request, such as from the admin tool, have
+	// neither an actor nor a trace.
+	var actorID, orgID *string
+	if actor.Subject != "" {
+		actorID = &actor.Subject
+	}
+	if c.OrgID != "" {
+		orgID = &c.OrgID
+	}
+
+	var traceID string
+	if span := trace.FromContext(ctx); span != nil {
+		traceID = span.SpanContext().TraceID.String()
+	}
+
+	const q = `INSERT INTO audit_log
+	(audit_id, org_id, actor_id, action, resource, resource_id, before, after, trace_id, date_created)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	_, err = tx.ExecContext(ctx, q, uuid.New().String(), orgID, actorID,
+		c.Action, c.Resource, c.ResourceID, before, after, traceID, now.UTC())
+	if err != nil {
+		return errors.Wrap(err, "inserting audit entry")
+	}
+
+	return nil
+}
+
+// List gives the entries of the caller's organization matching the filter,
+// newest first.
+func List(ctx context.Context, db *sqlx.DB, claims auth.Claims, f Filter) ([]Entry, error) {
+	if f.Limit <= 0 || f.Limit > MaxLimit {
+		f.Limit = DefaultLimit
+	}
+
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if org := claims.OrgScope(); org != nil {
+		where = append(where, "org_id = "+arg(*org))
+	}
+	if f.ActorID != "" {
+		if _, err := uuid.Parse(f.ActorID); err != nil {
+			return nil, ErrInvalidActor
+		}
+		where = append(where, "actor_id = "+arg(f.ActorID))
+	}
+	if f.Resource != "" {
+		where = append(where, "resource = "+arg(f.Resource))
+	}
+	if f.ResourceID != "" {
+		where = append(where, "resource_id = "+arg(f.ResourceID))
+	}
+	if f.From != nil {
+		where = append(where, "date_created >= "+arg(f.From.UTC()))
+	}
+	if f.To != nil {
+		where = append(where, "date_created < "+arg(f.To.UTC()))
+	}
+
+	q := `SELECT audit_id, COALESCE(org_id::text, '') AS org_id, COALESCE(actor_id::text, '') AS actor_id,
+	action, resource, resource_id, before, after, trace_id, date_created
+	FROM audit_log`
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += " ORDER BY date_created DESC, audit_id LIMIT " + arg(f.Limit) + " OFFSET " + arg(f.Offset)
+
+	list := []Entry{}
+	if err := db.SelectContext(ctx, &list, q, args...); err != nil {
+		return nil, errors.Wrap(err, "selecting audit entries")
+	}
+
+	return list, nil
+}
+
+// marshal encodes v for a JSONB column, keeping nil as NULL. The JSON is
+// passed as text since lib/pq would send a byte slice as bytea.
+func marshal(v interface{}) (*string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	s := string(data)
+	return &s, nil
+}
@@ -0,0 +1,42 @@
+package audit
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Entry records a single change made to a resource.
+type Entry struct {
+	ID          string          `db:"audit_id" json:"id"`
+	OrgID       string          `db:"org_id" json:"org_id"`
+	ActorID     string          `db:"actor_id" json:"actor_id"`
+	Action      string          `db:"action" json:"action"`
+	Resource    string          `db:"resource" json:"resource"`
+	ResourceID  string          `db:"resource_id" json:"resource_id"`
+	Before      json.RawMessage `db:"before" json:"before,omitempty"`
+	After       json.RawMessage `db:"after" json:"after,omitempty"`
+	TraceID     string          `db:"trace_id" json:"trace_id"`
+	DateCreated time.Time       `db:"date_created" json:"date_created"`
+}
+
+// Change describes what is recorded. Before and After are stored as JSON and
+// left empty when nil, such as Before for a create.
+type Change struct {
+	OrgID      string
+	Action     string
+	Resource   string
+	ResourceID string
+	Before     interface{}
+	After      interface{}
+}
+
+// Filter narrows down a listing of the audit log. Empty fields are ignored.
+type Filter struct {
+	ActorID    string
+	Resource   string
+	ResourceID string
+	From       *time.Time
+	To         *time.Time
+	Limit      int
+	Offset     int
+}
@@ -0,0 +1,290 @@
+package category
+
+import (
+	"context"
+	"database/sql"
+	"sales_service/internal/audit"
+	"sales_service/internal/platform/auth"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrNotFound       = errors.New("category not found")
+	ErrInvalidID      = errors.New("invalid category ID format")
+	ErrParentNotFound = errors.New("parent category not found")
+	ErrHasChildren    = errors.New("category still has subcategories")
+
+	// ErrCycle is returned when moving a category under itself or one of its
+	// own subcategories.
+	ErrCycle = errors.New("category cannot be moved under its own subtree")
+)
+
+// selectCategories lists the category columns in the order Category
+// declares them.
+const selectCategories = `SELECT category_id, org_id, parent_id, name, date_created, date_updated FROM categories`
+
+// List gives every category of the caller's organization, ordered by name.
+// Clients build the tree from the parent IDs.
+func List(ctx context.Context, db *sqlx.DB, user auth.Claims) ([]Category, error) {
+	list := []Category{}
+
+	const q = selectCategories + ` WHERE ($1::UUID IS NULL OR org_id = $1) ORDER BY name, category_id`
+	if err := db.SelectContext(ctx, &list, q, user.OrgScope()); err != nil {
+		return nil, errors.Wrap(err, "selecting categories")
+	}
+
+	return list, nil
+}
+
+// Retrieve finds a category of the caller's organization.
+func Retrieve(ctx context.Context, db *sqlx.DB, user auth.Claims, id string) (*Category, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var c Category
+
+	const q = selectCategories + ` WHERE category_id = $1 AND ($2::UUID IS NULL OR org_id = $2)`
+	if err := db.GetContext(ctx, &c, q, id, user.OrgScope()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting category")
+	}
+
+	return &c, nil
+}
+
+// Create adds a category to the caller's organization, at the top level or
+// under an existing category of the same organization.
+func Create(ctx context.Context, db *sqlx.DB, user auth.Claims, nc NewCategory, now time.Time) (*Category, error) {
+	now = now.UTC().Truncate(time.Microsecond)
+
+	c := Category{
+		ID:          uuid.New().String(),
+		OrgID:       user.OrgID,
+		ParentID:    nc.ParentID,
+		Name:        nc.Name,
+		DateCreated: now,
+		DateUpdated: now,
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	if c.ParentID != nil {
+		if err := checkParent(ctx, tx, c.OrgID, *c.ParentID); err != nil {
+			return nil, err
+		}
+	}
+
+	const q = `INSERT INTO categories (category_id, org_id, parent_id, name, date_created, date_updated)
+	VALUES ($1, $2, $3, $4, $5, $6)`
+	if _, err := tx.ExecContext(ctx, q, c.ID, c.OrgID, c.ParentID, c.Name, c.DateCreated, c.DateUpdated); err != nil {
+		return nil, errors.Wrap(err, "inserting category")
+	}
+
+	change := audit.Change{
+		OrgID:      c.OrgID,
+		Action:     audit.Create,
+		Resource:   audit.Category,
+		ResourceID: c.ID,
+		After:      c,
+	}
+	if err := audit.Record(ctx, tx, user, change, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing category")
+	}
+
+	return &c, nil
+}
+
+// Update renames a category of the caller's organization or moves it, with
+// its subcategories, under another parent.
+func Update(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, upd UpdateCategory, now time.Time) (*Category, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	before, err := lock(ctx, tx, user, id)
+	if err != nil {
+		return nil, err
+	}
+
+	c := *before
+	if upd.Name != nil {
+		c.Name = *upd.Name
+	}
+	if upd.ParentID != nil {
+		c.ParentID = nil
+		if *upd.ParentID != "" {
+			if err := checkParent(ctx, tx, c.OrgID, *upd.ParentID); err != nil {
+				return nil, err
+			}
+			if err := lockTree(ctx, tx, c.OrgID); err != nil {
+				return nil, err
+			}
+			if err := checkCycle(ctx, tx, id, *upd.ParentID); err != nil {
+				return nil, err
+			}
+			c.ParentID = upd.ParentID
+		}
+	}
+	c.DateUpdated = now.UTC().Truncate(time.Microsecond)
+
+	const q = `UPDATE categories SET name = $1, parent_id = $2, date_updated = $3 WHERE category_id = $4`
+	if _, err := tx.ExecContext(ctx, q, c.Name, c.ParentID, c.DateUpdated, id); err != nil {
+		return nil, errors.Wrap(err, "updating category")
+	}
+
+	change := audit.Change{
+		OrgID:      c.OrgID,
+		Action:     audit.Update,
+		Resource:   audit.Category,
+		ResourceID: id,
+		Before:     before,
+		After:      c,
+	}
+	if err := audit.Record(ctx, tx, user, change, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing category update")
+	}
+
+	return &c, nil
+}
+
+// Delete removes a category of the caller's organization. Its products are
+// left without a category. Categories with subcategories cannot be removed
+// until those are moved or removed first.
+func Delete(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, now time.Time) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	before, err := lock(ctx, tx, user, id)
+	if err != nil {
+		return err
+	}
+
+	var children bool
+
+	const q1 = `SELECT EXISTS (SELECT 1 FROM categories WHERE parent_id = $1)`
+	if err := tx.GetContext(ctx, &children, q1, id); err != nil {
+		return errors.Wrap(err, "counting subcategories")
+	}
+	if children {
+		return ErrHasChildren
+	}
+
+	const q2 = `DELETE FROM categories WHERE category_id = $1`
+	if _, err := tx.ExecContext(ctx, q2, id); err != nil {
+		return errors.Wrap(err, "deleting category")
+	}
+
+	change := audit.Change{
+		OrgID:      before.OrgID,
+		Action:     audit.Delete,
+		Resource:   audit.Category,
+		ResourceID: id,
+		Before:     before,
+	}
+	if err := audit.Record(ctx, tx, user, change, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing category delete")
+	}
+	return nil
+}
+
+// lock reads a category of the caller's organization and locks its row
+// inside tx.
+func lock(ctx context.Context, tx *sqlx.Tx, user auth.Claims, id string) (*Category, error) {
+	var c Category
+
+	const q = selectCategories + ` WHERE category_id = $1 AND ($2::UUID IS NULL OR org_id = $2) FOR UPDATE`
+	if err := tx.GetContext(ctx, &c, q, id, user.OrgScope()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "locking category")
+	}
+
+	return &c, nil
+}
+
+// checkParent returns ErrParentNotFound unless parentID is a category of the
+// org organization.
+func checkParent(ctx context.Context, tx *sqlx.Tx, org, parentID string) error {
+	if _, err := uuid.Parse(parentID); err != nil {
+		return ErrParentNotFound
+	}
+
+	var exists bool
+
+	const q = `SELECT EXISTS (SELECT 1 FROM categories WHERE category_id = $1 AND org_id = $2)`
+	if err := tx.GetContext(ctx, &exists, q, parentID, org); err != nil {
+		return errors.Wrap(err, "selecting parent category")
+	}
+	if !exists {
+		return ErrParentNotFound
+	}
+	return nil
+}
+
+// lockTree serializes moves between the categories of the org organization
+// until tx ends. Locking the moved category alone is not enough: moving A
+// under B and B under A at once would both pass checkCycle.
+func lockTree(ctx context.Context, tx *sqlx.Tx, org string) error {
+	const q = `SELECT pg_advisory_xact_lock(hashtext('categories:' || $1))`
+	if _, err := tx.ExecContext(ctx, q, org); err != nil {
+		return errors.Wrap(err, "locking category tree")
+	}
+	return nil
+}
+
+// checkCycle returns ErrCycle when parentID is id itself or one of its
+// subcategories, at any depth. The tree must be locked with lockTree.
+func checkCycle(ctx context.Context, tx *sqlx.Tx, id, parentID string) error {
+	var cycle bool
+
+	const q = `WITH RECURSIVE subtree AS (
+		SELECT category_id FROM categories WHERE category_id = $1
+		UNION
+		SELECT c.category_id FROM categories AS c JOIN subtree AS t ON c.parent_id = t.category_id
+	)
+	SELECT EXISTS (SELECT 1 FROM subtree WHERE category_id = $2)`
+	if err := tx.GetContext(ctx, &cycle, q, id, parentID); err != nil {
+		return errors.Wrap(err, "selecting subcategories")
+	}
+	if cycle {
+		return ErrCycle
+	}
+	return nil
+}
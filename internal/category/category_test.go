@@ -0,0 +1,86 @@
+package category
+
+import (
+	"context"
+	"sales_service/internal/organization"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/database/databasetest"
+	"testing"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+func TestCategories(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+
+	toys, err := Create(ctx, db, claims, NewCategory{Name: "Toys"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lego, err := Create(ctx, db, claims, NewCategory{Name: "Lego", ParentID: &toys.ID}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	technic, err := Create(ctx, db, claims, NewCategory{Name: "Technic", ParentID: &lego.ID}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missing := "a0eebc99-9c0b-4ef8-bb6d-6bb9bd390aff"
+	if _, err := Create(ctx, db, claims, NewCategory{Name: "Lost", ParentID: &missing}, now); !errors.Is(err, ErrParentNotFound) {
+		t.Fatalf("expected ErrParentNotFound, got %v", err)
+	}
+
+	// A category cannot end up below itself.
+	if _, err := Update(ctx, db, claims, toys.ID, UpdateCategory{ParentID: &technic.ID}, now); !errors.Is(err, ErrCycle) {
+		t.Fatalf("expected ErrCycle, got %v", err)
+	}
+	if _, err := Update(ctx, db, claims, toys.ID, UpdateCategory{ParentID: &toys.ID}, now); !errors.Is(err, ErrCycle) {
+		t.Fatalf("expected ErrCycle, got %v", err)
+	}
+
+	// Moving Technic to the top level leaves Lego without children.
+	top := ""
+	moved, err := Update(ctx, db, claims, technic.ID, UpdateCategory{ParentID: &top}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.ParentID != nil {
+		t.Fatalf("expected a top level category, got parent %s", *moved.ParentID)
+	}
+
+	if err := Delete(ctx, db, claims, toys.ID, now); !errors.Is(err, ErrHasChildren) {
+		t.Fatalf("expected ErrHasChildren, got %v", err)
+	}
+	if err := Delete(ctx, db, claims, lego.ID, now); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := List(ctx, db, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Name != "Technic" || list[1].Name != "Toys" {
+		t.Fatalf("expected Technic and Toys, got %+v", list)
+	}
+
+	// Categories of other organizations are out of reach, also as parents.
+	store, err := organization.Create(ctx, db, organization.NewOrganization{Name: "Second store"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a04", store.ID, []string{auth.RoleAdmin}, now, time.Hour)
+
+	if _, err := Retrieve(ctx, db, other, toys.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := Create(ctx, db, other, NewCategory{Name: "Lego", ParentID: &toys.ID}, now); !errors.Is(err, ErrParentNotFound) {
+		t.Fatalf("expected ErrParentNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package category
+
+import "time"
+
+// Category groups products for browsing. Categories form a tree within an
+// organization; those without a parent are at the top level.
+type Category struct {
+	ID          string    `db:"category_id" json:"id"`
+	OrgID       string    `db:"org_id" json:"org_id"`
+	ParentID    *string   `db:"parent_id" json:"parent_id"`
+	Name        string    `db:"name" json:"name"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// NewCategory is what we require from clients when adding a category.
+type NewCategory struct {
+	Name     string  `json:"name" validate:"required"`
+	ParentID *string `json:"parent_id"`
+}
+
+// UpdateCategory renames a category or moves it under another parent. The
+// fields are pointers so that one can specify only the fields that need to
+// be updated, and an empty ParentID moves the category to the top level.
+type UpdateCategory struct {
+	Name     *string `json:"name" validate:"omitempty,min=1"`
+	ParentID *string `json:"parent_id"`
+}
@@ -0,0 +1,65 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// Types of events published to the outbox.
+const (
+	ProductCreated    = "product.created"
+	ProductUpdated    = "product.updated"
+	ProductDeleted    = "product.deleted"
+	ProductRestored   = "product.restored"
+	ProductOutOfStock = "product.out_of_stock"
+	SaleCreated       = "sale.created"
+	TransferShipped   = "transfer.shipped"
+	TransferReceived  = "transfer.received"
+)
+
+// Types lists every event type, in the order they are documented.
+var Types = []string{
+	ProductCreated,
+	ProductUpdated,
+	ProductDeleted,
+	ProductRestored,
+	ProductOutOfStock,
+	SaleCreated,
+	TransferShipped,
+	TransferReceived,
+}
+
+// Known reports whether typ is one of Types.
+func Known(typ string) bool {
+	for _, t := range Types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish adds an event of the orgID organization to the outbox. It is meant
+// to be called with the transaction making the change, so an event is only
+// ever delivered for a change that was committed, and every committed change
+// has its event.
+func Publish(ctx context.Context, tx sqlx.ExecerContext, orgID, typ string, payload interface{}, now time.Time) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "encoding event payload")
+	}
+
+	// The payload is passed as text since lib/pq would send a byte slice as
+	// bytea.
+	const q = `INSERT INTO outbox (event_id, org_id, type, payload, date_created) VALUES ($1, $2, $3, $4, $5)`
+	if _, err := tx.ExecContext(ctx, q, uuid.New().String(), orgID, typ, string(data), now.UTC()); err != nil {
+		return errors.Wrap(err, "inserting event")
+	}
+
+	return nil
+}
@@ -0,0 +1,17 @@
+package event
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event is something that happened to a resource, kept in the outbox until
+// it has been handed to every webhook subscribed to it.
+type Event struct {
+	ID             string          `db:"event_id" json:"id"`
+	OrgID          string          `db:"org_id" json:"org_id"`
+	Type           string          `db:"type" json:"type"`
+	Payload        json.RawMessage `db:"payload" json:"data"`
+	DateCreated    time.Time       `db:"date_created" json:"date_created"`
+	DateDispatched *time.Time      `db:"date_dispatched" json:"-"`
+}
@@ -0,0 +1,299 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sales_service/internal/platform/auth"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Formats an export can be written in.
+const (
+	// FormatCSV writes a header naming the columns, then a record per row.
+	// Tags are separated by a vertical bar, as a product import expects.
+	FormatCSV = "csv"
+
+	// FormatNDJSON writes every row as a JSON object on its own line.
+	FormatNDJSON = "ndjson"
+
+	// FormatXLSX writes a spreadsheet with a single sheet.
+	FormatXLSX = "xlsx"
+)
+
+var (
+	ErrUnknownFormat  = errors.New("export format must be csv, ndjson or xlsx")
+	ErrInvalidColumns = errors.New("invalid columns")
+	ErrInvalidRange   = errors.New("from must be before to")
+)
+
+// batchSize is how many rows are fetched from the cursor at a time. Only one
+// batch is held in memory, however large the export.
+const batchSize = 500
+
+// kind is how the values of a column are scanned and written.
+type kind int
+
+const (
+	text kind = iota
+	number
+	timestamp
+	list
+)
+
+// column is a column an export can hold, and the expression selecting it.
+type column struct {
+	name string
+	expr string
+	kind kind
+}
+
+// productColumns are the columns of a product export, in their default
+// order.
+var productColumns = []column{
+	{"id", "p.id", text},
+	{"sku", "p.sku", text},
+	{"barcode", "p.barcode", text},
+	{"name", "p.name", text},
+	{"category_id", "p.category_id", text},
+	{"tags", "p.tags", list},
+	{"cost", "p.cost", number},
+	{"quantity", "p.quantity", number},
+	{"version", "p.version", number},
+	{"user_id", "p.user_id", text},
+	{"date_created", "p.date_created", timestamp},
+	{"date_updated", "p.date_updated", timestamp},
+	{"date_deleted", "p.date_deleted", timestamp},
+}
+
+// saleColumns are the columns of a sale export, in their default order. The
+// SKU is that of the variant sold, if any, and the refunded amount adds up
+// every refund of the sale.
+var saleColumns = []column{
+	{"sale_id", "s.sale_id", text},
+	{"order_id", "s.order_id", text},
+	{"product_id", "s.product_id", text},
+	{"variant_id", "s.variant_id", text},
+	{"warehouse_id", "s.warehouse_id", text},
+	{"sku", "COALESCE(v.sku, p.sku)", text},
+	{"product_name", "p.name", text},
+	{"user_id", "o.user_id", text},
+	{"quantity", "s.quantity", number},
+	{"list_price", "s.list_price", number},
+	{"paid", "s.paid", number},
+	{"refunded", "(SELECT COALESCE(SUM(r.amount), 0) FROM refunds AS r WHERE r.sale_id = s.sale_id)", number},
+	{"date_created", "s.date_created", timestamp},
+}
+
+// Products writes the products of the caller's organization to w, archived
+// ones included, oldest first. It returns the number of rows written.
+func Products(ctx context.Context, db *sqlx.DB, user auth.Claims, w io.Writer, opts Options) (int, error) {
+	const from = ` FROM products AS p
+	WHERE ($1::UUID IS NULL OR p.org_id = $1)
+	AND ($2::TIMESTAMP IS NULL OR p.date_created >= $2)
+	AND ($3::TIMESTAMP IS NULL OR p.date_created < $3)
+	ORDER BY p.date_created, p.id`
+
+	return run(ctx, db, user, w, opts, "products", productColumns, from)
+}
+
+// Sales writes the sales of the caller's organization to w, oldest first. It
+// returns the number of rows written.
+func Sales(ctx context.Context, db *sqlx.DB, user auth.Claims, w io.Writer, opts Options) (int, error) {
+	const from = ` FROM sales AS s
+	JOIN products AS p ON p.id = s.product_id
+	LEFT JOIN product_variants AS v ON v.variant_id = s.variant_id
+	JOIN orders AS o ON o.order_id = s.order_id
+	WHERE ($1::UUID IS NULL OR p.org_id = $1)
+	AND ($2::TIMESTAMP IS NULL OR s.date_created >= $2)
+	AND ($3::TIMESTAMP IS NULL OR s.date_created < $3)
+	ORDER BY s.date_created, s.sale_id`
+
+	return run(ctx, db, user, w, opts, "sales", saleColumns, from)
+}
+
+// run checks opts, then writes the rows selected by from through a cursor,
+// a batch at a time. Nothing is written to w when opts are invalid or the
+// query cannot start, so callers can still report those errors. The rows
+// are read in a single read-only snapshot, so rows written meanwhile do not
+// tear the export.
+func run(ctx context.Context, db *sqlx.DB, user auth.Claims, w io.Writer, opts Options, name string, all []column, from string) (int, error) {
+	cols, err := pick(all, opts.Columns)
+	if err != nil {
+		return 0, err
+	}
+
+	var out rowWriter
+	switch opts.Format {
+	case FormatCSV:
+		out = newCSVWriter(w)
+	case FormatNDJSON:
+		out = newNDJSONWriter(w)
+	case FormatXLSX:
+		out = newXLSXWriter(w, name)
+	default:
+		return 0, ErrUnknownFormat
+	}
+
+	if opts.From != nil && opts.To != nil && !opts.From.Before(*opts.To) {
+		return 0, ErrInvalidRange
+	}
+
+	tx, err := db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return 0, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	exprs := make([]string, len(cols))
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		exprs[i] = c.expr
+		names[i] = c.name
+	}
+
+	q := `DECLARE export NO SCROLL CURSOR FOR SELECT ` + strings.Join(exprs, ", ") + from
+	if _, err := tx.ExecContext(ctx, q, user.OrgScope(), utc(opts.From), utc(opts.To)); err != nil {
+		return 0, errors.Wrap(err, "declaring cursor")
+	}
+
+	if err := out.header(names); err != nil {
+		return 0, err
+	}
+
+	var n int
+	for {
+		fetched, err := fetch(ctx, tx, cols, out)
+		n += fetched
+		if err != nil {
+			return n, err
+		}
+		if fetched < batchSize {
+			break
+		}
+		if err := out.flush(); err != nil {
+			return n, err
+		}
+	}
+
+	if err := out.close(); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// fetch writes the next batch of rows of the cursor to out and returns how
+// many there were.
+func fetch(ctx context.Context, tx *sqlx.Tx, cols []column, out rowWriter) (int, error) {
+	q := fmt.Sprintf(`FETCH %d FROM export`, batchSize)
+	rows, err := tx.QueryContext(ctx, q)
+	if err != nil {
+		return 0, errors.Wrap(err, "fetching rows")
+	}
+	defer rows.Close()
+
+	dest := make([]interface{}, len(cols))
+	for i, c := range cols {
+		switch c.kind {
+		case number:
+			dest[i] = new(sql.NullInt64)
+		case timestamp:
+			dest[i] = new(sql.NullTime)
+		case list:
+			dest[i] = new(pq.StringArray)
+		default:
+			dest[i] = new(sql.NullString)
+		}
+	}
+	vals := make([]interface{}, len(cols))
+
+	var n int
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return n, errors.Wrap(err, "scanning row")
+		}
+		for i, d := range dest {
+			vals[i] = value(d)
+		}
+		if err := out.row(vals); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, errors.Wrap(err, "reading rows")
+	}
+
+	return n, nil
+}
+
+// value unwraps a scanned value into nil, a string, an int64, a time.Time or
+// a []string.
+func value(d interface{}) interface{} {
+	switch v := d.(type) {
+	case *sql.NullString:
+		if v.Valid {
+			return v.String
+		}
+	case *sql.NullInt64:
+		if v.Valid {
+			return v.Int64
+		}
+	case *sql.NullTime:
+		if v.Valid {
+			return v.Time
+		}
+	case *pq.StringArray:
+		if *v != nil {
+			return []string(*v)
+		}
+	}
+	return nil
+}
+
+// pick finds the named columns in all, keeping the order they were named
+// in. No names picks every column.
+func pick(all []column, names []string) ([]column, error) {
+	if len(names) == 0 {
+		return all, nil
+	}
+
+	cols := make([]column, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if seen[name] {
+			return nil, errors.Wrapf(ErrInvalidColumns, "column %q given twice", name)
+		}
+		seen[name] = true
+
+		found := false
+		for _, c := range all {
+			if c.name == name {
+				cols = append(cols, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.Wrapf(ErrInvalidColumns, "unknown column %q", name)
+		}
+	}
+
+	return cols, nil
+}
+
+// utc converts t to UTC, which the timestamps are stored in.
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
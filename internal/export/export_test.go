@@ -0,0 +1,165 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sales_service/internal/organization"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/database/databasetest"
+	"sales_service/internal/schema"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExport(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+
+	if err := schema.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+
+	// Products as CSV with the columns in the order asked for.
+	var buf bytes.Buffer
+	opts := Options{Format: FormatCSV, Columns: []string{"name", "cost", "tags"}}
+	n, err := Products(ctx, db, claims, &buf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 products, got %d", n)
+	}
+
+	want := "name,cost,tags\nLego Chima,2000,\nLego City,3000,\n"
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+
+	// Sales as NDJSON, limited to the day they were made on.
+	from := time.Date(2024, 5, 5, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 1)
+
+	buf.Reset()
+	opts = Options{Format: FormatNDJSON, Columns: []string{"sale_id", "paid", "refunded"}, From: &from, To: &to}
+	if _, err := Sales(ctx, db, claims, &buf, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []map[string]interface{}
+	dec := json.NewDecoder(&buf)
+	for {
+		var row map[string]interface{}
+		if err := dec.Decode(&row); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, row)
+	}
+
+	wantRows := []map[string]interface{}{
+		{"sale_id": "b0eebc99-9c0b-4ef8-bb6d-6bb9bd380a51", "paid": float64(6000), "refunded": float64(0)},
+		{"sale_id": "b0eebc99-9c0b-4ef8-bb6d-6bb9bd380a61", "paid": float64(2000), "refunded": float64(0)},
+		{"sale_id": "b0eebc99-9c0b-4ef8-bb6d-6bb9bd390a41", "paid": float64(3000), "refunded": float64(0)},
+	}
+	if diff := cmp.Diff(wantRows, got); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+
+	// Nothing was sold the day after.
+	from, to = to, to.AddDate(0, 0, 1)
+	buf.Reset()
+	if n, err := Sales(ctx, db, claims, &buf, opts); err != nil || n != 0 {
+		t.Fatalf("expected no sales, got %d, %v", n, err)
+	}
+
+	// Invalid options are refused before anything is written.
+	buf.Reset()
+	if _, err := Products(ctx, db, claims, &buf, Options{Format: FormatCSV, Columns: []string{"password"}}); !errors.Is(err, ErrInvalidColumns) {
+		t.Fatalf("expected ErrInvalidColumns, got %v", err)
+	}
+	if _, err := Products(ctx, db, claims, &buf, Options{Format: "pdf"}); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("expected ErrUnknownFormat, got %v", err)
+	}
+	if _, err := Sales(ctx, db, claims, &buf, Options{Format: FormatCSV, From: &to, To: &from}); !errors.Is(err, ErrInvalidRange) {
+		t.Fatalf("expected ErrInvalidRange, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected nothing written, got %q", buf.String())
+	}
+}
+
+func TestXLSX(t *testing.T) {
+	var buf bytes.Buffer
+
+	x := newXLSXWriter(&buf, "products")
+	if err := x.header([]string{"name", "cost", "tags", "date_created"}); err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2024, 5, 5, 12, 0, 0, 0, time.UTC)
+	if err := x.row([]interface{}{"Tom & Jerry", int64(3000), []string{"cartoon", "toy"}, created}); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.row([]interface{}{"<none>", nil, nil, nil}); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sheet string
+	for _, f := range zr.File {
+		if f.Name != "xl/worksheets/sheet1.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sheet = string(b)
+	}
+	if len(zr.File) != 6 || sheet == "" {
+		t.Fatalf("expected the 6 parts of a workbook, got %d", len(zr.File))
+	}
+
+	for _, want := range []string{
+		`<c r="A1" t="inlineStr" s="2"><is><t xml:space="preserve">name</t></is></c>`,
+		`<c r="A2" t="inlineStr"><is><t xml:space="preserve">Tom &amp; Jerry</t></is></c>`,
+		`<c r="B2"><v>3000</v></c>`,
+		`<c r="C2" t="inlineStr"><is><t xml:space="preserve">cartoon|toy</t></is></c>`,
+		`<c r="D2" s="1"><v>45417.5</v></c>`,
+		`<row r="3"><c r="A3" t="inlineStr"><is><t xml:space="preserve">&lt;none&gt;</t></is></c></row>`,
+	} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("sheet is missing %s", want)
+		}
+	}
+
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		if got := columnName(i); got != want {
+			t.Errorf("column %d: expected %s, got %s", i, want, got)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package export
+
+import "time"
+
+// Options choose what an export holds and how it is written.
+type Options struct {
+	// Format is one of FormatCSV, FormatNDJSON and FormatXLSX.
+	Format string
+
+	// Columns are the columns to write, in order. Every column is written
+	// when none are given.
+	Columns []string
+
+	// From and To limit the export to rows created in [From, To). Either
+	// may be left out.
+	From *time.Time
+	To   *time.Time
+}
@@ -0,0 +1,151 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+// rowWriter writes the rows of an export in one of its formats.
+type rowWriter interface {
+	// header starts the export with the names of its columns.
+	header(names []string) error
+
+	// row writes the values of a row, each nil, a string, an int64, a
+	// time.Time or a []string.
+	row(vals []interface{}) error
+
+	// flush passes the rows written so far on to the client.
+	flush() error
+
+	// close ends the export.
+	close() error
+}
+
+// flusher is implemented by writers holding back what was written to them,
+// like an http.ResponseWriter.
+type flusher interface {
+	Flush()
+}
+
+// flushTo flushes w when it holds writes back.
+func flushTo(w io.Writer) {
+	if f, ok := w.(flusher); ok {
+		f.Flush()
+	}
+}
+
+// csvWriter writes an export as CSV.
+type csvWriter struct {
+	w   io.Writer
+	cw  *csv.Writer
+	rec []string
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: w, cw: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) header(names []string) error {
+	c.rec = make([]string, len(names))
+	if err := c.cw.Write(names); err != nil {
+		return errors.Wrap(err, "writing header")
+	}
+	return nil
+}
+
+func (c *csvWriter) row(vals []interface{}) error {
+	for i, v := range vals {
+		switch v := v.(type) {
+		case string:
+			c.rec[i] = v
+		case int64:
+			c.rec[i] = strconv.FormatInt(v, 10)
+		case time.Time:
+			c.rec[i] = v.Format(time.RFC3339Nano)
+		case []string:
+			c.rec[i] = strings.Join(v, "|")
+		default:
+			c.rec[i] = ""
+		}
+	}
+	if err := c.cw.Write(c.rec); err != nil {
+		return errors.Wrap(err, "writing row")
+	}
+	return nil
+}
+
+func (c *csvWriter) flush() error {
+	c.cw.Flush()
+	if err := c.cw.Error(); err != nil {
+		return errors.Wrap(err, "writing rows")
+	}
+	flushTo(c.w)
+	return nil
+}
+
+func (c *csvWriter) close() error {
+	return c.flush()
+}
+
+// ndjsonWriter writes an export as a JSON object per row. The keys of every
+// object follow the order of the columns.
+type ndjsonWriter struct {
+	w     io.Writer
+	bw    *bufio.Writer
+	names [][]byte
+}
+
+func newNDJSONWriter(w io.Writer) *ndjsonWriter {
+	return &ndjsonWriter{w: w, bw: bufio.NewWriter(w)}
+}
+
+func (n *ndjsonWriter) header(names []string) error {
+	n.names = make([][]byte, len(names))
+	for i, name := range names {
+		key, err := json.Marshal(name)
+		if err != nil {
+			return errors.Wrap(err, "encoding column name")
+		}
+		n.names[i] = key
+	}
+	return nil
+}
+
+func (n *ndjsonWriter) row(vals []interface{}) error {
+	n.bw.WriteByte('{')
+	for i, v := range vals {
+		if i > 0 {
+			n.bw.WriteByte(',')
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return errors.Wrap(err, "encoding row")
+		}
+		n.bw.Write(n.names[i])
+		n.bw.WriteByte(':')
+		n.bw.Write(b)
+	}
+	if _, err := n.bw.WriteString("}\n"); err != nil {
+		return errors.Wrap(err, "writing row")
+	}
+	return nil
+}
+
+func (n *ndjsonWriter) flush() error {
+	if err := n.bw.Flush(); err != nil {
+		return errors.Wrap(err, "writing rows")
+	}
+	flushTo(n.w)
+	return nil
+}
+
+func (n *ndjsonWriter) close() error {
+	return n.flush()
+}
@@ -0,0 +1,224 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+// ErrTooManyRows is returned when an export holds more rows than fit in a
+// spreadsheet. Large exports should be written as CSV or NDJSON instead.
+var ErrTooManyRows = errors.New("too many rows for a spreadsheet")
+
+// maxSheetRows is the most rows a sheet can hold, the header included.
+const maxSheetRows = 1 << 20
+
+// Styles of the cells, indexes into the cellXfs of xlsxStyles.
+const (
+	styleDate   = 1
+	styleHeader = 2
+)
+
+// The parts of a workbook that do not depend on the rows. Only the sheet is
+// written as the rows come, as the last part of the archive.
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`
+
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`
+
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="{name}" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`
+
+	xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts>` +
+		`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="3">` +
+		`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+		`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+		`</cellXfs>` +
+		`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+		`</styleSheet>`
+
+	xlsxSheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>` +
+		`<sheetData>`
+
+	xlsxSheetEnd = `</sheetData></worksheet>`
+)
+
+// epoch is day zero of spreadsheet dates.
+var epoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// xlsxWriter writes an export as an Office Open XML spreadsheet. Cells hold
+// their strings inline rather than in a shared table, so nothing has to be
+// kept until the end; numbers and times are written as numbers, times
+// formatted as dates.
+type xlsxWriter struct {
+	w     io.Writer
+	name  string
+	zw    *zip.Writer
+	sheet *bufio.Writer
+	cols  []string
+	rows  int
+}
+
+func newXLSXWriter(w io.Writer, name string) *xlsxWriter {
+	return &xlsxWriter{w: w, name: name}
+}
+
+func (x *xlsxWriter) header(names []string) error {
+	x.zw = zip.NewWriter(x.w)
+
+	parts := []struct {
+		name, body string
+	}{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", strings.Replace(xlsxWorkbook, "{name}", escape(x.name), 1)},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, p := range parts {
+		f, err := x.zw.Create(p.name)
+		if err != nil {
+			return errors.Wrapf(err, "creating %s", p.name)
+		}
+		if _, err := io.WriteString(f, p.body); err != nil {
+			return errors.Wrapf(err, "writing %s", p.name)
+		}
+	}
+
+	f, err := x.zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return errors.Wrap(err, "creating sheet")
+	}
+	x.sheet = bufio.NewWriter(f)
+	x.sheet.WriteString(xlsxSheetStart)
+
+	x.cols = make([]string, len(names))
+	for i := range names {
+		x.cols[i] = columnName(i)
+	}
+
+	vals := make([]interface{}, len(names))
+	for i, name := range names {
+		vals[i] = name
+	}
+	return x.write(vals, styleHeader)
+}
+
+func (x *xlsxWriter) row(vals []interface{}) error {
+	return x.write(vals, 0)
+}
+
+// write adds a row to the sheet. Strings are written in style, other values
+// in the style of their type.
+func (x *xlsxWriter) write(vals []interface{}, style int) error {
+	if x.rows == maxSheetRows {
+		return ErrTooManyRows
+	}
+	x.rows++
+
+	r := strconv.Itoa(x.rows)
+	b := x.sheet
+
+	b.WriteString(`<row r="` + r + `">`)
+	for i, v := range vals {
+		ref := x.cols[i] + r
+		switch v := v.(type) {
+		case string:
+			b.WriteString(`<c r="` + ref + `" t="inlineStr"` + styleAttr(style) + `><is><t xml:space="preserve">` + escape(v) + `</t></is></c>`)
+		case []string:
+			b.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">` + escape(strings.Join(v, "|")) + `</t></is></c>`)
+		case int64:
+			b.WriteString(`<c r="` + ref + `"><v>` + strconv.FormatInt(v, 10) + `</v></c>`)
+		case time.Time:
+			days := v.UTC().Sub(epoch).Hours() / 24
+			b.WriteString(`<c r="` + ref + `"` + styleAttr(styleDate) + `><v>` + strconv.FormatFloat(days, 'f', -1, 64) + `</v></c>`)
+		}
+	}
+	if _, err := b.WriteString(`</row>`); err != nil {
+		return errors.Wrap(err, "writing row")
+	}
+	return nil
+}
+
+func (x *xlsxWriter) flush() error {
+	if err := x.sheet.Flush(); err != nil {
+		return errors.Wrap(err, "writing rows")
+	}
+	if err := x.zw.Flush(); err != nil {
+		return errors.Wrap(err, "writing rows")
+	}
+	flushTo(x.w)
+	return nil
+}
+
+func (x *xlsxWriter) close() error {
+	x.sheet.WriteString(xlsxSheetEnd)
+	if err := x.sheet.Flush(); err != nil {
+		return errors.Wrap(err, "writing sheet")
+	}
+	if err := x.zw.Close(); err != nil {
+		return errors.Wrap(err, "writing spreadsheet")
+	}
+	flushTo(x.w)
+	return nil
+}
+
+// styleAttr gives the attribute setting the style of a cell, or nothing for
+// the default style.
+func styleAttr(style int) string {
+	if style == 0 {
+		return ""
+	}
+	return ` s="` + strconv.Itoa(style) + `"`
+}
+
+// escape escapes s for XML text and attributes. Characters XML cannot hold
+// are replaced.
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// columnName gives the letters naming the column at index i: A to Z, then
+// AA, AB and so on.
+func columnName(i int) string {
+	var name []byte
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = append([]byte{byte('A' + (i-1)%26)}, name...)
+	}
+	return string(name)
+}
@@ -4,10 +4,14 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"sales_service/internal/apikey"
 	"sales_service/internal/platform/auth"
 	"sales_service/internal/platform/web"
+	"sales_service/internal/role"
+	"sales_service/internal/user"
 	"strings"
 
+	"github.com/jmoiron/sqlx"
 	"go.opencensus.io/trace"
 )
 
@@ -15,9 +19,10 @@ import (
 var ErrForbidden = web.NewRequestError(errors.New("request is forbidden"), http.StatusForbidden)
 
 // Authenticate is a middleware function that authenticates the request using a JSON Web Token (JWT)
-// in the Authorization header. It parses the token and adds the claims to the request context.
-// If the token is invalid or missing, it returns an error.
-func Authenticate(authenticator *auth.Authenticator) web.Middleware {
+// in the Authorization header, or an API key given as "ApiKey <key>" in the Authorization header
+// or in the X-API-Key header. It adds the claims to the request context.
+// If the credentials are invalid, missing or were revoked, it returns an error.
+func Authenticate(authenticator *auth.Authenticator, db *sqlx.DB) web.Middleware {
 
 	// Middleware function that wraps the provided handler and authenticates the request.
 	f := func(after web.Handler) web.Handler {
@@ -27,8 +32,31 @@ func Authenticate(authenticator *auth.Authenticator) web.Middleware {
 			ctx, span := trace.StartSpan(ctx, "internal.mid.Auth")
 			defer span.End()
 
-			// Extract the token from the Authorization header and parse it.
 			parts := strings.Split(r.Header.Get("Authorization"), " ")
+
+			// API keys are looked up in the database instead of being parsed.
+			key := r.Header.Get("X-API-Key")
+			if key == "" && len(parts) == 2 && strings.ToLower(parts[0]) == "apikey" {
+				key = parts[1]
+			}
+			if key != "" {
+				v, ok := ctx.Value(web.KeyValues).(*web.Values)
+				if !ok {
+					return errors.New("web value missing from context")
+				}
+
+				claims, err := apikey.Authenticate(ctx, db, key, v.Start)
+				if err != nil {
+					if errors.Is(err, apikey.ErrInvalidSecret) {
+						return web.NewRequestError(err, http.StatusUnauthorized)
+					}
+					return err
+				}
+
+				return authorized(ctx, db, claims, after, w, r)
+			}
+
+			// Otherwise extract the token from the Authorization header and parse it.
 			if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
 				// If the token is missing or has an invalid format, return an error.
 				err := errors.New("expected authorization header format: bearer <token>")
@@ -43,12 +71,18 @@ func Authenticate(authenticator *auth.Authenticator) web.Middleware {
 			}
 			span.End()
 
-			_ = claims
-			// Add the claims to the request context.
-			ctx = context.WithValue(ctx, auth.Key, claims)
+			// Reject tokens that were revoked before they expired.
+			if claims.Id != "" {
+				revoked, err := user.IsTokenRevoked(ctx, db, claims.Id)
+				if err != nil {
+					return err
+				}
+				if revoked {
+					return web.NewRequestError(errors.New("token has been revoked"), http.StatusUnauthorized)
+				}
+			}
 
-			// Call the next handler in the chain.
-			return after(ctx, w, r)
+			return authorized(ctx, db, claims, after, w, r)
 		}
 		return h
 	}
@@ -56,6 +90,25 @@ func Authenticate(authenticator *auth.Authenticator) web.Middleware {
 
 }
 
+// authorized looks up the permissions granted by the roles of claims, adds
+// the claims to the request context and calls the next handler. Claims
+// without an organization were issued before organizations existed and
+// cannot be scoped, so they are rejected.
+func authorized(ctx context.Context, db *sqlx.DB, claims auth.Claims, after web.Handler, w http.ResponseWriter, r *http.Request) error {
+	if claims.OrgID == "" {
+		return web.NewRequestError(errors.New("token has no organization"), http.StatusUnauthorized)
+	}
+
+	perms, err := role.PermissionsFor(ctx, db, claims.Roles)
+	if err != nil {
+		return err
+	}
+	claims.Permissions = perms
+
+	ctx = context.WithValue(ctx, auth.Key, claims)
+	return after(ctx, w, r)
+}
+
 func HasRole(roles ...string) web.Middleware {
 
 	f := func(after web.Handler) web.Handler {
@@ -78,3 +131,29 @@ func HasRole(roles ...string) web.Middleware {
 	}
 	return f
 }
+
+// RequirePermission rejects requests whose claims were not granted one of
+// perms, either for the caller's own resources or for any. Ownership is
+// checked further down with auth.Claims.Can once the resource is known.
+func RequirePermission(perms ...string) web.Middleware {
+
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			ctx, span := trace.StartSpan(ctx, "internal.mid.RequirePermission")
+			defer span.End()
+
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return errors.New("claims missing from request context")
+			}
+
+			if !claims.HasPermission(perms...) {
+				return ErrForbidden
+			}
+			return after(ctx, w, r)
+		}
+		return h
+	}
+	return f
+}
@@ -0,0 +1,41 @@
+package order
+
+import "time"
+
+// Order is a basket of products sold together.
+type Order struct {
+	ID          string      `db:"order_id" json:"id"`
+	UserID      string      `db:"user_id" json:"user_id"`
+	OrgID       string      `db:"org_id" json:"org_id"`
+	Total       int         `db:"total" json:"total"`
+	Lines       []OrderLine `db:"-" json:"lines"`
+	DateCreated time.Time   `db:"date_created" json:"date_created"`
+}
+
+// OrderLine is one product within an order. Lines are stored as sales, so
+// the per-product sale listing and revenue aggregates include them.
+type OrderLine struct {
+	ID          string    `db:"sale_id" json:"id"`
+	OrderID     string    `db:"order_id" json:"order_id"`
+	ProductID   string    `db:"product_id" json:"product_id"`
+	VariantID   *string   `db:"variant_id" json:"variant_id,omitempty"`
+	WarehouseID *string   `db:"warehouse_id" json:"warehouse_id,omitempty"`
+	Quantity    int       `db:"quantity" json:"quantity"`
+	Total       int       `db:"paid" json:"total"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
+
+// NewOrder is what we require from clients when creating an order.
+type NewOrder struct {
+	Lines []NewOrderLine `json:"lines" validate:"required,min=1,dive"`
+}
+
+// NewOrderLine asks for a quantity of a single product, or of one of its
+// variants, drawn from a warehouse when the product is stocked in any. The
+// price is taken from the cost of the product or variant.
+type NewOrderLine struct {
+	ProductID   string `json:"product_id" validate:"required,uuid"`
+	VariantID   string `json:"variant_id" validate:"omitempty,uuid"`
+	WarehouseID string `json:"warehouse_id" validate:"omitempty,uuid"`
+	Quantity    int    `json:"quantity" validate:"gte=1"`
+}
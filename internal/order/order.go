@@ -0,0 +1,297 @@
+package order
+
+import (
+	"context"
+	"database/sql"
+	"sales_service/internal/audit"
+	"sales_service/internal/event"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/product"
+	"sales_service/internal/warehouse"
+	"sort"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrNotFound  = errors.New("order not found")
+	ErrInvalidID = errors.New("invalid order ID format")
+	ErrForbidden = errors.New("action not allowed")
+
+	// ErrMixedOrgs is returned when an order asks for products of several
+	// organizations, which only a super-admin could reach.
+	ErrMixedOrgs = errors.New("order lines must belong to a single organization")
+)
+
+// item is what stock is reserved for: a product, or one of its variants.
+type item struct {
+	productID, variantID string
+}
+
+// draw is what stock is taken from a warehouse for: a product, or one of its
+// variants, and the warehouse it is drawn from, if any.
+type draw struct {
+	warehouseID, productID, variantID string
+}
+
+// Create records an order with all of its lines in a single transaction.
+// Every product is locked and checked for stock before anything is written,
+// so either the whole basket is sold or nothing is. The order belongs to the
+// organization of its products.
+func Create(ctx context.Context, db *sqlx.DB, user auth.Claims, no NewOrder, now time.Time) (*Order, error) {
+	o := Order{
+		ID:          uuid.New().String(),
+		UserID:      user.Subject,
+		DateCreated: now.UTC(),
+	}
+
+	// The same product, or variant, may appear on several lines; stock is
+	// checked against the combined quantity.
+	wanted := make(map[item]int)
+	perProduct := make(map[string]int)
+	drawn := make(map[draw]int)
+	for _, l := range no.Lines {
+		wanted[item{l.ProductID, l.VariantID}] += l.Quantity
+		perProduct[l.ProductID] += l.Quantity
+		drawn[draw{l.WarehouseID, l.ProductID, l.VariantID}] += l.Quantity
+	}
+
+	// Lock products in a stable order so two baskets sharing products cannot
+	// deadlock each other.
+	items := make([]item, 0, len(wanted))
+	for it := range wanted {
+		items = append(items, it)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].productID != items[j].productID {
+			return items[i].productID < items[j].productID
+		}
+		return items[i].variantID < items[j].variantID
+	})
+	draws := make([]draw, 0, len(drawn))
+	for d := range drawn {
+		draws = append(draws, d)
+	}
+	sort.Slice(draws, func(i, j int) bool {
+		if draws[i].warehouseID != draws[j].warehouseID {
+			return draws[i].warehouseID < draws[j].warehouseID
+		}
+		if draws[i].productID != draws[j].productID {
+			return draws[i].productID < draws[j].productID
+		}
+		return draws[i].variantID < draws[j].variantID
+	})
+	ids := make([]string, 0, len(perProduct))
+	for id := range perProduct {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	// Lines are charged the price effective now, which may be a scheduled
+	// change not yet applied to the product. Variants are charged their own
+	// cost.
+	products := make(map[string]*product.Product, len(ids))
+	prices := make(map[item]int, len(items))
+	for _, it := range items {
+		var p *product.Product
+		if it.variantID != "" {
+			var v *product.Variant
+			if p, v, err = product.ReserveVariant(ctx, tx, user, it.productID, it.variantID, wanted[it]); err != nil {
+				return nil, errors.Wrapf(err, "product %s", it.productID)
+			}
+			prices[it] = v.Cost
+		} else {
+			if p, err = product.Reserve(ctx, tx, user, it.productID, wanted[it]); err != nil {
+				return nil, errors.Wrapf(err, "product %s", it.productID)
+			}
+			if prices[it], err = product.PriceAt(ctx, tx, it.productID, now); err != nil {
+				return nil, errors.Wrapf(err, "product %s", it.productID)
+			}
+		}
+		if o.OrgID == "" {
+			o.OrgID = p.OrgID
+		}
+		if p.OrgID != o.OrgID {
+			return nil, ErrMixedOrgs
+		}
+		products[it.productID] = p
+	}
+
+	// Stock levels are locked once every product is, in a stable order too.
+	for _, d := range draws {
+		if err := warehouse.Draw(ctx, tx, user, d.warehouseID, d.productID, d.variantID, drawn[d], now); err != nil {
+			return nil, errors.Wrapf(err, "product %s", d.productID)
+		}
+	}
+
+	const q = `INSERT INTO orders (order_id, user_id, org_id, date_created) VALUES ($1, $2, $3, $4)`
+	if _, err := tx.ExecContext(ctx, q, o.ID, o.UserID, o.OrgID, o.DateCreated); err != nil {
+		return nil, errors.Wrap(err, "inserting order")
+	}
+
+	for _, l := range no.Lines {
+		price := prices[item{l.ProductID, l.VariantID}]
+		line := OrderLine{
+			ID:          uuid.New().String(),
+			OrderID:     o.ID,
+			ProductID:   l.ProductID,
+			Quantity:    l.Quantity,
+			Total:       price * l.Quantity,
+			DateCreated: o.DateCreated,
+		}
+		if l.VariantID != "" {
+			variantID := l.VariantID
+			line.VariantID = &variantID
+		}
+		if l.WarehouseID != "" {
+			warehouseID := l.WarehouseID
+			line.WarehouseID = &warehouseID
+		}
+
+		sale := product.Sale{
+			ID:          line.ID,
+			OrderID:     line.OrderID,
+			ProductID:   line.ProductID,
+			VariantID:   line.VariantID,
+			WarehouseID: line.WarehouseID,
+			Quantity:    line.Quantity,
+			ListPrice:   price,
+			Paid:        line.Total,
+			DateCreated: line.DateCreated,
+		}
+		if err := product.InsertSale(ctx, tx, sale); err != nil {
+			return nil, err
+		}
+		if err := event.Publish(ctx, tx, o.OrgID, event.SaleCreated, sale, now); err != nil {
+			return nil, err
+		}
+
+		o.Lines = append(o.Lines, line)
+		o.Total += line.Total
+	}
+
+	change := audit.Change{
+		OrgID:      o.OrgID,
+		Action:     audit.Create,
+		Resource:   audit.Order,
+		ResourceID: o.ID,
+		After:      o,
+	}
+	if err := audit.Record(ctx, tx, user, change, now); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		if err := product.PublishSoldOut(ctx, tx, *products[id], perProduct[id], now); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing order")
+	}
+
+	return &o, nil
+}
+
+// List retrieves a page of orders of the caller's organization, newest first.
+// When userID is not empty only that user's orders are returned.
+func List(ctx context.Context, db *sqlx.DB, user auth.Claims, userID string, limit, offset int) ([]Order, error) {
+	if limit <= 0 || limit > product.MaxLimit {
+		limit = product.DefaultLimit
+	}
+
+	list := []Order{}
+
+	const q = `SELECT o.order_id, o.user_id, o.org_id, o.date_created,
+	COALESCE(SUM(s.paid),0) AS total
+	FROM orders AS o
+	LEFT JOIN sales AS s ON s.order_id = o.order_id
+	WHERE ($1 = '' OR o.user_id::text = $1)
+	AND ($4::UUID IS NULL OR o.org_id = $4)
+	GROUP BY o.order_id
+	ORDER BY o.date_created DESC, o.order_id
+	LIMIT $2 OFFSET $3`
+
+	if err := db.SelectContext(ctx, &list, q, userID, limit, offset, user.OrgScope()); err != nil {
+		return nil, errors.Wrap(err, "selecting orders")
+	}
+
+	if err := loadLines(ctx, db, list); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Retrieve finds a single order of the caller's organization along with its
+// lines.
+func Retrieve(ctx context.Context, db *sqlx.DB, user auth.Claims, id string) (*Order, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var o Order
+
+	const q = `SELECT o.order_id, o.user_id, o.org_id, o.date_created,
+	COALESCE(SUM(s.paid),0) AS total
+	FROM orders AS o
+	LEFT JOIN sales AS s ON s.order_id = o.order_id
+	WHERE o.order_id = $1 AND ($2::UUID IS NULL OR o.org_id = $2)
+	GROUP BY o.order_id`
+
+	if err := db.GetContext(ctx, &o, q, id, user.OrgScope()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting order")
+	}
+
+	orders := []Order{o}
+	if err := loadLines(ctx, db, orders); err != nil {
+		return nil, err
+	}
+
+	return &orders[0], nil
+}
+
+// loadLines fills in the lines of every order in the slice with one query.
+func loadLines(ctx context.Context, db *sqlx.DB, orders []Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(orders))
+	index := make(map[string]int, len(orders))
+	for i, o := range orders {
+		ids[i] = o.ID
+		index[o.ID] = i
+		orders[i].Lines = []OrderLine{}
+	}
+
+	var lines []OrderLine
+
+	const q = `SELECT sale_id, order_id, product_id, variant_id, warehouse_id, quantity, paid, date_created
+	FROM sales WHERE order_id = ANY($1) ORDER BY date_created, sale_id`
+
+	if err := db.SelectContext(ctx, &lines, q, pq.Array(ids)); err != nil {
+		return errors.Wrap(err, "selecting order lines")
+	}
+
+	for _, l := range lines {
+		i := index[l.OrderID]
+		orders[i].Lines = append(orders[i].Lines, l)
+	}
+
+	return nil
+}
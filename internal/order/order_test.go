@@ -0,0 +1,86 @@
+package order
+
+import (
+	"context"
+	"sales_service/internal/organization"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/database/databasetest"
+	"sales_service/internal/product"
+	"sales_service/internal/schema"
+	"testing"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOrders(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+
+	if err := schema.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+
+	const (
+		city  = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a21"
+		chima = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"
+	)
+
+	no := NewOrder{Lines: []NewOrderLine{
+		{ProductID: city, Quantity: 2},
+		{ProductID: chima, Quantity: 1},
+	}}
+
+	created, err := Create(ctx, db, claims, no, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if created.Total != 2*3000+2000 {
+		t.Fatalf("expected total %d, got %d", 2*3000+2000, created.Total)
+	}
+
+	got, err := Retrieve(ctx, db, claims, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(created.Total, got.Total); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+	if len(got.Lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(got.Lines))
+	}
+
+	// The lines count as sales of their products.
+	p, err := product.Retrieve(ctx, db, claims, city, product.RetrieveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Sold != 5 {
+		t.Fatalf("expected 5 sold, got %d", p.Sold)
+	}
+
+	// An order that cannot be filled in full writes nothing.
+	no = NewOrder{Lines: []NewOrderLine{
+		{ProductID: chima, Quantity: 1},
+		{ProductID: city, Quantity: 1000},
+	}}
+	if _, err := Create(ctx, db, claims, no, now); !errors.Is(err, product.ErrInsufficientStock) {
+		t.Fatalf("expected ErrInsufficientStock, got %v", err)
+	}
+
+	p, err = product.Retrieve(ctx, db, claims, chima, product.RetrieveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Sold != 2 {
+		t.Fatalf("expected 2 sold, got %d", p.Sold)
+	}
+}
@@ -0,0 +1,18 @@
+package organization
+
+import "time"
+
+// Organization is a tenant of the service, such as a single store. Users,
+// products and orders each belong to one organization.
+type Organization struct {
+	ID          string    `db:"org_id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// NewOrganization is what we require from clients when adding an
+// organization.
+type NewOrganization struct {
+	Name string `json:"name" validate:"required"`
+}
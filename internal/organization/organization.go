@@ -0,0 +1,70 @@
+package organization
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultID is the organization data created before organizations existed
+// was moved into.
+const DefaultID = "d0eebc99-9c0b-4ef8-bb6d-6bb9bd390a01"
+
+var (
+	ErrNotFound  = errors.New("organization not found")
+	ErrInvalidID = errors.New("invalid organization ID format")
+)
+
+// Create adds an organization.
+func Create(ctx context.Context, db *sqlx.DB, no NewOrganization, now time.Time) (*Organization, error) {
+	now = now.UTC().Truncate(time.Microsecond)
+
+	o := Organization{
+		ID:          uuid.New().String(),
+		Name:        no.Name,
+		DateCreated: now,
+		DateUpdated: now,
+	}
+
+	const q = `INSERT INTO organizations (org_id, name, date_created, date_updated) VALUES ($1, $2, $3, $4)`
+	if _, err := db.ExecContext(ctx, q, o.ID, o.Name, o.DateCreated, o.DateUpdated); err != nil {
+		return nil, errors.Wrap(err, "inserting organization")
+	}
+
+	return &o, nil
+}
+
+// List gives every organization ordered by name.
+func List(ctx context.Context, db *sqlx.DB) ([]Organization, error) {
+	list := []Organization{}
+
+	const q = `SELECT org_id, name, date_created, date_updated FROM organizations ORDER BY name`
+	if err := db.SelectContext(ctx, &list, q); err != nil {
+		return nil, errors.Wrap(err, "selecting organizations")
+	}
+
+	return list, nil
+}
+
+// Retrieve gets a single organization.
+func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*Organization, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var o Organization
+
+	const q = `SELECT org_id, name, date_created, date_updated FROM organizations WHERE org_id = $1`
+	if err := db.GetContext(ctx, &o, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting organization %q", id)
+	}
+
+	return &o, nil
+}
@@ -30,9 +30,20 @@ func NewSimpleKeyLookupFunc(activeKID string, publicKey *rsa.PublicKey) KeyLooku
 	return f
 }
 
+// ErrVerifyOnly is returned when a token is requested from an Authenticator
+// that has no private key.
+var ErrVerifyOnly = errors.New("authenticator can only verify tokens")
+
+// signingKeyFunc returns the kid and private key new tokens are signed with.
+type signingKeyFunc func() (string, *rsa.PrivateKey)
+
+// publicKeysFunc returns the public keys an Authenticator publishes, indexed
+// by kid.
+type publicKeysFunc func() map[string]*rsa.PublicKey
+
 type Authenticator struct {
-	PrivateKey       *rsa.PrivateKey
-	activeKID        string
+	signingKey       signingKeyFunc
+	publicKeys       publicKeysFunc
 	algorithm        string
 	pubKeyLookupFunc KeyLookupFunc
 	parser           *jwt.Parser
@@ -47,6 +58,46 @@ func NewAuthenticator(privateKey *rsa.PrivateKey, activeKID string, algorithm st
 		return nil, errors.New("active kid cannot be empty")
 	}
 
+	signingKey := func() (string, *rsa.PrivateKey) {
+		return activeKID, privateKey
+	}
+
+	a, err := newAuthenticator(signingKey, algorithm, pubKeyLookupFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	a.publicKeys = func() map[string]*rsa.PublicKey {
+		return map[string]*rsa.PublicKey{activeKID: &privateKey.PublicKey}
+	}
+	return a, nil
+}
+
+// NewKeyRingAuthenticator creates an Authenticator that signs with the active
+// key of the ring and verifies tokens signed by any key in it. Reloading the
+// ring takes effect immediately.
+func NewKeyRingAuthenticator(ring *KeyRing, algorithm string) (*Authenticator, error) {
+	if ring == nil {
+		return nil, errors.New("key ring cannot be nil")
+	}
+
+	a, err := newAuthenticator(ring.Active, algorithm, ring.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	a.publicKeys = ring.PublicKeys
+	return a, nil
+}
+
+// NewVerifier creates an Authenticator that only verifies tokens, for
+// services that trust tokens issued elsewhere. Combined with
+// NewJWKSKeyLookupFunc it needs no access to the issuer's private key.
+func NewVerifier(algorithm string, pubKeyLookupFunc KeyLookupFunc) (*Authenticator, error) {
+	return newAuthenticator(nil, algorithm, pubKeyLookupFunc)
+}
+
+func newAuthenticator(signingKey signingKeyFunc, algorithm string, pubKeyLookupFunc KeyLookupFunc) (*Authenticator, error) {
 	if jwt.GetSigningMethod(algorithm) == nil {
 		return nil, fmt.Errorf("invalid signing method: %s", algorithm)
 	}
@@ -60,8 +111,7 @@ func NewAuthenticator(privateKey *rsa.PrivateKey, activeKID string, algorithm st
 	}
 
 	a := &Authenticator{
-		PrivateKey:       privateKey,
-		activeKID:        activeKID,
+		signingKey:       signingKey,
 		algorithm:        algorithm,
 		pubKeyLookupFunc: pubKeyLookupFunc,
 		parser:           &parser,
@@ -69,9 +119,28 @@ func NewAuthenticator(privateKey *rsa.PrivateKey, activeKID string, algorithm st
 	return a, nil
 }
 
+// CanSign reports whether the Authenticator holds a private key and can
+// generate tokens.
+func (a *Authenticator) CanSign() bool {
+	return a.signingKey != nil
+}
+
+// JWKS returns the public keys tokens are signed with as a JSON Web Key Set.
+// A verify-only Authenticator publishes no keys.
+func (a *Authenticator) JWKS() JWKS {
+	if a.publicKeys == nil {
+		return NewJWKS(nil, a.algorithm)
+	}
+	return NewJWKS(a.publicKeys(), a.algorithm)
+}
+
 // GenerateToken generates a JWT token using the provided claims and returns the
 // token as a string. It uses the Authenticator's private key to sign the token.
 func (a *Authenticator) GenerateToken(claims Claims) (string, error) {
+	if !a.CanSign() {
+		return "", ErrVerifyOnly
+	}
+
 	// Get the signing method based on the algorithm specified in the authenticator.
 	method := jwt.GetSigningMethod(a.algorithm)
 
@@ -79,10 +148,11 @@ func (a *Authenticator) GenerateToken(claims Claims) (string, error) {
 	tkn := jwt.NewWithClaims(method, claims)
 
 	// Add the active kid to the token's header.
-	tkn.Header["kid"] = a.activeKID
+	kid, key := a.signingKey()
+	tkn.Header["kid"] = kid
 
-	// Sign the token using the authenticator's private key.
-	str, err := tkn.SignedString(a.PrivateKey)
+	// Sign the token using the active private key.
+	str, err := tkn.SignedString(key)
 
 	// If there was an error signing the token, return the error.
 	if err != nil {
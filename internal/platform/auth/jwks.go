@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// jwksMinRefresh limits how often an unknown kid may trigger a fetch of the
+// remote key set.
+const jwksMinRefresh = 30 * time.Second
+
+// JWK is a JSON Web Key holding an RSA public key, as described in RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWKS builds a key set from public keys indexed by kid. Keys are sorted
+// by kid so the output is stable.
+func NewJWKS(keys map[string]*rsa.PublicKey, algorithm string) JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for kid, key := range keys {
+		set.Keys = append(set.Keys, JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: algorithm,
+			Kid: kid,
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// PublicKey decodes the RSA public key held by the JWK.
+func (k JWK) PublicKey() (*rsa.PublicKey, error) {
+	if k.Kty != "RSA" {
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding modulus")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding exponent")
+	}
+
+	key := rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}
+	return &key, nil
+}
+
+// jwksCache keeps the keys of a remote JWKS endpoint in memory.
+type jwksCache struct {
+	url    string
+	client *http.Client
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time     // last successful fetch
+	tried   time.Time     // last fetch, successful or not
+	err     error         // error of the last fetch
+	done    chan struct{} // closed when the fetch in flight ends
+}
+
+// NewJWKSKeyLookupFunc creates a KeyLookupFunc that reads public keys from a
+// remote JWKS endpoint such as the one published by another sales-api. Keys
+// are cached for ttl. An unknown kid triggers an early refresh, since the
+// issuer may have rotated its keys. When a refresh fails, keys already in the
+// cache keep being used.
+func NewJWKSKeyLookupFunc(url string, client *http.Client, ttl time.Duration) KeyLookupFunc {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	c := jwksCache{
+		url:    url,
+		client: client,
+		ttl:    ttl,
+	}
+	return c.lookup
+}
+
+// lookup returns the cached key with the given kid, fetching the key set
+// again when needed. The endpoint is fetched without holding the lock and by
+// one lookup at a time; others looking for an unknown kid wait for it, the
+// rest keep using the cache. Fetches, failed ones included, are not retried
+// within jwksMinRefresh of each other.
+func (c *jwksCache) lookup(kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+
+	_, ok := c.keys[kid]
+	fresh := time.Since(c.fetched) <= c.ttl
+	done := c.done
+
+	switch {
+	case ok && (fresh || done != nil), done == nil && time.Since(c.tried) < jwksMinRefresh:
+		// Answer from the cache.
+	case done != nil:
+		// Wait for the fetch another lookup started.
+		c.mu.Unlock()
+		<-done
+		c.mu.Lock()
+	default:
+		// Fetch, letting other lookups in meanwhile.
+		done = make(chan struct{})
+		c.done, c.tried = done, time.Now()
+		c.mu.Unlock()
+
+		keys, err := c.fetch()
+
+		c.mu.Lock()
+		if err == nil {
+			c.keys, c.fetched = keys, time.Now()
+		}
+		c.err, c.done = err, nil
+		close(done)
+	}
+	defer c.mu.Unlock()
+
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	return nil, fmt.Errorf("unrecognized key: %v", kid)
+}
+
+// fetch reads the keys served by the endpoint.
+func (c *jwksCache) fetch() (map[string]*rsa.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating JWKS request")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching JWKS")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: unexpected status %s", resp.Status)
+	}
+
+	var set JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, errors.Wrap(err, "decoding JWKS")
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := k.PublicKey()
+		if err != nil {
+			return nil, errors.Wrapf(err, "key %q", k.Kid)
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
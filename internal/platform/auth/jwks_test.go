@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJWKSVerifier(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer, err := NewAuthenticator(key, "1", "RS256", NewSimpleKeyLookupFunc("1", &key.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(issuer.JWKS())
+	}))
+	defer srv.Close()
+
+	verifier, err := NewVerifier("RS256", NewJWKSKeyLookupFunc(srv.URL, srv.Client(), time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := verifier.GenerateToken(Claims{}); err != ErrVerifyOnly {
+		t.Fatalf("expected ErrVerifyOnly, got %v", err)
+	}
+
+	claims := NewClaims("user", "org", []string{RoleUser}, time.Now(), time.Hour)
+	token, err := issuer.GenerateToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := verifier.ParseClaims(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Subject != claims.Subject {
+			t.Fatalf("expected subject %q, got %q", claims.Subject, got.Subject)
+		}
+	}
+
+	if fetches != 1 {
+		t.Fatalf("expected the key set to be fetched once, got %d", fetches)
+	}
+}
+
+func TestJWKSFailedFetch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer, err := NewAuthenticator(key, "1", "RS256", NewSimpleKeyLookupFunc("1", &key.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(issuer.JWKS())
+	}))
+	defer srv.Close()
+
+	c := jwksCache{url: srv.URL, client: srv.Client(), ttl: time.Hour}
+
+	// A failed fetch is not retried right away, however many lookups come.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.lookup("1"); err == nil {
+				t.Error("expected the lookup to fail")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("expected the key set to be fetched once, got %d", n)
+	}
+
+	// Once jwksMinRefresh has passed the fetch is tried again.
+	c.tried = c.tried.Add(-jwksMinRefresh)
+
+	if _, err := c.lookup("1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Fatalf("expected the key set to be fetched twice, got %d", n)
+	}
+}
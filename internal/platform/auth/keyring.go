@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// KeyRing holds every RSA private key found in a directory, keyed by kid.
+// The kid of a key is its file name without the .pem extension. Tokens are
+// signed with the active key and verified with whichever key their kid names,
+// so tokens signed with a retired key keep working until they expire as long
+// as its file stays in the directory.
+type KeyRing struct {
+	dir string
+
+	mu        sync.RWMutex
+	activeKID string
+	keys      map[string]*rsa.PrivateKey
+}
+
+// NewKeyRing loads the keys in dir and makes activeKID the signing key.
+func NewKeyRing(dir, activeKID string) (*KeyRing, error) {
+	kr := KeyRing{dir: dir}
+	if err := kr.Load(activeKID); err != nil {
+		return nil, err
+	}
+	return &kr, nil
+}
+
+// Load reads the directory again and makes activeKID the signing key. It is
+// safe to call while tokens are being signed and verified. If anything goes
+// wrong the previously loaded keys stay in use.
+func (kr *KeyRing) Load(activeKID string) error {
+	files, err := filepath.Glob(filepath.Join(kr.dir, "*.pem"))
+	if err != nil {
+		return errors.Wrap(err, "listing key files")
+	}
+
+	keys := make(map[string]*rsa.PrivateKey, len(files))
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return errors.Wrapf(err, "reading key file %s", file)
+		}
+
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(contents)
+		if err != nil {
+			return errors.Wrapf(err, "parsing key file %s", file)
+		}
+
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		keys[kid] = key
+	}
+
+	if _, ok := keys[activeKID]; !ok {
+		return fmt.Errorf("active key %q not found in %s", activeKID, kr.dir)
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	kr.activeKID = activeKID
+	kr.keys = keys
+
+	return nil
+}
+
+// Active returns the kid and private key used for signing.
+func (kr *KeyRing) Active() (string, *rsa.PrivateKey) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	return kr.activeKID, kr.keys[kr.activeKID]
+}
+
+// PublicKey returns the public key with the given kid. It satisfies
+// KeyLookupFunc.
+func (kr *KeyRing) PublicKey(kid string) (*rsa.PublicKey, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	key, ok := kr.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unrecognized key: %v", kid)
+	}
+	return &key.PublicKey, nil
+}
+
+// PublicKeys returns the public keys of every key in the ring.
+func (kr *KeyRing) PublicKeys() map[string]*rsa.PublicKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	keys := make(map[string]*rsa.PublicKey, len(kr.keys))
+	for kid, key := range kr.keys {
+		keys[kid] = &key.PublicKey
+	}
+	return keys
+}
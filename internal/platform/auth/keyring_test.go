@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyRingRotation(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "old")
+
+	ring, err := NewKeyRing(dir, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewKeyRingAuthenticator(ring, "RS256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	oldToken, err := a.GenerateToken(NewClaims("user", "org", []string{RoleUser}, now, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate: add a new key and make it the active one.
+	writeKey(t, dir, "new")
+	if err := ring.Load("new"); err != nil {
+		t.Fatal(err)
+	}
+
+	if kid, _ := ring.Active(); kid != "new" {
+		t.Fatalf("expected active key %q, got %q", "new", kid)
+	}
+
+	// Tokens signed with the retired key still verify.
+	if _, err := a.ParseClaims(oldToken); err != nil {
+		t.Fatalf("parsing token signed with retired key: %v", err)
+	}
+
+	newToken, err := a.GenerateToken(NewClaims("user", "org", []string{RoleUser}, now, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.ParseClaims(newToken); err != nil {
+		t.Fatalf("parsing token signed with new key: %v", err)
+	}
+
+	// Once the retired key file is removed its tokens are rejected.
+	if err := os.Remove(filepath.Join(dir, "old.pem")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.Load("new"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.ParseClaims(oldToken); err == nil {
+		t.Fatal("expected token signed with removed key to be rejected")
+	}
+
+	// A missing active key leaves the ring unchanged.
+	if err := ring.Load("missing"); err == nil {
+		t.Fatal("expected error for missing active key")
+	}
+	if kid, _ := ring.Active(); kid != "new" {
+		t.Fatalf("expected active key %q, got %q", "new", kid)
+	}
+}
+
+// writeKey generates an RSA key and stores it as dir/kid.pem.
+func writeKey(t *testing.T, dir, kid string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), pem.EncodeToMemory(&block), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
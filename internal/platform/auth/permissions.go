@@ -0,0 +1,79 @@
+package auth
+
+// Permissions a role can be granted. Permissions on resources that have an
+// owner only cover the caller's own resources; the form returned by Any
+// covers everyone's.
+const (
+	PermProductRead     = "product:read"
+	PermProductCreate   = "product:create"
+	PermProductWrite    = "product:write"
+	PermProductDelete   = "product:delete"
+	PermProductRestore  = "product:restore"
+	PermProductPurge    = "product:purge"
+	PermProductRollback = "product:rollback"
+
+	PermCategoryManage = "category:manage"
+
+	PermSaleRead   = "sale:read"
+	PermSaleCreate = "sale:create"
+	PermSaleRefund = "sale:refund"
+
+	PermOrderRead   = "order:read"
+	PermOrderCreate = "order:create"
+
+	PermReportRead = "report:read"
+
+	PermUserRead   = "user:read"
+	PermUserCreate = "user:create"
+	PermUserWrite  = "user:write"
+	PermUserDelete = "user:delete"
+	PermUserRoles  = "user:roles"
+
+	PermAPIKeyManage = "apikey:manage"
+
+	PermRoleManage = "role:manage"
+
+	PermOrgManage = "organization:manage"
+
+	PermAuditRead = "audit:read"
+
+	PermWebhookManage = "webhook:manage"
+
+	PermWarehouseManage = "warehouse:manage"
+	PermStockTransfer   = "stock:transfer"
+)
+
+// Any returns the form of perm that applies to resources of every owner.
+func Any(perm string) string {
+	return perm + ":any"
+}
+
+// HasPermission reports whether the claims were granted any of perms, either
+// directly or in their Any form.
+func (c Claims) HasPermission(perms ...string) bool {
+	for _, has := range c.Permissions {
+		for _, want := range perms {
+			if has == want || has == Any(want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Can is the ownership policy shared by every resource. It reports whether
+// the claims allow perm on a resource owned by ownerID: the Any form of perm
+// allows it for every resource, the plain form only when the caller is the
+// owner. A product is owned by the user who created it, a sale or order by
+// the user who placed it, and a user, with its API keys, by itself.
+func (c Claims) Can(perm, ownerID string) bool {
+	for _, has := range c.Permissions {
+		if has == Any(perm) {
+			return true
+		}
+		if has == perm && ownerID != "" && ownerID == c.Subject {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClaimsCan(t *testing.T) {
+	const (
+		owner = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a04"
+		other = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03"
+	)
+
+	own := NewClaims(owner, "org", []string{RoleUser}, time.Now(), time.Hour)
+	own.Permissions = []string{PermProductWrite}
+
+	anyone := NewClaims(other, "org", []string{RoleAdmin}, time.Now(), time.Hour)
+	anyone.Permissions = []string{Any(PermProductWrite)}
+
+	tests := []struct {
+		name   string
+		claims Claims
+		perm   string
+		owner  string
+		want   bool
+	}{
+		{"own resource", own, PermProductWrite, owner, true},
+		{"someone else's resource", own, PermProductWrite, other, false},
+		{"resource without owner", own, PermProductWrite, "", false},
+		{"other permission", own, PermProductDelete, owner, false},
+		{"any owner", anyone, PermProductWrite, owner, true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.claims.Can(tt.perm, tt.owner); got != tt.want {
+			t.Errorf("%s: Can(%q, %q) = %v, want %v", tt.name, tt.perm, tt.owner, got, tt.want)
+		}
+	}
+
+	// Holding the :any form satisfies a route that only needs the plain one.
+	if !anyone.HasPermission(PermProductWrite) {
+		t.Errorf("expected %s to imply %s", Any(PermProductWrite), PermProductWrite)
+	}
+	if own.HasPermission(Any(PermProductWrite)) {
+		t.Errorf("expected %s not to imply %s", PermProductWrite, Any(PermProductWrite))
+	}
+}
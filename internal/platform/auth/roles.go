@@ -4,11 +4,15 @@ import (
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 )
 
 const (
 	RoleAdmin = "ADMIN"
 	RoleUser  = "USER"
+
+	// RoleSuperAdmin is not limited to the organization of the user.
+	RoleSuperAdmin = "SUPERADMIN"
 )
 
 type ctxKey int
@@ -17,13 +21,20 @@ const Key ctxKey = 1
 
 type Claims struct {
 	Roles []string `json:"roles"`
+	OrgID string   `json:"org,omitempty"`
 	jwt.StandardClaims
+
+	// Permissions granted by Roles. They are looked up for every request
+	// and never stored in the token, so role changes apply immediately.
+	Permissions []string `json:"-"`
 }
 
-func NewClaims(subject string, roles []string, now time.Time, expires time.Duration) Claims {
+func NewClaims(subject, orgID string, roles []string, now time.Time, expires time.Duration) Claims {
 	c := Claims{
 		Roles: roles,
+		OrgID: orgID,
 		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
 			Subject:   subject,
 			IssuedAt:  now.Unix(),
 			ExpiresAt: now.Add(expires).Unix(),
@@ -42,3 +53,13 @@ func (c Claims) HasRole(roles ...string) bool {
 	}
 	return false
 }
+
+// OrgScope returns the organization the caller's queries are limited to, or
+// nil for a super-admin who may reach every organization. It is meant to be
+// passed as a nullable query parameter.
+func (c Claims) OrgScope() *string {
+	if c.HasRole(RoleSuperAdmin) {
+		return nil
+	}
+	return &c.OrgID
+}
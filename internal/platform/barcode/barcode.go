@@ -0,0 +1,106 @@
+// Package barcode checks and draws the EAN and UPC barcodes printed on
+// products: EAN-13, UPC-A, which is an EAN-13 starting with a zero, and the
+// short EAN-8.
+package barcode
+
+import (
+	"errors"
+)
+
+// ErrInvalid is returned for codes that are not a valid EAN-13, UPC-A or
+// EAN-8 barcode.
+var ErrInvalid = errors.New("barcode must be a valid EAN-13, UPC-A or EAN-8 code")
+
+// Valid reports whether code is 8, 12 or 13 digits ending in the right check
+// digit.
+func Valid(code string) bool {
+	switch len(code) {
+	case 8, 12, 13:
+	default:
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return checkDigit(code[:len(code)-1]) == int(code[len(code)-1]-'0')
+}
+
+// checkDigit computes the check digit of the other digits of a code. From
+// the right, digits are weighted 3 and 1 in turn, and the check digit takes
+// their sum up to a multiple of ten.
+func checkDigit(digits string) int {
+	var sum int
+	for i := range digits {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 0 {
+			d *= 3
+		}
+		sum += d
+	}
+	return (10 - sum%10) % 10
+}
+
+// The patterns of every digit, one bit per module from left to right, dark
+// modules set. Digits left of the middle guard are drawn with the L or G
+// patterns, digits right of it with the R patterns.
+var (
+	lPatterns = [10]string{"0001101", "0011001", "0010011", "0111101", "0100011", "0110001", "0101111", "0111011", "0110111", "0001011"}
+	gPatterns = [10]string{"0100111", "0110011", "0011011", "0100001", "0011101", "0111001", "0000101", "0010001", "0001001", "0010111"}
+	rPatterns = [10]string{"1110010", "1100110", "1101100", "1000010", "1011100", "1001110", "1010000", "1000100", "1001000", "1110100"}
+)
+
+// parities tell, for the first digit of an EAN-13, which of the six digits
+// left of the middle guard use the G patterns. The first digit is not drawn
+// itself, only through this choice.
+var parities = [10]string{"LLLLLL", "LLGLGG", "LLGGLG", "LLGGGL", "LGLLGG", "LGGLLG", "LGGGLL", "LGLGLG", "LGLGGL", "LGGLGL"}
+
+// The guards framing the digits.
+const (
+	sideGuard   = "101"
+	middleGuard = "01010"
+)
+
+// Encode gives the modules of the barcode of code from left to right, true
+// for dark ones, quiet zones left out: 95 for an EAN-13 or UPC-A and 67 for
+// an EAN-8.
+func Encode(code string) ([]bool, error) {
+	if !Valid(code) {
+		return nil, ErrInvalid
+	}
+
+	// A UPC-A is drawn as the EAN-13 with a leading zero.
+	if len(code) == 12 {
+		code = "0" + code
+	}
+
+	var left, right, parity string
+	switch len(code) {
+	case 13:
+		left, right, parity = code[1:7], code[7:], parities[code[0]-'0']
+	default:
+		left, right, parity = code[:4], code[4:], "LLLL"
+	}
+
+	pattern := sideGuard
+	for i := range left {
+		d := left[i] - '0'
+		if parity[i] == 'G' {
+			pattern += gPatterns[d]
+		} else {
+			pattern += lPatterns[d]
+		}
+	}
+	pattern += middleGuard
+	for i := range right {
+		pattern += rPatterns[right[i]-'0']
+	}
+	pattern += sideGuard
+
+	modules := make([]bool, len(pattern))
+	for i := range pattern {
+		modules[i] = pattern[i] == '1'
+	}
+	return modules, nil
+}
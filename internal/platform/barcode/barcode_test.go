@@ -0,0 +1,148 @@
+package barcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{"4006381333931", true},
+		{"4006381333932", false},
+		{"036000291452", true},
+		{"036000291453", false},
+		{"96385074", true},
+		{"96385075", false},
+		{"400638133393", false},
+		{"40063813339a", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := Valid(tt.code); got != tt.want {
+			t.Errorf("Valid(%q): expected %v, got %v", tt.code, tt.want, got)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		code    string
+		modules int
+		want    string
+	}{
+		{"4006381333931", 95, "4006381333931"},
+		{"036000291452", 95, "0036000291452"},
+		{"96385074", 67, "96385074"},
+	}
+
+	for _, tt := range tests {
+		modules, err := Encode(tt.code)
+		if err != nil {
+			t.Fatalf("encoding %s: %v", tt.code, err)
+		}
+		if len(modules) != tt.modules {
+			t.Fatalf("encoding %s: expected %d modules, got %d", tt.code, tt.modules, len(modules))
+		}
+		if got := decode(t, modules); got != tt.want {
+			t.Errorf("encoding %s: decoded back to %s", tt.code, got)
+		}
+	}
+
+	if _, err := Encode("4006381333932"); err != ErrInvalid {
+		t.Fatalf("expected ErrInvalid, got %v", err)
+	}
+}
+
+// decode reads the digits back from the modules of a barcode, checking the
+// guards on the way.
+func decode(t *testing.T, modules []bool) string {
+	var s strings.Builder
+	for _, m := range modules {
+		if m {
+			s.WriteByte('1')
+		} else {
+			s.WriteByte('0')
+		}
+	}
+	bits := s.String()
+
+	n := (len(bits) - 2*len(sideGuard) - len(middleGuard)) / 14
+	mid := len(sideGuard) + 7*n
+	if bits[:3] != sideGuard || bits[len(bits)-3:] != sideGuard || bits[mid:mid+5] != middleGuard {
+		t.Fatalf("guards missing from %s", bits)
+	}
+
+	find := func(patterns [10]string, p string) int {
+		for d, q := range patterns {
+			if q == p {
+				return d
+			}
+		}
+		return -1
+	}
+
+	var digits, parity string
+	for i := 0; i < n; i++ {
+		p := bits[3+7*i : 10+7*i]
+		if d := find(lPatterns, p); d >= 0 {
+			digits += string(rune('0' + d))
+			parity += "L"
+		} else if d := find(gPatterns, p); d >= 0 {
+			digits += string(rune('0' + d))
+			parity += "G"
+		} else {
+			t.Fatalf("unknown left pattern %s", p)
+		}
+	}
+	for i := 0; i < n; i++ {
+		p := bits[mid+5+7*i : mid+12+7*i]
+		d := find(rPatterns, p)
+		if d < 0 {
+			t.Fatalf("unknown right pattern %s", p)
+		}
+		digits += string(rune('0' + d))
+	}
+
+	// The first digit of an EAN-13 is only told by the parity of the left
+	// half.
+	if n == 6 {
+		for d, q := range parities {
+			if q == parity {
+				return string(rune('0'+d)) + digits
+			}
+		}
+		t.Fatalf("unknown parity %s", parity)
+	}
+	return digits
+}
+
+func TestLabels(t *testing.T) {
+	var buf bytes.Buffer
+	if err := SVG(&buf, "4006381333931", "Tom & Jerry", 2); err != nil {
+		t.Fatal(err)
+	}
+	svg := buf.String()
+	for _, want := range []string{`width="234"`, `>Tom &amp; Jerry</text>`, `>4006381333931</text>`} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("svg is missing %s", want)
+		}
+	}
+
+	buf.Reset()
+	if err := PNG(&buf, "96385074", 3); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.Bounds().Dx(); got != (67+2*quietZone)*3 {
+		t.Fatalf("expected a width of %d, got %d", (67+2*quietZone)*3, got)
+	}
+}
@@ -0,0 +1,144 @@
+package barcode
+
+import (
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// The layout of a label, in modules, the width of the thinnest bar.
+const (
+	quietZone     = 11
+	margin        = 4
+	captionHeight = 12
+	barHeight     = 60
+	textGap       = 3
+	digitHeight   = 7
+)
+
+// digits is a 5 by 7 font for the digits printed under the bars of a PNG
+// label, a row of five pixels per string.
+var digits = [10][7]string{
+	{"01110", "10001", "10011", "10101", "11001", "10001", "01110"},
+	{"00100", "01100", "00100", "00100", "00100", "00100", "01110"},
+	{"01110", "10001", "00001", "00010", "00100", "01000", "11111"},
+	{"11111", "00010", "00100", "00010", "00001", "10001", "01110"},
+	{"00010", "00110", "01010", "10010", "11111", "00010", "00010"},
+	{"11111", "10000", "11110", "00001", "00001", "10001", "01110"},
+	{"00110", "01000", "10000", "11110", "10001", "10001", "01110"},
+	{"11111", "00001", "00010", "00100", "01000", "01000", "01000"},
+	{"01110", "10001", "10001", "01110", "10001", "10001", "01110"},
+	{"01110", "10001", "10001", "01111", "00001", "00010", "01100"},
+}
+
+// SVG writes a label for code to w: the caption, if any, over the bars and
+// the digits under them. Every module is scale pixels wide.
+func SVG(w io.Writer, code, caption string, scale int) error {
+	modules, err := Encode(code)
+	if err != nil {
+		return err
+	}
+
+	top := margin
+	if caption != "" {
+		top += captionHeight
+	}
+	width := len(modules) + 2*quietZone
+	height := top + barHeight + textGap + digitHeight + margin
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`,
+		width*scale, height*scale, width, height)
+	b.WriteString(`<rect width="100%" height="100%" fill="#fff"/>`)
+
+	if caption != "" {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-family="sans-serif" font-size="9" text-anchor="middle">`, width/2, margin+9)
+		xml.EscapeText(&b, []byte(caption))
+		b.WriteString(`</text>`)
+	}
+
+	b.WriteString(`<g fill="#000">`)
+	for _, r := range runs(modules) {
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d"/>`, quietZone+r.x, top, r.width, barHeight)
+	}
+	b.WriteString(`</g>`)
+
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-family="monospace" font-size="9" text-anchor="middle" letter-spacing="2">%s</text>`,
+		width/2, top+barHeight+textGap+digitHeight, code)
+	b.WriteString(`</svg>`)
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// PNG writes a label for code to w: the bars with the digits under them.
+// Every module is scale pixels wide. PNG labels carry no caption, there is
+// no font to draw it with.
+func PNG(w io.Writer, code string, scale int) error {
+	modules, err := Encode(code)
+	if err != nil {
+		return err
+	}
+
+	width := len(modules) + 2*quietZone
+	height := margin + barHeight + textGap + digitHeight + margin
+
+	img := image.NewGray(image.Rect(0, 0, width*scale, height*scale))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+
+	// fill paints a rectangle given in modules black.
+	fill := func(x, y, dx, dy int) {
+		for py := y * scale; py < (y+dy)*scale; py++ {
+			for px := x * scale; px < (x+dx)*scale; px++ {
+				img.SetGray(px, py, color.Gray{})
+			}
+		}
+	}
+
+	for _, r := range runs(modules) {
+		fill(quietZone+r.x, margin, r.width, barHeight)
+	}
+
+	// The digits are spaced by one module and centered under the bars.
+	y := margin + barHeight + textGap
+	x := (width - 6*len(code) + 1) / 2
+	for _, c := range code {
+		for row, bits := range digits[c-'0'] {
+			for col := range bits {
+				if bits[col] == '1' {
+					fill(x+col, y+row, 1, 1)
+				}
+			}
+		}
+		x += 6
+	}
+
+	return png.Encode(w, img)
+}
+
+// bar is a run of dark modules, starting at module x.
+type bar struct {
+	x, width int
+}
+
+// runs gives the bars of a barcode, from left to right.
+func runs(modules []bool) []bar {
+	var bars []bar
+	for i := 0; i < len(modules); i++ {
+		if !modules[i] {
+			continue
+		}
+		start := i
+		for i < len(modules) && modules[i] {
+			i++
+		}
+		bars = append(bars, bar{x: start, width: i - start})
+	}
+	return bars
+}
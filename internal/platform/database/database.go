@@ -2,7 +2,6 @@ package database
 
 import (
 	"context"
-	"fmt"
 	"net/url"
 
 	"github.com/jmoiron/sqlx"
@@ -33,7 +32,6 @@ func OpenDB(cfg Config) (*sqlx.DB, error) {
 		Path:     cfg.Name,
 		RawQuery: q.Encode(),
 	}
-	fmt.Println(u.String())
 	return sqlx.Open("postgres", u.String())
 }
 
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net/http"
 	"reflect"
+	"sales_service/internal/platform/barcode"
 	"strings"
 
 	en "github.com/go-playground/locales/en"
@@ -34,6 +35,20 @@ func init() {
 	// The validator uses the translator to translate the error messages.
 	en_translations.RegisterDefaultTranslations(validate, lang)
 
+	// Register the barcode tag, which checks the digits of EAN and UPC
+	// barcodes, along with its message. An empty string passes, so updates
+	// can clear a barcode; fields that need one are tagged required too.
+	validate.RegisterValidation("barcode", func(fl validator.FieldLevel) bool {
+		code := fl.Field().String()
+		return code == "" || barcode.Valid(code)
+	})
+	validate.RegisterTranslation("barcode", lang, func(trans ut.Translator) error {
+		return trans.Add("barcode", "{0} must be a valid EAN-13, UPC-A or EAN-8 barcode", true)
+	}, func(trans ut.Translator, fe validator.FieldError) string {
+		t, _ := trans.T("barcode", fe.Field())
+		return t
+	})
+
 	// Register a custom tag name function for the validator.
 	// The function extracts the JSON field name from the struct field tag.
 	// If the field is not tagged with "json", the field is ignored.
@@ -69,6 +84,13 @@ func Decode(r *http.Request, val interface{}) error {
 	}
 
 	// Validate the decoded struct
+	return Validate(val)
+}
+
+// Validate checks val against the rules of its validate struct tags. When
+// the validation fails the error is of type *web.Error and contains the
+// validation error messages.
+func Validate(val interface{}) error {
 	if err := validate.Struct(val); err != nil {
 		// If the validation fails, get the validation errors
 		verrors, ok := err.(validator.ValidationErrors)
@@ -2,6 +2,7 @@ package web
 
 import (
 	"context"
+	"encoding/csv"
 	"encoding/json"
 
 	"net/http"
@@ -21,7 +22,7 @@ func Respond(ctx context.Context, w http.ResponseWriter, val interface{}, status
 
 	v.StatusCode = statusCode
 
-	if statusCode == http.StatusNoContent {
+	if statusCode == http.StatusNoContent || statusCode == http.StatusNotModified {
 		w.WriteHeader(statusCode)
 		return nil
 	}
@@ -75,4 +76,47 @@ func RespondError(ctx context.Context, w http.ResponseWriter, err error) error {
 	return nil
 }
 
+// RespondCSV writes the records to the http.ResponseWriter as CSV with the
+// specified status code. The first record is expected to be the header.
+func RespondCSV(ctx context.Context, w http.ResponseWriter, records [][]string, statusCode int) error {
+	v, ok := ctx.Value(KeyValues).(*Values)
+	if !ok {
+		return errors.New("web value missing from context")
+	}
+
+	v.StatusCode = statusCode
+
+	// Set the content type header to text/csv
+	w.Header().Set("content-type", "text/csv;charset=utf-8")
+
+	// Set the status code of the response
+	w.WriteHeader(statusCode)
+
+	// Write the records to the response
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(records); err != nil {
+		return errors.Wrap(err, "write to client")
+	}
+
+	return nil
+}
 
+// RespondBytes writes data to the http.ResponseWriter as it is, with the
+// specified content type and status code.
+func RespondBytes(ctx context.Context, w http.ResponseWriter, data []byte, contentType string, statusCode int) error {
+	v, ok := ctx.Value(KeyValues).(*Values)
+	if !ok {
+		return errors.New("web value missing from context")
+	}
+
+	v.StatusCode = statusCode
+
+	w.Header().Set("content-type", contentType)
+	w.WriteHeader(statusCode)
+
+	if _, err := w.Write(data); err != nil {
+		return errors.Wrap(err, "write to client")
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/barcode"
+
+	"github.com/go-faster/errors"
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrInvalidBarcode is returned when looking up a code that is not a valid
+// EAN-13, UPC-A or EAN-8 barcode.
+var ErrInvalidBarcode = errors.New("invalid barcode")
+
+// RetrieveByBarcode finds the product of the caller's organization carrying
+// the barcode code, archived ones excepted, as when a scanner reads a label.
+// A UPC-A finds the product carrying it as an EAN-13 with a leading zero,
+// and the other way round.
+func RetrieveByBarcode(ctx context.Context, db *sqlx.DB, user auth.Claims, code string) (*Product, error) {
+	if !barcode.Valid(code) {
+		return nil, ErrInvalidBarcode
+	}
+
+	var p Product
+
+	// The comparison matches the expression of the unique index on
+	// barcodes, which the lookup goes through.
+	const q = selectProducts + ` WHERE lpad(p.barcode, 14, '0') = lpad($1, 14, '0')
+	AND ($2::UUID IS NULL OR p.org_id = $2) AND p.date_deleted IS NULL ` + groupProducts
+	if err := db.GetContext(ctx, &p, q, code, user.OrgScope()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting product by barcode")
+	}
+
+	return &p, nil
+}
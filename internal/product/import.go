@@ -0,0 +1,453 @@
+package product
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sales_service/internal/audit"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/web"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/jmoiron/sqlx"
+)
+
+// Formats a product import can be read from.
+const (
+	// FormatCSV files start with a header naming their columns: name, cost
+	// and quantity, and optionally sku, barcode, category_id and tags. Tags
+	// are separated by a vertical bar.
+	FormatCSV = "csv"
+
+	// FormatNDJSON files hold a NewProduct as a JSON object on every line.
+	FormatNDJSON = "ndjson"
+)
+
+// MaxImportErrors limits how many failed rows an import report lists. Rows
+// failing beyond it are only counted.
+const MaxImportErrors = 1000
+
+// maxLineLength is the longest line of an NDJSON import.
+const maxLineLength = 1 << 20
+
+var (
+	ErrUnknownFormat = errors.New("import format must be csv or ndjson")
+	ErrImportHeader  = errors.New("invalid csv header")
+	ErrMalformed     = errors.New("malformed import")
+
+	// ErrArchivedSKU is returned when an import row names the SKU of an
+	// archived product. The product must be restored before it is updated.
+	ErrArchivedSKU = errors.New("product with this sku is archived")
+)
+
+// csvColumns are the columns of a CSV import, and whether they are required.
+var csvColumns = map[string]bool{
+	"name":        true,
+	"cost":        true,
+	"quantity":    true,
+	"sku":         false,
+	"barcode":     false,
+	"category_id": false,
+	"tags":        false,
+}
+
+// importRow is a product read from an import. Rows that could not be parsed
+// carry the reason instead.
+type importRow struct {
+	line int
+	np   NewProduct
+	err  error
+}
+
+// Import reads products from r, one row at a time, and adds them to the
+// caller's organization. Rows are validated with the rules of NewProduct. A
+// row with the SKU of an existing product updates that product instead, for
+// which the caller needs the product:write permission for their own
+// products or for any product. Failed rows are reported without stopping the
+// import; the other rows are kept, unless dryRun is set in which case
+// nothing is.
+func Import(ctx context.Context, db *sqlx.DB, user auth.Claims, r io.Reader, format string, dryRun bool, now time.Time) (*ImportReport, error) {
+	var next func() (importRow, error)
+	switch format {
+	case FormatCSV:
+		var err error
+		if next, err = csvRows(r); err != nil {
+			return nil, err
+		}
+	case FormatNDJSON:
+		next = ndjsonRows(r)
+	default:
+		return nil, ErrUnknownFormat
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	report := ImportReport{DryRun: dryRun, Errors: []ImportError{}}
+
+	for {
+		row, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		report.Rows++
+
+		result, err := importOne(ctx, tx, user, row, now)
+		if err != nil {
+			if !isRowError(err) {
+				return nil, errors.Wrapf(err, "line %d", row.line)
+			}
+			report.Failed++
+			if len(report.Errors) < MaxImportErrors {
+				report.Errors = append(report.Errors, newImportError(row, err))
+			}
+			continue
+		}
+
+		switch result {
+		case audit.Create:
+			report.Created++
+		case audit.Update:
+			report.Updated++
+		default:
+			report.Unchanged++
+		}
+	}
+
+	// A dry run goes through every row like a real import so the report is
+	// the same, and then throws it all away.
+	if dryRun {
+		return &report, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing import")
+	}
+	return &report, nil
+}
+
+// importOne validates a row and writes it inside a savepoint, so a row
+// failing in the database leaves tx usable for the next ones. It returns
+// the audit action taken, or nothing when the product did not change.
+func importOne(ctx context.Context, tx *sqlx.Tx, user auth.Claims, row importRow, now time.Time) (string, error) {
+	if row.err != nil {
+		return "", row.err
+	}
+	if err := web.Validate(row.np); err != nil {
+		var webErr *web.Error
+		if !errors.As(err, &webErr) {
+			return "", err
+		}
+		fields := make([]FieldError, len(webErr.Fields))
+		for i, f := range webErr.Fields {
+			fields[i] = FieldError(f)
+		}
+		return "", &rowError{err: webErr.Err, fields: fields}
+	}
+
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT import_row`); err != nil {
+		return "", errors.Wrap(err, "creating savepoint")
+	}
+
+	action, err := upsert(ctx, tx, user, row.np, now)
+	if err != nil {
+		if _, rerr := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT import_row`); rerr != nil {
+			return "", errors.Wrap(rerr, "rolling back to savepoint")
+		}
+		return "", err
+	}
+
+	if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT import_row`); err != nil {
+		return "", errors.Wrap(err, "releasing savepoint")
+	}
+	return action, nil
+}
+
+// upsert creates the product of an import row, or updates the product with
+// the same SKU. Fields left out of the row, the barcode, category and tags,
+// are kept on update.
+func upsert(ctx context.Context, tx *sqlx.Tx, user auth.Claims, np NewProduct, now time.Time) (string, error) {
+	if np.SKU == "" {
+		if _, err := create(ctx, tx, user, np, now); err != nil {
+			return "", err
+		}
+		return audit.Create, nil
+	}
+
+	var existing struct {
+		ID       string `db:"id"`
+		UserID   string `db:"user_id"`
+		Archived bool   `db:"archived"`
+	}
+
+	const q = `SELECT id, user_id, date_deleted IS NOT NULL AS archived FROM products
+	WHERE org_id = $1 AND sku = $2 FOR UPDATE`
+	err := tx.GetContext(ctx, &existing, q, user.OrgID, np.SKU)
+	switch {
+	case err == sql.ErrNoRows:
+		if _, err := create(ctx, tx, user, np, now); err != nil {
+			return "", err
+		}
+		return audit.Create, nil
+	case err != nil:
+		return "", errors.Wrap(err, "locking product")
+	case existing.Archived:
+		return "", ErrArchivedSKU
+	case !user.Can(auth.PermProductWrite, existing.UserID):
+		return "", ErrForbidden
+	}
+
+	before, err := retrieve(ctx, tx, nil, existing.ID, false)
+	if err != nil {
+		return "", err
+	}
+
+	update := UpdateProduct{
+		Name:       &np.Name,
+		Cost:       &np.Cost,
+		Quantity:   &np.Quantity,
+		CategoryID: np.CategoryID,
+	}
+	if np.Barcode != "" {
+		update.Barcode = &np.Barcode
+	}
+	if np.Tags != nil {
+		update.Tags = &np.Tags
+	}
+
+	if !changes(before, update) {
+		return "", nil
+	}
+
+	if np.Cost != before.Cost {
+		if _, err := insertPrice(ctx, tx, user, before.ID, np.Cost, now, now); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := apply(ctx, tx, user, audit.Update, before, update, now); err != nil {
+		return "", err
+	}
+	return audit.Update, nil
+}
+
+// changes reports whether update would change any field of p.
+func changes(p *Product, update UpdateProduct) bool {
+	if *update.Name != p.Name || *update.Cost != p.Cost || *update.Quantity != p.Quantity {
+		return true
+	}
+	if update.Barcode != nil && *update.Barcode != p.Barcode {
+		return true
+	}
+	if update.CategoryID != nil {
+		var current string
+		if p.CategoryID != nil {
+			current = *p.CategoryID
+		}
+		if *update.CategoryID != current {
+			return true
+		}
+	}
+	if update.Tags != nil {
+		tags := normalizeTags(*update.Tags)
+		if len(tags) != len(p.Tags) {
+			return true
+		}
+		for i := range tags {
+			if tags[i] != p.Tags[i] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isRowError reports whether err is a problem with a single row of an import
+// rather than with the import as a whole.
+func isRowError(err error) bool {
+	var rowErr *rowError
+	switch {
+	case errors.As(err, &rowErr),
+		errors.Is(err, ErrCategoryNotFound),
+		errors.Is(err, ErrDuplicateSKU),
+		errors.Is(err, ErrDuplicateBarcode),
+		errors.Is(err, ErrHasVariants),
+		errors.Is(err, ErrStocked),
+		errors.Is(err, ErrArchivedSKU),
+		errors.Is(err, ErrForbidden):
+		return true
+	default:
+		return false
+	}
+}
+
+// newImportError describes a failed row for the report.
+func newImportError(row importRow, err error) ImportError {
+	ie := ImportError{
+		Line:  row.line,
+		SKU:   row.np.SKU,
+		Error: err.Error(),
+	}
+
+	var rowErr *rowError
+	if errors.As(err, &rowErr) {
+		ie.Fields = rowErr.fields
+	}
+	return ie
+}
+
+// rowError fails a single row of an import that could not be read or is not
+// valid, naming the fields at fault if any.
+type rowError struct {
+	err    error
+	fields []FieldError
+}
+
+func (e *rowError) Error() string {
+	return e.err.Error()
+}
+
+// csvRows reads the header of a CSV import and returns a function reading
+// its rows one at a time. The function returns io.EOF after the last row.
+func csvRows(r io.Reader) (func() (importRow, error), error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, errors.Wrap(ErrImportHeader, "the file is empty")
+		}
+		return nil, errors.Wrap(ErrMalformed, err.Error())
+	}
+
+	cols := make(map[string]int, len(header))
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if _, ok := csvColumns[h]; !ok {
+			return nil, errors.Wrapf(ErrImportHeader, "unknown column %q", h)
+		}
+		if _, ok := cols[h]; ok {
+			return nil, errors.Wrapf(ErrImportHeader, "column %q given twice", h)
+		}
+		cols[h] = i
+	}
+	for col, required := range csvColumns {
+		if _, ok := cols[col]; required && !ok {
+			return nil, errors.Wrapf(ErrImportHeader, "missing column %q", col)
+		}
+	}
+
+	return func() (importRow, error) {
+		rec, err := cr.Read()
+		if err != nil {
+			if err == io.EOF {
+				return importRow{}, io.EOF
+			}
+
+			// A row with the wrong number of fields only fails that row,
+			// anything else leaves the rest of the file unreadable.
+			var perr *csv.ParseError
+			if errors.As(err, &perr) && errors.Is(perr.Err, csv.ErrFieldCount) {
+				return importRow{line: perr.StartLine, err: &rowError{err: perr.Err}}, nil
+			}
+			return importRow{}, errors.Wrap(ErrMalformed, err.Error())
+		}
+
+		line, _ := cr.FieldPos(0)
+		return parseCSVRow(line, cols, rec), nil
+	}, nil
+}
+
+// parseCSVRow turns a record of a CSV import into a NewProduct.
+func parseCSVRow(line int, cols map[string]int, rec []string) importRow {
+	get := func(col string) string {
+		if i, ok := cols[col]; ok {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+
+	row := importRow{
+		line: line,
+		np: NewProduct{
+			Name:    get("name"),
+			SKU:     get("sku"),
+			Barcode: get("barcode"),
+		},
+	}
+
+	var fields []FieldError
+	for _, c := range []struct {
+		col string
+		dst *int
+	}{{"cost", &row.np.Cost}, {"quantity", &row.np.Quantity}} {
+		v, err := strconv.Atoi(get(c.col))
+		if err != nil {
+			fields = append(fields, FieldError{Field: c.col, Error: c.col + " must be an integer"})
+			continue
+		}
+		*c.dst = v
+	}
+
+	if id := get("category_id"); id != "" {
+		row.np.CategoryID = &id
+	}
+	if tags := get("tags"); tags != "" {
+		row.np.Tags = strings.Split(tags, "|")
+	}
+
+	if len(fields) > 0 {
+		row.err = &rowError{err: errors.New("field validation error"), fields: fields}
+	}
+	return row
+}
+
+// ndjsonRows returns a function reading the rows of an NDJSON import one at
+// a time. Blank lines are skipped. The function returns io.EOF after the
+// last row.
+func ndjsonRows(r io.Reader) func() (importRow, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), maxLineLength)
+
+	var line int
+	return func() (importRow, error) {
+		for sc.Scan() {
+			line++
+
+			b := bytes.TrimSpace(sc.Bytes())
+			if len(b) == 0 {
+				continue
+			}
+
+			var np NewProduct
+			dec := json.NewDecoder(bytes.NewReader(b))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&np); err != nil {
+				return importRow{line: line, err: &rowError{err: err}}, nil
+			}
+			return importRow{line: line, np: np}, nil
+		}
+
+		if err := sc.Err(); err != nil {
+			if errors.Is(err, bufio.ErrTooLong) {
+				return importRow{}, errors.Wrapf(ErrMalformed, "line %d is too long", line+1)
+			}
+			return importRow{}, errors.Wrap(err, "reading import")
+		}
+		return importRow{}, io.EOF
+	}
+}
@@ -0,0 +1,225 @@
+package product
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sales_service/internal/platform/auth"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// DefaultLimit is the page size used when the caller does not ask for one.
+	DefaultLimit = 50
+
+	// MaxLimit is the largest page size a caller may request.
+	MaxLimit = 500
+)
+
+// sortColumns lists the columns a listing can be ordered by. The computed
+// revenue, sold and available aggregates are allowed because the ordering is applied
+// on top of the grouped query.
+var sortColumns = map[string]bool{
+	"id":           true,
+	"name":         true,
+	"cost":         true,
+	"quantity":     true,
+	"sold":         true,
+	"revenue":      true,
+	"available":    true,
+	"user_id":      true,
+	"date_created": true,
+	"date_updated": true,
+}
+
+// cursor is the decoded form of the opaque token handed out as NextCursor.
+// It remembers the sort key and ID of the last row of a page so the next page
+// can continue right after it.
+type cursor struct {
+	SortBy string `json:"s"`
+	Desc   bool   `json:"d"`
+	Value  string `json:"v"`
+	ID     string `json:"id"`
+}
+
+// List retrieves a page of products of the caller's organization matching
+// the filter.
+func List(ctx context.Context, db *sqlx.DB, user auth.Claims, f ListFilter) (*Page, error) {
+	if f.SortBy == "" {
+		f.SortBy = "date_created"
+	}
+	if !sortColumns[f.SortBy] {
+		return nil, ErrInvalidSort
+	}
+	if f.Limit <= 0 {
+		f.Limit = DefaultLimit
+	}
+	if f.Limit > MaxLimit {
+		f.Limit = MaxLimit
+	}
+
+	// Build the WHERE clause and its positional arguments together so the
+	// placeholders always line up.
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if org := user.OrgScope(); org != nil {
+		where = append(where, "p.org_id = "+arg(*org))
+	}
+	if !f.Archived {
+		where = append(where, "p.date_deleted IS NULL")
+	}
+	if f.Name != "" {
+		where = append(where, `p.name ILIKE '%' || `+arg(escapeLike(f.Name))+` || '%'`)
+	}
+	if f.MinCost != nil {
+		where = append(where, "p.cost >= "+arg(*f.MinCost))
+	}
+	if f.MaxCost != nil {
+		where = append(where, "p.cost <= "+arg(*f.MaxCost))
+	}
+	if f.UserID != "" {
+		where = append(where, "p.user_id = "+arg(f.UserID))
+	}
+	if f.CreatedFrom != nil {
+		where = append(where, "p.date_created >= "+arg(f.CreatedFrom.UTC()))
+	}
+	if f.CreatedTo != nil {
+		where = append(where, "p.date_created < "+arg(f.CreatedTo.UTC()))
+	}
+	if f.CategoryID != "" {
+		where = append(where, `p.category_id IN (
+			WITH RECURSIVE subtree AS (
+				SELECT category_id FROM categories WHERE category_id = `+arg(f.CategoryID)+`
+				UNION
+				SELECT c.category_id FROM categories AS c JOIN subtree AS t ON c.parent_id = t.category_id
+			)
+			SELECT category_id FROM subtree)`)
+	}
+	if tags := normalizeTags(f.Tags); len(tags) > 0 {
+		where = append(where, "p.tags @> "+arg(tags))
+	}
+
+	var cond string
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var page Page
+
+	// The filters only touch product columns, so counting does not need the
+	// sales join.
+	if err := db.GetContext(ctx, &page.Total, `SELECT COUNT(*) FROM products AS p`+cond, args...); err != nil {
+		return nil, errors.Wrap(err, "counting products")
+	}
+
+	dir, cmp := "ASC", ">"
+	if f.Desc {
+		dir, cmp = "DESC", "<"
+	}
+
+	// Wrap the grouped query so the computed columns can be used for ordering
+	// and for the keyset condition.
+	q := `SELECT * FROM (` + selectProducts + cond + ` ` + groupProducts + `) AS p`
+
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if c.SortBy != f.SortBy || c.Desc != f.Desc {
+			return nil, ErrInvalidCursor
+		}
+		q += fmt.Sprintf(` WHERE (p.%s, p.id) %s (%s, %s)`, f.SortBy, cmp, arg(c.Value), arg(c.ID))
+	}
+
+	// Ask for one extra row to find out whether there is a next page.
+	q += fmt.Sprintf(` ORDER BY p.%s %s, p.id %s LIMIT %s`, f.SortBy, dir, dir, arg(f.Limit+1))
+	if f.Cursor == "" && f.Offset > 0 {
+		q += ` OFFSET ` + arg(f.Offset)
+	}
+
+	page.Items = []Product{}
+	if err := db.SelectContext(ctx, &page.Items, q, args...); err != nil {
+		return nil, errors.Wrap(err, "selecting products")
+	}
+
+	if len(page.Items) > f.Limit {
+		page.Items = page.Items[:f.Limit]
+		last := page.Items[len(page.Items)-1]
+		page.NextCursor = encodeCursor(cursor{
+			SortBy: f.SortBy,
+			Desc:   f.Desc,
+			Value:  sortValue(last, f.SortBy),
+			ID:     last.ID,
+		})
+	}
+
+	return &page, nil
+}
+
+// sortValue returns the value of the given sort column for p in a form
+// PostgreSQL can compare against the column again.
+func sortValue(p Product, column string) string {
+	switch column {
+	case "name":
+		return p.Name
+	case "cost":
+		return strconv.Itoa(p.Cost)
+	case "quantity":
+		return strconv.Itoa(p.Quantity)
+	case "sold":
+		return strconv.Itoa(p.Sold)
+	case "revenue":
+		return strconv.Itoa(p.Revenue)
+	case "available":
+		return strconv.Itoa(p.Available)
+	case "user_id":
+		return p.UserID
+	case "date_created":
+		return p.DateCreated.UTC().Format(time.RFC3339Nano)
+	case "date_updated":
+		return p.DateUpdated.UTC().Format(time.RFC3339Nano)
+	default:
+		return p.ID
+	}
+}
+
+// encodeCursor turns a cursor into the opaque string given to clients.
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a string produced by encodeCursor.
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil || !sortColumns[c.SortBy] {
+		return c, ErrInvalidCursor
+	}
+	if _, err := uuid.Parse(c.ID); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s so it is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
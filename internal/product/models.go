@@ -1,42 +1,314 @@
 package product
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/lib/pq"
+)
 
 type Product struct {
 	ID          string    `db:"id" json:"id"`
 	Name        string    `db:"name" json:"name"`
+	SKU         string    `db:"sku" json:"sku,omitempty"`
+	Barcode     string    `db:"barcode" json:"barcode,omitempty"`
 	Cost        int       `db:"cost" json:"cost"`
 	Quantity    int       `db:"quantity" json:"quantity"`
 	Sold        int       `db:"sold" json:"sold"`
 	Revenue     int       `db:"revenue" json:"revenue"`
+	Available   int       `db:"available" json:"available"`
 	UserID      string    `db:"user_id" json:"user_id"`
+	OrgID       string    `db:"org_id" json:"org_id"`
 	DateCreated time.Time `db:"date_created" json:"date_created"`
 	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+
+	// CategoryID is the category the product is browsed under, if any, and
+	// Tags are free-form labels, kept lowercase and sorted.
+	CategoryID *string        `db:"category_id" json:"category_id"`
+	Tags       pq.StringArray `db:"tags" json:"tags"`
+
+	// Version increases on every write to the product, including sales and
+	// refunds changing what is sold and available. Updates must name the
+	// version they were based on.
+	Version int `db:"version" json:"version"`
+
+	// DateDeleted is set while the product is archived.
+	DateDeleted *time.Time `db:"date_deleted" json:"date_deleted,omitempty"`
+
+	// Variants are the versions the product is sold in, only read by
+	// Retrieve. The quantity of a product with variants is the sum of theirs,
+	// and its sales totals include the sales of every variant.
+	Variants []Variant `db:"-" json:"variants,omitempty"`
 }
 
+// NewProduct is what we require from clients when adding a product. The SKU
+// and the barcode are optional, but must be unique within the organization
+// when given. Barcodes are EAN-13, UPC-A or EAN-8 codes.
 type NewProduct struct {
-	Name     string `json:"name" validate:"required"`
-	Cost     int    `json:"cost" validate:"gte=0"`
-	Quantity int    `json:"quantity" validate:"gte=1"`
+	Name       string   `json:"name" validate:"required"`
+	SKU        string   `json:"sku" validate:"omitempty,max=64"`
+	Barcode    string   `json:"barcode" validate:"omitempty,barcode"`
+	Cost       int      `json:"cost" validate:"gte=0"`
+	Quantity   int      `json:"quantity" validate:"gte=1"`
+	CategoryID *string  `json:"category_id"`
+	Tags       []string `json:"tags" validate:"dive,max=64"`
 }
 
 // UpdateProduct represents a request to update a product.
 // The fields are pointers so that one can specify only the fields that need to be updated.
+// An empty CategoryID takes the product out of its category, and Tags replaces
+// every tag of the product.
 type UpdateProduct struct {
-	Name     *string `json:"name"`
-	Cost     *int    `json:"cost" validate:"omitempty,gte=0"`
-	Quantity *int    `json:"quantity" validate:"omitempty,gte=1"`
+	Name       *string   `json:"name"`
+	SKU        *string   `json:"sku" validate:"omitempty,max=64"`
+	Barcode    *string   `json:"barcode" validate:"omitempty,barcode"`
+	Cost       *int      `json:"cost" validate:"omitempty,gte=0"`
+	Quantity   *int      `json:"quantity" validate:"omitempty,gte=1"`
+	CategoryID *string   `json:"category_id"`
+	Tags       *[]string `json:"tags" validate:"omitempty,dive,max=64"`
+}
+
+// Variant is a version of a product sold on its own, such as a size or a
+// packaging, with its own stock and price.
+type Variant struct {
+	ID          string     `db:"variant_id" json:"id"`
+	ProductID   string     `db:"product_id" json:"product_id"`
+	SKU         string     `db:"sku" json:"sku,omitempty"`
+	Attributes  Attributes `db:"attributes" json:"attributes"`
+	Cost        int        `db:"cost" json:"cost"`
+	Quantity    int        `db:"quantity" json:"quantity"`
+	Sold        int        `db:"sold" json:"sold"`
+	Revenue     int        `db:"revenue" json:"revenue"`
+	Available   int        `db:"available" json:"available"`
+	DateCreated time.Time  `db:"date_created" json:"date_created"`
+	DateUpdated time.Time  `db:"date_updated" json:"date_updated"`
+}
+
+// Attributes name what sets a variant apart from the others of its
+// product, like {"size": "L", "color": "red"}. They are stored as a JSON
+// object.
+type Attributes map[string]string
+
+// Scan reads attributes from their JSON column.
+func (a *Attributes) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	case nil:
+		*a = nil
+		return nil
+	default:
+		return errors.Errorf("cannot scan %T into attributes", src)
+	}
+	return json.Unmarshal(b, a)
+}
+
+// Value writes attributes to their JSON column.
+func (a Attributes) Value() (driver.Value, error) {
+	if a == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(a)
+}
+
+// NewVariant is what we require from clients when adding a variant to a
+// product. The SKU is optional, but must be unique among the variants of the
+// organization when given.
+type NewVariant struct {
+	SKU        string     `json:"sku" validate:"omitempty,max=64"`
+	Attributes Attributes `json:"attributes" validate:"required,min=1,dive,keys,required,max=64,endkeys,max=256"`
+	Cost       int        `json:"cost" validate:"gte=0"`
+	Quantity   int        `json:"quantity" validate:"gte=0"`
+}
+
+// UpdateVariant represents a request to update a variant. Fields left out
+// are kept, and Attributes replaces every attribute.
+type UpdateVariant struct {
+	SKU        *string    `json:"sku" validate:"omitempty,max=64"`
+	Attributes Attributes `json:"attributes" validate:"omitempty,min=1,dive,keys,required,max=64,endkeys,max=256"`
+	Cost       *int       `json:"cost" validate:"omitempty,gte=0"`
+	Quantity   *int       `json:"quantity" validate:"omitempty,gte=0"`
+}
+
+// Tag is a tag in use with the number of products carrying it.
+type Tag struct {
+	Name     string `db:"tag" json:"name"`
+	Products int    `db:"products" json:"products"`
+}
+
+// Revision is the name, cost and quantity of a product as written by a
+// create or an update. Revisions are numbered with the version the write gave
+// the product, so the numbers have gaps where sales bumped the version.
+type Revision struct {
+	ProductID   string    `db:"product_id" json:"product_id"`
+	Version     int       `db:"version" json:"version"`
+	Name        string    `db:"name" json:"name"`
+	Cost        int       `db:"cost" json:"cost"`
+	Quantity    int       `db:"quantity" json:"quantity"`
+	UserID      string    `db:"user_id" json:"user_id"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
+
+// Diff lists the fields that differ between two revisions of a product.
+type Diff struct {
+	ProductID string        `json:"product_id"`
+	From      int           `json:"from"`
+	To        int           `json:"to"`
+	Changes   []FieldChange `json:"changes"`
+}
+
+// FieldChange is a field whose value differs between two revisions.
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// Price is the cost of a product from a point in time until the next price
+// takes effect. Prices effective in the future are scheduled changes.
+type Price struct {
+	ID            string    `db:"price_id" json:"id"`
+	ProductID     string    `db:"product_id" json:"product_id"`
+	Cost          int       `db:"cost" json:"cost"`
+	EffectiveFrom time.Time `db:"effective_from" json:"effective_from"`
+	UserID        string    `db:"user_id" json:"user_id"`
+	DateCreated   time.Time `db:"date_created" json:"date_created"`
+}
+
+// NewPrice is what we require from clients when changing the price of a
+// product. Leaving EffectiveFrom out changes it right away.
+type NewPrice struct {
+	Cost          int        `json:"cost" validate:"gte=0"`
+	EffectiveFrom *time.Time `json:"effective_from"`
+}
+
+// RetrieveOptions changes what Retrieve reads. The zero value reads the
+// product as it is now, unless it is archived.
+type RetrieveOptions struct {
+	// Archived finds archived products too.
+	Archived bool
+
+	// AsOf, when set, gives the cost the product had at that time.
+	AsOf *time.Time
 }
 
+// Sale is a single line of an order: a quantity of one product and the amount
+// paid for it. ListPrice is the unit price of the product at the time of the
+// sale, so the difference with Paid is the discount given.
 type Sale struct {
 	ID          string    `db:"sale_id" json:"id"`
+	OrderID     string    `db:"order_id" json:"order_id"`
 	ProductID   string    `db:"product_id" json:"product_id"`
+	VariantID   *string   `db:"variant_id" json:"variant_id,omitempty"`
+	WarehouseID *string   `db:"warehouse_id" json:"warehouse_id,omitempty"`
 	Quantity    int       `db:"quantity" json:"quantity"`
+	ListPrice   int       `db:"list_price" json:"list_price"`
 	Paid        int       `db:"paid" json:"paid"`
 	DateCreated time.Time `db:"date_created" json:"date_created"`
 }
 
+// NewSale is what we require from clients when selling a product. Products
+// with variants are sold by variant, and only those. Products stocked in
+// warehouses are drawn from the warehouse given.
 type NewSale struct {
-	Quantity int `json:"quantity"`
-	Paid     int `json:"paid"`
+	VariantID   string `json:"variant_id" validate:"omitempty,uuid"`
+	WarehouseID string `json:"warehouse_id" validate:"omitempty,uuid"`
+	Quantity    int    `json:"quantity" validate:"gte=1"`
+	Paid        int    `json:"paid" validate:"gte=0"`
+}
+
+// Refund gives back all or part of a sale. Refunded units return to stock
+// and the amount is taken off the product's revenue.
+type Refund struct {
+	ID          string    `db:"refund_id" json:"id"`
+	SaleID      string    `db:"sale_id" json:"sale_id"`
+	Quantity    int       `db:"quantity" json:"quantity"`
+	Amount      int       `db:"amount" json:"amount"`
+	Reason      string    `db:"reason" json:"reason"`
+	UserID      string    `db:"user_id" json:"user_id"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
+
+// NewRefund is what we require from clients when refunding a sale. Leaving
+// both Quantity and Amount out refunds whatever is left of the sale. Giving
+// only Quantity refunds the matching share of the amount paid, and giving
+// only Amount refunds money without returning goods.
+type NewRefund struct {
+	Quantity *int   `json:"quantity" validate:"omitempty,gte=0"`
+	Amount   *int   `json:"amount" validate:"omitempty,gte=0"`
+	Reason   string `json:"reason"`
+}
+
+// ListFilter holds the optional criteria used to narrow, order and page
+// through a product listing. Zero values mean "no restriction".
+type ListFilter struct {
+	Name        string
+	MinCost     *int
+	MaxCost     *int
+	UserID      string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	SortBy      string
+	Desc        bool
+	Limit       int
+	Offset      int
+	Cursor      string
+
+	// CategoryID keeps the products of a category and of all of its
+	// subcategories, and Tags those carrying every one of the tags.
+	CategoryID string
+	Tags       []string
+
+	// Archived includes archived products, which are left out by default.
+	Archived bool
+}
+
+// SearchResult is a product found by Search. Rank orders the results, higher
+// first, and Highlight is the product name as HTML with the words that
+// matched the query wrapped in <mark> elements.
+type SearchResult struct {
+	Product
+	Rank      float64 `db:"rank" json:"rank"`
+	Highlight string  `db:"-" json:"highlight"`
+}
+
+// ImportReport sums up an import. Every row read was either created, updated,
+// left unchanged or failed. The failed rows are listed in Errors, up to
+// MaxImportErrors of them. A dry run reports what an import would do without
+// keeping any of it.
+type ImportReport struct {
+	DryRun    bool          `json:"dry_run"`
+	Rows      int           `json:"rows"`
+	Created   int           `json:"created"`
+	Updated   int           `json:"updated"`
+	Unchanged int           `json:"unchanged"`
+	Failed    int           `json:"failed"`
+	Errors    []ImportError `json:"errors"`
+}
+
+// ImportError is why a row of an import failed. Line is the line of the file
+// the row starts on.
+type ImportError struct {
+	Line   int          `json:"line"`
+	SKU    string       `json:"sku,omitempty"`
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError is why a single field of an import row was rejected.
+type FieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// Page is a single page of a product listing.
+type Page struct {
+	Items      []Product `json:"items"`
+	Total      int       `json:"total"`
+	NextCursor string    `json:"next_cursor,omitempty"`
 }
@@ -0,0 +1,255 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"sales_service/internal/audit"
+	"sales_service/internal/platform/auth"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// ErrNoPrice is returned when asking for the price of a product at a
+	// time before it had one.
+	ErrNoPrice = errors.New("product had no price at that time")
+
+	ErrPastPrice     = errors.New("price changes cannot take effect in the past")
+	ErrPriceNotFound = errors.New("scheduled price not found")
+)
+
+// PriceAt gives the cost of a product effective at the given time.
+func PriceAt(ctx context.Context, db sqlx.QueryerContext, productID string, at time.Time) (int, error) {
+	var cost int
+
+	const q = `SELECT cost FROM product_prices WHERE product_id = $1 AND effective_from <= $2
+	ORDER BY effective_from DESC LIMIT 1`
+	if err := sqlx.GetContext(ctx, db, &cost, q, productID, at.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrNoPrice
+		}
+		return 0, errors.Wrap(err, "selecting price")
+	}
+
+	return cost, nil
+}
+
+// insertPrice records that the product costs cost from the given time. A
+// second price at the same time replaces the first.
+func insertPrice(ctx context.Context, tx *sqlx.Tx, user auth.Claims, productID string, cost int, from, now time.Time) (*Price, error) {
+	p := Price{
+		ID:            uuid.New().String(),
+		ProductID:     productID,
+		Cost:          cost,
+		EffectiveFrom: from.UTC(),
+		UserID:        user.Subject,
+		DateCreated:   now.UTC(),
+	}
+
+	// Prices set outside of a request have no author.
+	var userID *string
+	if user.Subject != "" {
+		userID = &user.Subject
+	}
+
+	const q = `INSERT INTO product_prices (price_id, product_id, cost, effective_from, user_id, date_created)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (product_id, effective_from) DO UPDATE
+	SET cost = EXCLUDED.cost, user_id = EXCLUDED.user_id, date_created = EXCLUDED.date_created
+	RETURNING price_id`
+	if err := tx.GetContext(ctx, &p.ID, q, p.ID, p.ProductID, p.Cost, p.EffectiveFrom, userID, p.DateCreated); err != nil {
+		return nil, errors.Wrap(err, "inserting price")
+	}
+
+	return &p, nil
+}
+
+// ListPrices gives the price history of a product of the caller's
+// organization, scheduled changes included, oldest first.
+func ListPrices(ctx context.Context, db *sqlx.DB, user auth.Claims, productID string) ([]Price, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	if _, err := retrieve(ctx, db, user.OrgScope(), productID, true); err != nil {
+		return nil, err
+	}
+
+	list := []Price{}
+
+	const q = `SELECT price_id, product_id, cost, effective_from, COALESCE(user_id::text, '') AS user_id, date_created
+	FROM product_prices WHERE product_id = $1 ORDER BY effective_from`
+	if err := db.SelectContext(ctx, &list, q, productID); err != nil {
+		return nil, errors.Wrap(err, "selecting prices")
+	}
+
+	return list, nil
+}
+
+// SchedulePrice changes the price of a product from np.EffectiveFrom, or
+// right away when it is not set. Callers need the product:write permission
+// for their own products or for any product. A change taking effect now
+// updates the cost of the product immediately, a later one is applied by
+// ApplyPrices once it is due.
+func SchedulePrice(ctx context.Context, db *sqlx.DB, user auth.Claims, productID string, np NewPrice, now time.Time) (*Price, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	from := now
+	if np.EffectiveFrom != nil {
+		from = *np.EffectiveFrom
+	}
+	if from.Before(now) {
+		return nil, ErrPastPrice
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	owner, err := lock(ctx, tx, user, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.Can(auth.PermProductWrite, owner) {
+		return nil, ErrForbidden
+	}
+
+	price, err := insertPrice(ctx, tx, user, productID, np.Cost, from, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if !from.After(now) {
+		before, err := retrieve(ctx, tx, nil, productID, false)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := apply(ctx, tx, user, audit.Update, before, UpdateProduct{Cost: &np.Cost}, now); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing price")
+	}
+	return price, nil
+}
+
+// CancelPrice removes a price change of a product of the caller's
+// organization that has not taken effect yet.
+func CancelPrice(ctx context.Context, db *sqlx.DB, user auth.Claims, productID, priceID string, now time.Time) error {
+	if _, err := uuid.Parse(productID); err != nil {
+		return ErrInvalidID
+	}
+	if _, err := uuid.Parse(priceID); err != nil {
+		return ErrPriceNotFound
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	owner, err := lock(ctx, tx, user, productID)
+	if err != nil {
+		return err
+	}
+
+	if !user.Can(auth.PermProductWrite, owner) {
+		return ErrForbidden
+	}
+
+	const q = `DELETE FROM product_prices WHERE price_id = $1 AND product_id = $2 AND effective_from > $3`
+	res, err := tx.ExecContext(ctx, q, priceID, productID, now.UTC())
+	if err != nil {
+		return errors.Wrap(err, "deleting price")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrPriceNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing price cancellation")
+	}
+	return nil
+}
+
+// ApplyPrices brings the cost of every product in line with the price
+// effective at now, applying the scheduled changes that became due. It is
+// meant to run periodically and returns how many products changed.
+func ApplyPrices(ctx context.Context, db *sqlx.DB, now time.Time) (int, error) {
+	var ids []string
+
+	const q = `SELECT p.id FROM products AS p
+	WHERE p.date_deleted IS NULL AND p.cost <> (
+		SELECT pr.cost FROM product_prices AS pr
+		WHERE pr.product_id = p.id AND pr.effective_from <= $1
+		ORDER BY pr.effective_from DESC LIMIT 1)`
+	if err := db.SelectContext(ctx, &ids, q, now.UTC()); err != nil {
+		return 0, errors.Wrap(err, "selecting due prices")
+	}
+
+	var n int
+	for _, id := range ids {
+		changed, err := applyPrice(ctx, db, id, now)
+		if err != nil {
+			return n, errors.Wrapf(err, "product %s", id)
+		}
+		if changed {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// applyPrice updates the cost of a single product to its price at now. The
+// change is made by the system, so it has no actor.
+func applyPrice(ctx context.Context, db *sqlx.DB, id string, now time.Time) (bool, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	const q = `SELECT id FROM products WHERE id = $1 AND date_deleted IS NULL FOR UPDATE`
+	if err := tx.GetContext(ctx, &id, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "locking product")
+	}
+
+	before, err := retrieve(ctx, tx, nil, id, false)
+	if err != nil {
+		return false, err
+	}
+
+	cost, err := PriceAt(ctx, tx, id, now)
+	if err != nil {
+		return false, err
+	}
+
+	// Someone may have changed the price since the product was picked.
+	if cost == before.Cost {
+		return false, nil
+	}
+
+	if _, err := apply(ctx, tx, auth.Claims{}, audit.Update, before, UpdateProduct{Cost: &cost}, now); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, errors.Wrap(err, "committing price")
+	}
+	return true, nil
+}
@@ -3,65 +3,115 @@ package product
 import (
 	"context"
 	"database/sql"
-	"fmt"
+	"sales_service/internal/audit"
+	"sales_service/internal/event"
 	"sales_service/internal/platform/auth"
+	"sales_service/internal/warehouse"
 	"time"
 
 	"github.com/go-faster/errors"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 var (
-	ErrNotFound  = errors.New("product not found")
-	ErrInvalidID = errors.New("invalid product ID format")
-	ErrForbidden = errors.New("action not allowed")
-)
+	ErrNotFound      = errors.New("product not found")
+	ErrInvalidID     = errors.New("invalid product ID format")
+	ErrForbidden     = errors.New("action not allowed")
+	ErrInvalidSort   = errors.New("invalid sort column")
+	ErrInvalidCursor = errors.New("invalid pagination cursor")
+	ErrNotArchived   = errors.New("archived product not found")
+
+	// ErrCategoryNotFound is returned when assigning a product to a category
+	// that is not one of its organization.
+	ErrCategoryNotFound = errors.New("category not found")
+
+	// ErrDuplicateSKU is returned when a SKU is already given to another
+	// product of the organization.
+	ErrDuplicateSKU = errors.New("sku is already in use")
+
+	// ErrDuplicateBarcode is returned when a barcode is already given to
+	// another product of the organization. A UPC-A is the same barcode as
+	// the EAN-13 with a leading zero.
+	ErrDuplicateBarcode = errors.New("barcode is already in use")
+
+	// ErrVersionMismatch is returned when a product was changed since the
+	// version an update was based on.
+	ErrVersionMismatch = errors.New("product was changed by someone else")
+
+	// ErrInsufficientStock is returned when a sale asks for more units than
+	// are still available.
+	ErrInsufficientStock = errors.New("not enough stock available")
 
-// List retrieves all products from the database.
-func List(ctx context.Context, db *sqlx.DB) ([]Product, error) {
-	// Create a slice to store the retrieved data.
-	var list []Product
+	ErrSaleNotFound   = errors.New("sale not found")
+	ErrInvalidSaleID  = errors.New("invalid sale ID format")
+	ErrInvalidRefund  = errors.New("refund must return a quantity or an amount")
+	ErrRefundTooLarge = errors.New("refund exceeds what was sold")
+)
 
-	// Define the SQL query to retrieve all products.
-	const query = `select p.id, p.name, p.cost, p.user_id,p.quantity,
-	COALESCE(SUM(s.paid),0) as revenue,
+// selectProducts is the base query that joins products with their sales to
+// compute the sold and revenue aggregates. Sales are read net of refunds. It
+// must be followed by an optional WHERE clause on p and then groupProducts.
+// Every query on products is limited to the caller's organization by a
+// nullable parameter, see auth.Claims.OrgScope. Archived products, those with
+// a date_deleted, are left out unless asked for.
+const selectProducts = `SELECT p.id, p.name, COALESCE(p.sku, '') AS sku, COALESCE(p.barcode, '') AS barcode, p.cost, p.quantity, p.user_id, p.org_id,
+	COALESCE(SUM(s.paid),0) AS revenue,
 	COALESCE(SUM(s.quantity),0) AS sold,
-	p.date_created, p.date_updated from products AS p
-	LEFT JOIN sales AS s ON p.id = s.product_id 
-	Group BY p.id, p.name, p.cost, p.quantity, p.user_id,p.date_created, p.date_updated`
+	p.quantity - COALESCE(SUM(s.quantity),0) AS available,
+	p.date_created, p.date_updated, p.category_id, p.tags, p.version, p.date_deleted FROM products AS p
+	LEFT JOIN net_sales AS s ON p.id = s.product_id`
 
-	// Use the Select method of the sqlx.DB connection to execute the query
-	// and store the result in the list variable.
-	if err := db.SelectContext(ctx, &list, query); err != nil {
-		return nil, err
-	}
+// groupProducts closes a selectProducts query.
+const groupProducts = `GROUP BY p.id`
 
-	// Return the retrieved list of products and nil for the error.
-	return list, nil
-}
+// Retention is how long archived products are kept before a purge removes
+// them.
+const Retention = 90 * 24 * time.Hour
 
-// Retrieve retrieves a single product from the database
-func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*Product, error) {
+// Retrieve retrieves a single product of the caller's organization from the
+// database. Archived products and past prices are read when opts asks for
+// them.
+func Retrieve(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, opts RetrieveOptions) (*Product, error) {
 
 	if _, err := uuid.Parse(id); err != nil {
 		return nil, ErrInvalidID
 	}
 
+	p, err := retrieve(ctx, db, user.OrgScope(), id, opts.Archived)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Variants, err = variants(ctx, db, user.OrgScope(), id); err != nil {
+		return nil, err
+	}
+
+	// Only the cost is read as of the time asked for, the sales totals are
+	// those of today.
+	if opts.AsOf != nil {
+		if p.Cost, err = PriceAt(ctx, db, id, *opts.AsOf); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// retrieve reads a product of the org organization, or of any when org is
+// nil, through db or a transaction.
+func retrieve(ctx context.Context, db sqlx.QueryerContext, org *string, id string, archived bool) (*Product, error) {
+
 	// Create a new Product variable to store the retrieved data.
 	var p Product
 
 	// Define the SQL query to retrieve a single product by ID.
-	const q = `select p.id, p.name, p.cost, p.quantity,
-	COALESCE(SUM(s.paid),0) as revenue,
-	COALESCE(SUM(s.quantity),0) AS sold,
-	p.date_created, p.date_updated from products AS p
-	LEFT JOIN sales AS s ON p.id = s.product_id 
-	Group BY p.id, p.name, p.cost, p.quantity, p.date_created, p.date_updated
-	HAVING p.id = $1`
+	const q = selectProducts + ` WHERE p.id = $1 AND ($2::UUID IS NULL OR p.org_id = $2)
+	AND ($3 OR p.date_deleted IS NULL) ` + groupProducts
 
 	// Execute the query to retrieve a single product by ID.
-	if err := db.GetContext(ctx, &p, q, id); err != nil {
+	if err := sqlx.GetContext(ctx, db, &p, q, id, org, archived); err != nil {
 
 		// If it is, return the ErrNotFound error.
 		if err == sql.ErrNoRows {
@@ -76,76 +126,461 @@ func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*Product, error) {
 	return &p, nil
 }
 
-// Create inserts a new product into the database
+// Create inserts a new product into the database. The product belongs to
+// the organization of the user creating it.
 func Create(ctx context.Context, db *sqlx.DB, user auth.Claims, newProduct NewProduct, currentTime time.Time) (*Product, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	product, err := create(ctx, tx, user, newProduct, currentTime)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing product")
+	}
+
+	return product, nil
+}
+
+// create inserts a new product inside tx along with its first revision and
+// price, its audit entry and its event.
+func create(ctx context.Context, tx *sqlx.Tx, user auth.Claims, newProduct NewProduct, currentTime time.Time) (*Product, error) {
+	// Match the precision PostgreSQL stores so the returned product is
+	// identical to what Retrieve reads back.
+	currentTime = currentTime.UTC().Truncate(time.Microsecond)
+
 	product := &Product{
 		ID:          uuid.New().String(),
 		Name:        newProduct.Name,
+		SKU:         newProduct.SKU,
+		Barcode:     newProduct.Barcode,
 		Cost:        newProduct.Cost,
 		Quantity:    newProduct.Quantity,
+		Available:   newProduct.Quantity,
 		UserID:      user.Subject,
+		OrgID:       user.OrgID,
 		DateCreated: currentTime,
 		DateUpdated: currentTime,
+		CategoryID:  newProduct.CategoryID,
+		Tags:        normalizeTags(newProduct.Tags),
+		Version:     1,
 	}
 
-	const query = `INSERT INTO products(id, name, cost, quantity, user_id, date_created, date_updated) VALUES($1, $2, $3, $4, $5, $6, $7) RETURNING *`
-	productFromDB := make([]Product, 1)
+	if product.CategoryID != nil {
+		if err := checkCategory(ctx, tx, product.OrgID, *product.CategoryID); err != nil {
+			return nil, err
+		}
+	}
+
+	// The search document is kept in step with the name and tags, see
+	// Search.
+	const query = `INSERT INTO products(id, name, cost, quantity, user_id, org_id, date_created, date_updated, category_id, tags, search, sku, barcode) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, product_search($2, $10), NULLIF($11, ''), NULLIF($12, ''))`
 
-	err := db.SelectContext(ctx, &productFromDB, query, product.ID, product.Name, product.Cost, product.Quantity, product.UserID, product.DateCreated, product.DateUpdated)
+	_, err := tx.ExecContext(ctx, query, product.ID, product.Name, product.Cost, product.Quantity, product.UserID, product.OrgID, product.DateCreated, product.DateUpdated, product.CategoryID, product.Tags, product.SKU, product.Barcode)
 	if err != nil {
+		if dup := duplicateError(err); dup != nil {
+			return nil, dup
+		}
 		return nil, errors.Wrapf(err, "inserting product: %v", product)
 	}
 
-	return &productFromDB[0], nil
+	if err := insertRevision(ctx, tx, user, *product); err != nil {
+		return nil, err
+	}
+
+	if _, err := insertPrice(ctx, tx, user, product.ID, product.Cost, currentTime, currentTime); err != nil {
+		return nil, err
+	}
+
+	change := audit.Change{
+		OrgID:      product.OrgID,
+		Action:     audit.Create,
+		Resource:   audit.Product,
+		ResourceID: product.ID,
+		After:      product,
+	}
+	if err := audit.Record(ctx, tx, user, change, currentTime); err != nil {
+		return nil, err
+	}
+
+	if err := event.Publish(ctx, tx, product.OrgID, event.ProductCreated, product, currentTime); err != nil {
+		return nil, err
+	}
+
+	return product, nil
 }
 
-func Update(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, update UpdateProduct, now time.Time) error {
+// Update modifies a product. Callers need the product:write permission for
+// their own products or for any product, and version must be the current
+// version of the product so changes made in the meantime are not lost.
+func Update(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, version int, update UpdateProduct, now time.Time) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
 
-	product, err := Retrieve(ctx, db, id)
+	tx, err := db.BeginTxx(ctx, nil)
 	if err != nil {
-		return errors.Wrap(err, "updating product")
+		return errors.Wrap(err, "beginning transaction")
 	}
+	defer tx.Rollback()
 
-	fmt.Println("userID", product.UserID)
-	fmt.Println("subject", user.Subject)
-	if !user.HasRole(auth.RoleAdmin) && product.UserID != user.Subject {
+	owner, err := lock(ctx, tx, user, id)
+	if err != nil {
+		return err
+	}
+
+	if !user.Can(auth.PermProductWrite, owner) {
 		return ErrForbidden
 	}
 
+	before, err := retrieve(ctx, tx, nil, id, false)
+	if err != nil {
+		return errors.Wrap(err, "updating product")
+	}
+
+	if before.Version != version {
+		return ErrVersionMismatch
+	}
+
+	// A new cost is a price change taking effect right away.
+	if update.Cost != nil && *update.Cost != before.Cost {
+		if _, err := insertPrice(ctx, tx, user, id, *update.Cost, now, now); err != nil {
+			return err
+		}
+	}
+
+	if _, err := apply(ctx, tx, user, audit.Update, before, update, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing product update")
+	}
+	return nil
+}
+
+// apply writes update on top of before, the current state of a product
+// locked in tx, and records the new revision, the audit entry of the action
+// and the events of the change.
+func apply(ctx context.Context, tx *sqlx.Tx, user auth.Claims, action string, before *Product, update UpdateProduct, now time.Time) (*Product, error) {
+	product := *before
 	if update.Name != nil {
 		product.Name = *update.Name
 	}
+	if update.SKU != nil {
+		product.SKU = *update.SKU
+	}
+	if update.Barcode != nil {
+		product.Barcode = *update.Barcode
+	}
 	if update.Cost != nil {
 		product.Cost = *update.Cost
 	}
-	if update.Quantity != nil {
+	if update.Quantity != nil && *update.Quantity != product.Quantity {
+		has, err := hasVariants(ctx, tx, product.ID)
+		if err != nil {
+			return nil, err
+		}
+		if has {
+			return nil, ErrHasVariants
+		}
+		stocked, err := warehouse.Stocked(ctx, tx, product.ID, "")
+		if err != nil {
+			return nil, err
+		}
+		if stocked {
+			return nil, ErrStocked
+		}
 		product.Quantity = *update.Quantity
+		product.Available = product.Quantity - product.Sold
 	}
-	product.DateUpdated = now
+	if update.CategoryID != nil {
+		product.CategoryID = nil
+		if *update.CategoryID != "" {
+			if err := checkCategory(ctx, tx, product.OrgID, *update.CategoryID); err != nil {
+				return nil, err
+			}
+			product.CategoryID = update.CategoryID
+		}
+	}
+	if update.Tags != nil {
+		product.Tags = normalizeTags(*update.Tags)
+	}
+	product.DateUpdated = now.UTC()
+	product.Version++
 
 	const q = `UPDATE products SET 
 	name = $1, cost = $2,
-	quantity = $3,
-	date_updated = $4 WHERE id = $5`
+	quantity = $3, category_id = $4, tags = $5, search = product_search($1, $5),
+	date_updated = $6, version = $7, sku = NULLIF($8, ''), barcode = NULLIF($9, '') WHERE id = $10`
 
-	_, err = db.ExecContext(ctx, q, product.Name, product.Cost,
-		product.Quantity, product.DateUpdated, product.ID)
+	_, err := tx.ExecContext(ctx, q, product.Name, product.Cost,
+		product.Quantity, product.CategoryID, product.Tags, product.DateUpdated, product.Version, product.SKU, product.Barcode, product.ID)
 
 	if err != nil {
-		return errors.Wrap(err, "updating product")
+		if dup := duplicateError(err); dup != nil {
+			return nil, dup
+		}
+		return nil, errors.Wrap(err, "updating product")
 	}
-	return nil
+
+	if err := insertRevision(ctx, tx, user, product); err != nil {
+		return nil, err
+	}
+
+	change := audit.Change{
+		OrgID:      product.OrgID,
+		Action:     action,
+		Resource:   audit.Product,
+		ResourceID: product.ID,
+		Before:     before,
+		After:      product,
+	}
+	if err := audit.Record(ctx, tx, user, change, now); err != nil {
+		return nil, err
+	}
+
+	if err := event.Publish(ctx, tx, product.OrgID, event.ProductUpdated, product, now); err != nil {
+		return nil, err
+	}
+
+	// Lowering the quantity below what was already sold also takes the
+	// product out of stock.
+	if before.Available > 0 && product.Available <= 0 {
+		if err := event.Publish(ctx, tx, product.OrgID, event.ProductOutOfStock, product, now); err != nil {
+			return nil, err
+		}
+	}
+
+	return &product, nil
 }
 
-func Delete(ctx context.Context, db *sqlx.DB, id string) error {
+// Delete archives a product. Its sales are kept, so revenue reports do not
+// change, and it can be brought back with Restore until it is purged.
+// Callers need the product:delete permission for their own products or for
+// any product.
+func Delete(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, now time.Time) error {
 	if _, err := uuid.Parse(id); err != nil {
 		return ErrInvalidID
 	}
-	const q = `DELETE FROM products WHERE id = $1`
-	_, err := db.ExecContext(ctx, q, id)
 
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	owner, err := lock(ctx, tx, user, id)
+	if err != nil {
+		return err
+	}
+
+	if !user.Can(auth.PermProductDelete, owner) {
+		return ErrForbidden
+	}
+
+	before, err := retrieve(ctx, tx, nil, id, false)
 	if err != nil {
-		return errors.Wrap(err, "deleting product")
+		return err
+	}
+
+	deleted := now.UTC()
+
+	const q = `UPDATE products SET date_deleted = $1, version = version + 1 WHERE id = $2`
+	if _, err := tx.ExecContext(ctx, q, deleted, id); err != nil {
+		return errors.Wrap(err, "archiving product")
+	}
+
+	after := *before
+	after.DateDeleted = &deleted
+	after.Version++
+
+	change := audit.Change{
+		OrgID:      before.OrgID,
+		Action:     audit.Delete,
+		Resource:   audit.Product,
+		ResourceID: id,
+		Before:     before,
+		After:      after,
+	}
+	if err := audit.Record(ctx, tx, user, change, now); err != nil {
+		return err
+	}
+
+	if err := event.Publish(ctx, tx, before.OrgID, event.ProductDeleted, after, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing product delete")
 	}
 	return nil
 }
+
+// Restore brings back an archived product of the caller's organization.
+func Restore(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, now time.Time) (*Product, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	var locked string
+
+	const q1 = `SELECT id FROM products WHERE id = $1 AND ($2::UUID IS NULL OR org_id = $2)
+	AND date_deleted IS NOT NULL FOR UPDATE`
+	if err := tx.GetContext(ctx, &locked, q1, id, user.OrgScope()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotArchived
+		}
+		return nil, errors.Wrap(err, "locking product")
+	}
+
+	before, err := retrieve(ctx, tx, nil, id, true)
+	if err != nil {
+		return nil, err
+	}
+
+	product := *before
+	product.DateDeleted = nil
+	product.DateUpdated = now.UTC()
+	product.Version++
+
+	const q2 = `UPDATE products SET date_deleted = NULL, date_updated = $1, version = version + 1 WHERE id = $2`
+	if _, err := tx.ExecContext(ctx, q2, product.DateUpdated, id); err != nil {
+		return nil, errors.Wrap(err, "restoring product")
+	}
+
+	change := audit.Change{
+		OrgID:      product.OrgID,
+		Action:     audit.Restore,
+		Resource:   audit.Product,
+		ResourceID: id,
+		Before:     before,
+		After:      product,
+	}
+	if err := audit.Record(ctx, tx, user, change, now); err != nil {
+		return nil, err
+	}
+
+	if err := event.Publish(ctx, tx, product.OrgID, event.ProductRestored, product, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing product restore")
+	}
+	return &product, nil
+}
+
+// Purge permanently removes the products of the caller's organization that
+// were archived before the given time. Along with each product go its
+// variants, prices, revisions, sales with their refunds, stock levels and
+// received transfers. Orders left without any line are removed too; other
+// orders keep their remaining lines and lose the total of the purged ones.
+// Products with transfers still on their way are kept until they are
+// received. It returns how many products were removed.
+func Purge(ctx context.Context, db *sqlx.DB, user auth.Claims, before time.Time, now time.Time) (int, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	var purged []Product
+
+	const q = `DELETE FROM products AS p WHERE p.date_deleted < $1 AND ($2::UUID IS NULL OR p.org_id = $2)
+	AND NOT EXISTS (SELECT 1 FROM stock_transfers AS t WHERE t.product_id = p.id AND t.status = 'shipped')
+	RETURNING id, name, COALESCE(sku, '') AS sku, COALESCE(barcode, '') AS barcode, cost, quantity, user_id, org_id, date_created, date_updated, category_id, tags, version, date_deleted`
+	if err := tx.SelectContext(ctx, &purged, q, before.UTC(), user.OrgScope()); err != nil {
+		return 0, errors.Wrap(err, "purging products")
+	}
+
+	const empty = `DELETE FROM orders AS o WHERE ($1::UUID IS NULL OR o.org_id = $1)
+	AND NOT EXISTS (SELECT 1 FROM sales AS s WHERE s.order_id = o.order_id)`
+	if _, err := tx.ExecContext(ctx, empty, user.OrgScope()); err != nil {
+		return 0, errors.Wrap(err, "purging empty orders")
+	}
+
+	for _, p := range purged {
+		change := audit.Change{
+			OrgID:      p.OrgID,
+			Action:     audit.Purge,
+			Resource:   audit.Product,
+			ResourceID: p.ID,
+			Before:     p,
+		}
+		if err := audit.Record(ctx, tx, user, change, now); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "committing product purge")
+	}
+	return len(purged), nil
+}
+
+// lock locks the row of a product of the caller's organization inside tx
+// and returns the ID of its owner. Archived products cannot be locked.
+func lock(ctx context.Context, tx *sqlx.Tx, user auth.Claims, id string) (string, error) {
+	var owner string
+
+	const q = `SELECT user_id FROM products WHERE id = $1 AND ($2::UUID IS NULL OR org_id = $2)
+	AND date_deleted IS NULL FOR UPDATE`
+	if err := tx.GetContext(ctx, &owner, q, id, user.OrgScope()); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrNotFound
+		}
+		return "", errors.Wrap(err, "locking product")
+	}
+
+	return owner, nil
+}
+
+// checkCategory returns ErrCategoryNotFound unless id is a category of the
+// org organization.
+func checkCategory(ctx context.Context, tx *sqlx.Tx, org, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrCategoryNotFound
+	}
+
+	var exists bool
+
+	const q = `SELECT EXISTS (SELECT 1 FROM categories WHERE category_id = $1 AND org_id = $2)`
+	if err := tx.GetContext(ctx, &exists, q, id, org); err != nil {
+		return errors.Wrap(err, "selecting category")
+	}
+	if !exists {
+		return ErrCategoryNotFound
+	}
+	return nil
+}
+
+// duplicateError gives the error for a product or variant whose SKU or
+// barcode is already in use, when err is the unique violation of one of
+// them, and nil otherwise.
+func duplicateError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+		return nil
+	}
+
+	switch pqErr.Constraint {
+	case "products_org_id_sku_idx", "product_variants_org_id_sku_idx":
+		return ErrDuplicateSKU
+	case "products_org_id_barcode_idx":
+		return ErrDuplicateBarcode
+	default:
+		return nil
+	}
+}
@@ -2,12 +2,21 @@ package product
 
 import (
 	"context"
+	"encoding/json"
+	"sales_service/internal/audit"
+	"sales_service/internal/category"
+	"sales_service/internal/organization"
+	"sales_service/internal/platform/auth"
 	"sales_service/internal/platform/database/databasetest"
 	"sales_service/internal/schema"
+	"sales_service/internal/warehouse"
+	"strings"
 
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/go-faster/errors"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -23,12 +32,13 @@ func TestProducts(t *testing.T) {
 		Quantity: 20,
 	}
 	now := time.Date(2024, 5, 5, 5, 5, 5, 0, time.UTC)
-	product1, err := Create(ctx, db, NewProduct, now)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+	product1, err := Create(ctx, db, claims, NewProduct, now)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	product2, err := Retrieve(ctx, db, product1.ID)
+	product2, err := Retrieve(ctx, db, claims, product1.ID, RetrieveOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -48,12 +58,999 @@ func TestProductList(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	products, err := List(ctx, db)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, time.Now(), time.Hour)
+
+	page, err := List(ctx, db, claims, ListFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(page.Items) != 2 || page.Total != 2 {
+		t.Fatalf("expected 2 products, got %d of %d", len(page.Items), page.Total)
+	}
+
+	// Walk the listing one product at a time, ordered by revenue.
+	var names []string
+	filter := ListFilter{SortBy: "revenue", Desc: true, Limit: 1}
+	for {
+		page, err := List(ctx, db, claims, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range page.Items {
+			names = append(names, p.Name)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		filter.Cursor = page.NextCursor
+	}
+
+	if diff := cmp.Diff([]string{"Lego City", "Lego Chima"}, names); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+
+	page, err = List(ctx, db, claims, ListFilter{Name: "chima"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Total != 1 || page.Items[0].Name != "Lego Chima" {
+		t.Fatalf("expected only Lego Chima, got %+v", page.Items)
+	}
+}
+
+func TestProductAudit(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+	claims.Permissions = []string{auth.Any(auth.PermProductWrite), auth.Any(auth.PermProductDelete)}
+
+	p, err := Create(ctx, db, claims, NewProduct{Name: "Duplo", Cost: 1000, Quantity: 5}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cost := 1200
+	if err := Update(ctx, db, claims, p.ID, 1, UpdateProduct{Cost: &cost}, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Update(ctx, db, claims, p.ID, 1, UpdateProduct{Cost: &cost}, now.Add(time.Minute)); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("expected ErrVersionMismatch, got %v", err)
+	}
+	if err := Delete(ctx, db, claims, p.ID, now.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := audit.List(ctx, db, claims, audit.Filter{Resource: audit.Product, ResourceID: p.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actions []string
+	for _, e := range entries {
+		actions = append(actions, e.Action)
+		if e.ActorID != claims.Subject {
+			t.Fatalf("expected actor %s, got %s", claims.Subject, e.ActorID)
+		}
+	}
+	if diff := cmp.Diff([]string{audit.Delete, audit.Update, audit.Create}, actions); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+
+	var before, after Product
+	if err := json.Unmarshal(entries[1].Before, &before); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(entries[1].After, &after); err != nil {
+		t.Fatal(err)
+	}
+	if before.Cost != 1000 || after.Cost != 1200 {
+		t.Fatalf("expected cost to change from 1000 to 1200, got %d to %d", before.Cost, after.Cost)
+	}
+}
+
+func TestProductOrgIsolation(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+
+	if err := schema.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	store, err := organization.Create(ctx, db, organization.NewOrganization{Name: "Second store"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a04", store.ID, []string{auth.RoleAdmin}, now, time.Hour)
+	other.Permissions = []string{auth.Any(auth.PermProductWrite)}
+
+	p, err := Create(ctx, db, other, NewProduct{Name: "Duplo", Cost: 1000, Quantity: 5}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The default organization does not see the other store's product.
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+	claims.Permissions = []string{auth.Any(auth.PermProductWrite)}
+
+	if _, err := Retrieve(ctx, db, claims, p.ID, RetrieveOptions{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	name := "Stolen"
+	if err := Update(ctx, db, claims, p.ID, p.Version, UpdateProduct{Name: &name}, now); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	page, err := List(ctx, db, other, ListFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || page.Items[0].ID != p.ID {
+		t.Fatalf("expected only %s, got %+v", p.ID, page.Items)
+	}
+
+	// A super-admin sees every organization.
+	super := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleSuperAdmin}, now, time.Hour)
+
+	page, err = List(ctx, db, super, ListFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 3 {
+		t.Fatalf("expected 3 products, got %d", page.Total)
+	}
+}
+
+func TestProductArchive(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2024, 5, 5, 5, 5, 5, 0, time.UTC)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+	claims.Permissions = []string{auth.Any(auth.PermProductDelete)}
+
+	p, err := Create(ctx, db, claims, NewProduct{Name: "Duplo", Cost: 10, Quantity: 5}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sale, err := AddSale(ctx, db, claims, NewSale{Quantity: 2, Paid: 20}, p.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Delete(ctx, db, claims, p.ID, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Archived products are hidden and cannot be sold, but keep their sales.
+	if _, err := Retrieve(ctx, db, claims, p.ID, RetrieveOptions{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := AddSale(ctx, db, claims, NewSale{Quantity: 1, Paid: 10}, p.ID, now); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	got, err := Retrieve(ctx, db, claims, p.ID, RetrieveOptions{Archived: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DateDeleted == nil || got.Revenue != 20 {
+		t.Fatalf("expected an archived product with its revenue, got %+v", got)
+	}
+
+	page, err := List(ctx, db, claims, ListFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 0 {
+		t.Fatalf("expected no products, got %d", page.Total)
+	}
+	page, err = List(ctx, db, claims, ListFilter{Archived: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 {
+		t.Fatalf("expected the archived product, got %d", page.Total)
+	}
+
+	restored, err := Restore(ctx, db, claims, p.ID, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.DateDeleted != nil {
+		t.Fatalf("expected the product to be restored, got %+v", restored)
+	}
+	if _, err := Restore(ctx, db, claims, p.ID, now); !errors.Is(err, ErrNotArchived) {
+		t.Fatalf("expected ErrNotArchived, got %v", err)
+	}
+
+	// A product with units on their way between warehouses is kept until
+	// they are received.
+	shipped, err := Create(ctx, db, claims, NewProduct{Name: "Duplo Town", Cost: 10}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ws []*warehouse.Warehouse
+	for _, name := range []string{"North", "South"} {
+		w, err := warehouse.Create(ctx, db, claims, warehouse.NewWarehouse{Name: name}, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws = append(ws, w)
+	}
+	if _, err := warehouse.SetStock(ctx, db, claims, ws[0].ID, shipped.ID, warehouse.Count{Quantity: 3}, now); err != nil {
+		t.Fatal(err)
+	}
+	nt := warehouse.NewTransfer{ProductID: shipped.ID, FromWarehouseID: ws[0].ID, ToWarehouseID: ws[1].ID, Quantity: 3}
+	if _, err := warehouse.Ship(ctx, db, claims, nt, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := Delete(ctx, db, claims, shipped.ID, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only products archived before the cutoff are purged.
+	if err := Delete(ctx, db, claims, p.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	n, err := Purge(ctx, db, claims, now.Add(-time.Hour), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("expected nothing purged, got %d", n)
+	}
+	n, err = Purge(ctx, db, claims, now.Add(Retention), now.Add(Retention))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 product purged, got %d", n)
+	}
+	if _, err := Retrieve(ctx, db, claims, p.ID, RetrieveOptions{Archived: true}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := Retrieve(ctx, db, claims, shipped.ID, RetrieveOptions{Archived: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The order of the purged sale was left without lines.
+	var orders int
+	if err := db.GetContext(ctx, &orders, `SELECT COUNT(*) FROM orders WHERE order_id = $1`, sale.OrderID); err != nil {
+		t.Fatal(err)
+	}
+	if orders != 0 {
+		t.Fatalf("expected the order to be purged, got %d", orders)
+	}
+}
+
+func TestProductRevisions(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2024, 5, 5, 5, 5, 5, 0, time.UTC)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+	claims.Permissions = []string{auth.Any(auth.PermProductWrite)}
+
+	p, err := Create(ctx, db, claims, NewProduct{Name: "Duplo", Cost: 10, Quantity: 5}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cost := 12
+	if err := Update(ctx, db, claims, p.ID, 1, UpdateProduct{Cost: &cost}, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddSale(ctx, db, claims, NewSale{Quantity: 1, Paid: 12}, p.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	name := "Duplo Train"
+	if err := Update(ctx, db, claims, p.ID, 3, UpdateProduct{Name: &name}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sale bumped the version without writing a revision.
+	revs, err := ListRevisions(ctx, db, claims, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []int
+	for _, r := range revs {
+		versions = append(versions, r.Version)
+	}
+	if diff := cmp.Diff([]int{1, 2, 4}, versions); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+
+	d, err := DiffRevisions(ctx, db, claims, p.ID, 1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FieldChange{
+		{Field: "name", From: "Duplo", To: "Duplo Train"},
+		{Field: "cost", From: 10, To: 12},
+	}
+	if diff := cmp.Diff(want, d.Changes); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := Rollback(ctx, db, claims, p.ID, 3, now); !errors.Is(err, ErrRevisionNotFound) {
+		t.Fatalf("expected ErrRevisionNotFound, got %v", err)
+	}
+
+	got, err := Rollback(ctx, db, claims, p.ID, 1, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Duplo" || got.Cost != 10 || got.Version != 5 || got.Sold != 1 {
+		t.Fatalf("expected the first revision at version 5, got %+v", got)
+	}
+}
+
+func TestProductPrices(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2024, 5, 5, 5, 5, 5, 0, time.UTC)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+	claims.Permissions = []string{auth.Any(auth.PermProductWrite)}
+
+	p, err := Create(ctx, db, claims, NewProduct{Name: "Duplo", Cost: 10, Quantity: 5}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	past := now.Add(-time.Hour)
+	if _, err := SchedulePrice(ctx, db, claims, p.ID, NewPrice{Cost: 8, EffectiveFrom: &past}, now); !errors.Is(err, ErrPastPrice) {
+		t.Fatalf("expected ErrPastPrice, got %v", err)
+	}
+
+	later := now.Add(24 * time.Hour)
+	if _, err := SchedulePrice(ctx, db, claims, p.ID, NewPrice{Cost: 15, EffectiveFrom: &later}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Until it is due the scheduled price changes nothing, not even a sale.
+	s, err := AddSale(ctx, db, claims, NewSale{Quantity: 1, Paid: 9}, p.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ListPrice != 10 {
+		t.Fatalf("expected the sale to be listed at 10, got %d", s.ListPrice)
+	}
+
+	got, err := Retrieve(ctx, db, claims, p.ID, RetrieveOptions{AsOf: &later})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Cost != 15 {
+		t.Fatalf("expected a cost of 15 as of the change, got %d", got.Cost)
+	}
+	if _, err := Retrieve(ctx, db, claims, p.ID, RetrieveOptions{AsOf: &past}); !errors.Is(err, ErrNoPrice) {
+		t.Fatalf("expected ErrNoPrice before the product existed, got %v", err)
+	}
+
+	if n, err := ApplyPrices(ctx, db, now); err != nil || n != 0 {
+		t.Fatalf("expected no price to apply yet, got %d, %v", n, err)
+	}
+	if n, err := ApplyPrices(ctx, db, later); err != nil || n != 1 {
+		t.Fatalf("expected 1 price applied, got %d, %v", n, err)
+	}
+
+	got, err = Retrieve(ctx, db, claims, p.ID, RetrieveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Cost != 15 {
+		t.Fatalf("expected the scheduled cost of 15, got %d", got.Cost)
+	}
+
+	prices, err := ListPrices(ctx, db, claims, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prices) != 2 {
+		t.Fatalf("expected 2 prices, got %d", len(prices))
+	}
+	if err := CancelPrice(ctx, db, claims, p.ID, prices[1].ID, later); !errors.Is(err, ErrPriceNotFound) {
+		t.Fatalf("expected a price in effect not to be cancelled, got %v", err)
+	}
+}
+
+func TestProductCategories(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2024, 5, 5, 5, 5, 5, 0, time.UTC)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+	claims.Permissions = []string{auth.Any(auth.PermProductWrite)}
+
+	toys, err := category.Create(ctx, db, claims, category.NewCategory{Name: "Toys"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lego, err := category.Create(ctx, db, claims, category.NewCategory{Name: "Lego", ParentID: &toys.ID}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	city, err := Create(ctx, db, claims, NewProduct{Name: "City", Cost: 10, Quantity: 5, CategoryID: &lego.ID, Tags: []string{" Kids", "kids", "Outdoor"}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"kids", "outdoor"}, []string(city.Tags)); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := Create(ctx, db, claims, NewProduct{Name: "Ball", Cost: 5, Quantity: 5, CategoryID: &toys.ID, Tags: []string{"kids"}}, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Create(ctx, db, claims, NewProduct{Name: "Book", Cost: 5, Quantity: 5}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := "a0eebc99-9c0b-4ef8-bb6d-6bb9bd390aff"
+	if _, err := Create(ctx, db, claims, NewProduct{Name: "Lost", Cost: 5, Quantity: 5, CategoryID: &missing}, now); !errors.Is(err, ErrCategoryNotFound) {
+		t.Fatalf("expected ErrCategoryNotFound, got %v", err)
+	}
+
+	names := func(f ListFilter) []string {
+		t.Helper()
+		f.SortBy = "name"
+		page, err := List(ctx, db, claims, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, p := range page.Items {
+			got = append(got, p.Name)
+		}
+		return got
+	}
+
+	// A category lists the products of its subcategories too.
+	if diff := cmp.Diff([]string{"Ball", "City"}, names(ListFilter{CategoryID: toys.ID})); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"City"}, names(ListFilter{CategoryID: lego.ID})); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"Ball", "City"}, names(ListFilter{Tags: []string{"KIDS"}})); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"City"}, names(ListFilter{Tags: []string{"kids", "outdoor"}})); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+
+	// Clearing the category and tags takes the product out of both filters.
+	none, tags := "", []string{}
+	if err := Update(ctx, db, claims, city.ID, 1, UpdateProduct{CategoryID: &none, Tags: &tags}, now); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"Ball"}, names(ListFilter{CategoryID: toys.ID, Tags: []string{"kids"}})); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err := ListTags(ctx, db, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]Tag{{Name: "kids", Products: 1}}, got); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestProductSearch(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2024, 5, 5, 5, 5, 5, 0, time.UTC)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+	claims.Permissions = []string{auth.Any(auth.PermProductWrite)}
+
+	for _, np := range []NewProduct{
+		{Name: "Wooden Train Set", Cost: 10, Quantity: 5, Tags: []string{"railway"}},
+		{Name: "Train Whistle", Cost: 10, Quantity: 5},
+		{Name: "Teddy Bear", Cost: 10, Quantity: 5},
+	} {
+		if _, err := Create(ctx, db, claims, np, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := func(query string) []string {
+		t.Helper()
+		list, err := Search(ctx, db, claims, query, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, r := range list {
+			got = append(got, r.Name)
+		}
+		return got
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"trai", []string{"Train Whistle", "Wooden Train Set"}},
+		{"wooden tr", []string{"Wooden Train Set"}},
+		{"railway", []string{"Wooden Train Set"}},
+		{"whistel", []string{"Train Whistle"}},
+		{"lorry", []string{}},
+	}
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.want, names(tt.query)); diff != "" {
+			t.Errorf("%q: mismatch (-want +got):\n%s", tt.query, diff)
+		}
+	}
+
+	// Renaming a product updates what it is found by.
+	list, err := Search(ctx, db, claims, "teddy", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "Plush Rabbit"
+	if err := Update(ctx, db, claims, list[0].ID, 1, UpdateProduct{Name: &name}, now); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"Plush Rabbit"}, names("rabbit")); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := Search(ctx, db, claims, " -- ", 0); !errors.Is(err, ErrEmptySearch) {
+		t.Fatalf("expected ErrEmptySearch, got %v", err)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name  string
+		words []string
+		want  string
+	}{
+		{"Lego City", []string{"leg"}, "<mark>Lego</mark> City"},
+		{"Train Whistle", []string{"whistel"}, "Train <mark>Whistle</mark>"},
+		{"Train Whistle", []string{"tran", "whistle"}, "<mark>Train</mark> <mark>Whistle</mark>"},
+		{"Cat & Dog", []string{"dog"}, "Cat &amp; <mark>Dog</mark>"},
+		{"Box", []string{"bix"}, "Box"},
+	}
+	for _, tt := range tests {
+		if got := highlight(tt.name, tt.words); got != tt.want {
+			t.Errorf("highlight(%q, %q) = %q, want %q", tt.name, tt.words, got, tt.want)
+		}
+	}
+}
+
+func TestProductImport(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2024, 5, 5, 5, 5, 5, 0, time.UTC)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+	claims.Permissions = []string{auth.Any(auth.PermProductWrite)}
+
+	// The fourth row has the SKU of the first, so it updates what the first
+	// created.
+	const file = `name,sku,cost,quantity,tags
+Bricks,BR-1,100,10,kids|Blocks
+Ball,,abc,5,
+Kite,KT-1,50,0,
+Bricks Deluxe,BR-1,150,10,blocks|kids
+`
+	want := ImportReport{Rows: 4, Created: 1, Updated: 1, Failed: 2}
+
+	for _, dryRun := range []bool{true, false} {
+		report, err := Import(ctx, db, claims, strings.NewReader(file), FormatCSV, dryRun, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var lines []int
+		for _, e := range report.Errors {
+			lines = append(lines, e.Line)
+		}
+		if diff := cmp.Diff([]int{3, 4}, lines); diff != "" {
+			t.Fatalf("mismatch (-want +got):\n%s", diff)
+		}
+
+		want.DryRun = dryRun
+		report.Errors = nil
+		if diff := cmp.Diff(want, *report); diff != "" {
+			t.Fatalf("mismatch (-want +got):\n%s", diff)
+		}
+
+		page, err := List(ctx, db, claims, ListFilter{Name: "Bricks"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case dryRun && page.Total != 0:
+			t.Fatalf("expected a dry run to keep nothing, got %d products", page.Total)
+		case !dryRun && (page.Total != 1 || page.Items[0].Name != "Bricks Deluxe" || page.Items[0].Cost != 150):
+			t.Fatalf("expected the updated product, got %+v", page.Items)
+		}
+	}
+
+	// Importing the same product again changes nothing, and unknown fields
+	// fail their row.
+	const lines = `{"name": "Bricks Deluxe", "sku": "BR-1", "cost": 150, "quantity": 10}
+
+{"name": "Kite", "colour": "red", "cost": 50, "quantity": 1}
+`
+	report, err := Import(ctx, db, claims, strings.NewReader(lines), FormatNDJSON, false, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Unchanged != 1 || report.Failed != 1 || report.Errors[0].Line != 3 {
+		t.Fatalf("expected 1 unchanged and 1 failed row on line 3, got %+v", report)
+	}
+
+	if _, err := Import(ctx, db, claims, strings.NewReader("name,price\n"), FormatCSV, false, now); !errors.Is(err, ErrImportHeader) {
+		t.Fatalf("expected ErrImportHeader, got %v", err)
+	}
+}
+
+func TestProductBarcodes(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2024, 5, 5, 5, 5, 5, 0, time.UTC)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+	claims.Permissions = []string{auth.Any(auth.PermProductWrite)}
+
+	// The barcode is stored as an EAN-13, and found by its UPC-A too.
+	p, err := Create(ctx, db, claims, NewProduct{Name: "Cola", Barcode: "0036000291452", Cost: 100, Quantity: 10}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, code := range []string{"0036000291452", "036000291452"} {
+		got, err := RetrieveByBarcode(ctx, db, claims, code)
+		if err != nil {
+			t.Fatalf("looking up %s: %v", code, err)
+		}
+		if got.ID != p.ID || got.Barcode != "0036000291452" {
+			t.Fatalf("looking up %s: expected %s, got %+v", code, p.ID, got)
+		}
+	}
+
+	// The UPC-A is the same barcode, so it cannot be given to another product.
+	if _, err := Create(ctx, db, claims, NewProduct{Name: "Diet Cola", Barcode: "036000291452", Cost: 100, Quantity: 10}, now); !errors.Is(err, ErrDuplicateBarcode) {
+		t.Fatalf("expected ErrDuplicateBarcode, got %v", err)
+	}
+
+	if _, err := RetrieveByBarcode(ctx, db, claims, "036000291453"); !errors.Is(err, ErrInvalidBarcode) {
+		t.Fatalf("expected ErrInvalidBarcode, got %v", err)
+	}
+
+	// Clearing the barcode frees it.
+	clear := ""
+	if err := Update(ctx, db, claims, p.ID, p.Version, UpdateProduct{Barcode: &clear}, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RetrieveByBarcode(ctx, db, claims, "036000291452"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestProductVariants(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2024, 5, 5, 5, 5, 5, 0, time.UTC)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+	claims.Permissions = []string{auth.Any(auth.PermProductWrite)}
+
+	p, err := Create(ctx, db, claims, NewProduct{Name: "T-shirt", Cost: 1000, Quantity: 100}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The quantity of the product becomes the sum of its variants.
+	small, err := AddVariant(ctx, db, claims, p.ID, NewVariant{SKU: "TS-S", Attributes: Attributes{"size": "S"}, Cost: 1000, Quantity: 5}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large, err := AddVariant(ctx, db, claims, p.ID, NewVariant{SKU: "TS-L", Attributes: Attributes{"size": "L"}, Cost: 1200, Quantity: 3}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddVariant(ctx, db, claims, p.ID, NewVariant{SKU: "TS-L", Attributes: Attributes{"size": "XL"}}, now); !errors.Is(err, ErrDuplicateSKU) {
+		t.Fatalf("expected ErrDuplicateSKU, got %v", err)
+	}
+
+	// A product with variants is sold by variant, at the cost of the variant.
+	if _, err := AddSale(ctx, db, claims, NewSale{Quantity: 1, Paid: 1000}, p.ID, now); !errors.Is(err, ErrVariantRequired) {
+		t.Fatalf("expected ErrVariantRequired, got %v", err)
+	}
+	sale, err := AddSale(ctx, db, claims, NewSale{VariantID: large.ID, Quantity: 2, Paid: 2400}, p.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sale.VariantID == nil || *sale.VariantID != large.ID || sale.ListPrice != 1200 {
+		t.Fatalf("expected a sale of %s at 1200, got %+v", large.ID, sale)
+	}
+	if _, err := AddSale(ctx, db, claims, NewSale{VariantID: large.ID, Quantity: 2, Paid: 2400}, p.ID, now); !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("expected ErrInsufficientStock, got %v", err)
+	}
+	if _, err := AddSale(ctx, db, claims, NewSale{VariantID: "f0eebc99-9c0b-4ef8-bb6d-6bb9bd390a99", Quantity: 1, Paid: 1000}, p.ID, now); !errors.Is(err, ErrVariantNotFound) {
+		t.Fatalf("expected ErrVariantNotFound, got %v", err)
+	}
+
+	got, err := Retrieve(ctx, db, claims, p.ID, RetrieveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Quantity != 8 || got.Sold != 2 || got.Revenue != 2400 || got.Available != 6 {
+		t.Fatalf("expected rollups of 8, 2, 2400 and 6, got %+v", got)
+	}
+
+	type totals struct {
+		ID                                 string
+		Quantity, Sold, Revenue, Available int
+	}
+	var variants []totals
+	for _, v := range got.Variants {
+		variants = append(variants, totals{v.ID, v.Quantity, v.Sold, v.Revenue, v.Available})
+	}
+	want := []totals{
+		{small.ID, 5, 0, 0, 5},
+		{large.ID, 3, 2, 2400, 1},
+	}
+	if diff := cmp.Diff(want, variants); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+
+	// Stock is set on the variants, not on the product.
+	quantity := 50
+	if err := Update(ctx, db, claims, p.ID, got.Version, UpdateProduct{Quantity: &quantity}, now); !errors.Is(err, ErrHasVariants) {
+		t.Fatalf("expected ErrHasVariants, got %v", err)
+	}
+	if _, err := ModifyVariant(ctx, db, claims, p.ID, small.ID, UpdateVariant{Quantity: &quantity}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sold variants are kept, even once every unit was refunded, the others
+	// can go.
+	if _, err := AddRefund(ctx, db, claims, sale.ID, NewRefund{}, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveVariant(ctx, db, claims, p.ID, large.ID, now); !errors.Is(err, ErrVariantSold) {
+		t.Fatalf("expected ErrVariantSold, got %v", err)
+	}
+	if err := RemoveVariant(ctx, db, claims, p.ID, small.ID, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err = Retrieve(ctx, db, claims, p.ID, RetrieveOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Quantity != 3 || len(got.Variants) != 1 {
+		t.Fatalf("expected a quantity of 3 and one variant, got %d and %d", got.Quantity, len(got.Variants))
+	}
+}
+
+func TestAddSaleStock(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2024, 5, 5, 5, 5, 5, 0, time.UTC)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+
+	p, err := Create(ctx, db, claims, NewProduct{Name: "limited", Cost: 10, Quantity: 5}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fire more concurrent sales than there is stock for; exactly five may win.
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := AddSale(ctx, db, claims, NewSale{Quantity: 1, Paid: 10}, p.ID, now)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var sold, refused int
+	for err := range errs {
+		switch {
+		case err == nil:
+			sold++
+		case errors.Is(err, ErrInsufficientStock):
+			refused++
+		default:
+			t.Fatal(err)
+		}
+	}
+
+	if sold != 5 || refused != 5 {
+		t.Fatalf("expected 5 sold and 5 refused, got %d and %d", sold, refused)
+	}
+
+	got, err := Retrieve(ctx, db, claims, p.ID, RetrieveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Available != 0 {
+		t.Fatalf("expected nothing available, got %d", got.Available)
+	}
+}
+
+func TestAddSaleWarehouse(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2024, 5, 5, 5, 5, 5, 0, time.UTC)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+
+	p, err := Create(ctx, db, claims, NewProduct{Name: "stocked", Cost: 10, Quantity: 5}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := warehouse.Create(ctx, db, claims, warehouse.NewWarehouse{Name: "Main"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := warehouse.SetStock(ctx, db, claims, w.ID, p.ID, warehouse.Count{Quantity: 3}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := AddSale(ctx, db, claims, NewSale{Quantity: 1, Paid: 10}, p.ID, now); !errors.Is(err, warehouse.ErrRequired) {
+		t.Fatalf("expected warehouse.ErrRequired, got %v", err)
+	}
+	sale, err := AddSale(ctx, db, claims, NewSale{WarehouseID: w.ID, Quantity: 3, Paid: 30}, p.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddSale(ctx, db, claims, NewSale{WarehouseID: w.ID, Quantity: 1, Paid: 10}, p.ID, now); !errors.Is(err, warehouse.ErrInsufficientStock) {
+		t.Fatalf("expected warehouse.ErrInsufficientStock, got %v", err)
+	}
+
+	// Refunded units go back to the warehouse they were sold from.
+	one := 1
+	if _, err := AddRefund(ctx, db, claims, sale.ID, NewRefund{Quantity: &one}, now); err != nil {
+		t.Fatal(err)
+	}
+	s, err := warehouse.ProductStock(ctx, db, claims, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.OnHand != 1 {
+		t.Fatalf("expected 1 unit on hand, got %d", s.OnHand)
+	}
+
+	// The quantity of the product follows its stock, so that only the units
+	// on hand are available, and cannot be set apart from it.
+	got, err := Retrieve(ctx, db, claims, p.ID, RetrieveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Quantity != 3 || got.Available != s.OnHand {
+		t.Fatalf("expected 3 units with %d available, got %d with %d", s.OnHand, got.Quantity, got.Available)
+	}
+	quantity := 10
+	if err := Update(ctx, db, claims, p.ID, got.Version, UpdateProduct{Quantity: &quantity}, now); !errors.Is(err, ErrStocked) {
+		t.Fatalf("expected ErrStocked, got %v", err)
+	}
+	if _, err := AddVariant(ctx, db, claims, p.ID, NewVariant{SKU: "STOCKED-RED", Cost: 10}, now); !errors.Is(err, ErrStocked) {
+		t.Fatalf("expected ErrStocked, got %v", err)
+	}
+}
+
+func TestRefundBeforeStock(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2024, 5, 5, 5, 5, 5, 0, time.UTC)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+
+	p, err := Create(ctx, db, claims, NewProduct{Name: "stocked later", Cost: 10, Quantity: 5}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sale, err := AddSale(ctx, db, claims, NewSale{Quantity: 2, Paid: 20}, p.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := warehouse.Create(ctx, db, claims, warehouse.NewWarehouse{Name: "Main"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := warehouse.SetStock(ctx, db, claims, w.ID, p.ID, warehouse.Count{Quantity: 3}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// The refunded unit has no warehouse to go back to, so it is not
+	// available until a stock take counts it.
+	one := 1
+	if _, err := AddRefund(ctx, db, claims, sale.ID, NewRefund{Quantity: &one}, now); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Retrieve(ctx, db, claims, p.ID, RetrieveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Quantity != 4 || got.Available != 3 {
+		t.Fatalf("expected 4 units with 3 available, got %d with %d", got.Quantity, got.Available)
+	}
+}
+
+func TestRefunds(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2024, 5, 5, 5, 5, 5, 0, time.UTC)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+
+	p, err := Create(ctx, db, claims, NewProduct{Name: "refundable", Cost: 10, Quantity: 5}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sale, err := AddSale(ctx, db, claims, NewSale{Quantity: 2, Paid: 20}, p.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	one := 1
+	refund, err := AddRefund(ctx, db, claims, sale.ID, NewRefund{Quantity: &one}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Amount != 10 {
+		t.Fatalf("expected a prorated amount of 10, got %d", refund.Amount)
+	}
+
+	got, err := Retrieve(ctx, db, claims, p.ID, RetrieveOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
+	if got.Sold != 1 || got.Revenue != 10 || got.Available != 4 {
+		t.Fatalf("expected sold 1, revenue 10, available 4, got %d, %d, %d", got.Sold, got.Revenue, got.Available)
+	}
+
+	five := 5
+	if _, err := AddRefund(ctx, db, claims, sale.ID, NewRefund{Quantity: &five}, now); !errors.Is(err, ErrRefundTooLarge) {
+		t.Fatalf("expected ErrRefundTooLarge, got %v", err)
+	}
 
-	if len(products) != 2 {
-		t.Fatalf("expected 2 products, got %d", len(products))
+	// Refunding without a quantity or amount gives back whatever is left.
+	refund, err = AddRefund(ctx, db, claims, sale.ID, NewRefund{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Quantity != 1 || refund.Amount != 10 {
+		t.Fatalf("expected the remaining 1 unit and 10 paid, got %d and %d", refund.Quantity, refund.Amount)
 	}
 }
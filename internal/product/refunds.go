@@ -0,0 +1,157 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"sales_service/internal/audit"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/warehouse"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// AddRefund gives back all or part of a sale. The sale row is locked so that
+// concurrent refunds of the same sale cannot together return more than was
+// sold. Only sales of products of the caller's organization can be refunded.
+func AddRefund(ctx context.Context, db *sqlx.DB, user auth.Claims, saleID string, nr NewRefund, now time.Time) (*Refund, error) {
+	if _, err := uuid.Parse(saleID); err != nil {
+		return nil, ErrInvalidSaleID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	var sale struct {
+		Sale
+		OrgID string `db:"org_id"`
+	}
+
+	const lock = `SELECT s.sale_id, s.order_id, s.product_id, s.variant_id, s.warehouse_id, s.quantity, s.list_price, s.paid, s.date_created, p.org_id
+	FROM sales AS s
+	JOIN products AS p ON p.id = s.product_id
+	WHERE s.sale_id = $1 AND ($2::UUID IS NULL OR p.org_id = $2)
+	FOR UPDATE OF s`
+	if err := tx.GetContext(ctx, &sale, lock, saleID, user.OrgScope()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSaleNotFound
+		}
+		return nil, errors.Wrap(err, "locking sale")
+	}
+
+	// What is left of the sale after earlier refunds.
+	var left struct {
+		Quantity int `db:"quantity"`
+		Amount   int `db:"paid"`
+	}
+
+	const q = `SELECT quantity, paid FROM net_sales WHERE sale_id = $1`
+	if err := tx.GetContext(ctx, &left, q, saleID); err != nil {
+		return nil, errors.Wrap(err, "reading refunded totals")
+	}
+
+	r := Refund{
+		ID:          uuid.New().String(),
+		SaleID:      saleID,
+		Reason:      nr.Reason,
+		UserID:      user.Subject,
+		DateCreated: now.UTC(),
+	}
+
+	switch {
+	case nr.Quantity == nil && nr.Amount == nil:
+		r.Quantity, r.Amount = left.Quantity, left.Amount
+
+	case nr.Amount == nil:
+		r.Quantity = *nr.Quantity
+		r.Amount = left.Amount
+		if r.Quantity < left.Quantity {
+			r.Amount = left.Amount * r.Quantity / left.Quantity
+		}
+
+	case nr.Quantity == nil:
+		r.Amount = *nr.Amount
+
+	default:
+		r.Quantity, r.Amount = *nr.Quantity, *nr.Amount
+	}
+
+	if r.Quantity == 0 && r.Amount == 0 {
+		return nil, ErrInvalidRefund
+	}
+	if r.Quantity > left.Quantity || r.Amount > left.Amount {
+		return nil, ErrRefundTooLarge
+	}
+
+	const ins = `INSERT INTO refunds (refund_id, sale_id, quantity, amount, reason, user_id, date_created)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = tx.ExecContext(ctx, ins, r.ID, r.SaleID, r.Quantity, r.Amount, r.Reason, r.UserID, r.DateCreated)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting refund")
+	}
+
+	if err := bumpVersion(ctx, tx, sale.ProductID); err != nil {
+		return nil, err
+	}
+
+	// Refunded units go back to the warehouse the sale drew them from. A
+	// sale made before the product was stocked has no warehouse to take them
+	// back: the quantity of the product follows its stock again, and they
+	// only count once a stock take records them.
+	if r.Quantity > 0 {
+		var variantID string
+		if sale.VariantID != nil {
+			variantID = *sale.VariantID
+		}
+		if sale.WarehouseID != nil {
+			if err := warehouse.Return(ctx, tx, *sale.WarehouseID, sale.ProductID, variantID, r.Quantity, now); err != nil {
+				return nil, err
+			}
+		} else if err := warehouse.Reconcile(ctx, tx, sale.ProductID, variantID, now); err != nil {
+			return nil, err
+		}
+	}
+
+	change := audit.Change{
+		OrgID:      sale.OrgID,
+		Action:     audit.Create,
+		Resource:   audit.Refund,
+		ResourceID: r.ID,
+		After:      r,
+	}
+	if err := audit.Record(ctx, tx, user, change, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing refund")
+	}
+
+	return &r, nil
+}
+
+// ListRefunds gives all refunds recorded against a sale of the caller's
+// organization.
+func ListRefunds(ctx context.Context, db *sqlx.DB, user auth.Claims, saleID string) ([]Refund, error) {
+	if _, err := uuid.Parse(saleID); err != nil {
+		return nil, ErrInvalidSaleID
+	}
+
+	list := []Refund{}
+
+	const q = `SELECT r.refund_id, r.sale_id, r.quantity, r.amount, r.reason, r.user_id, r.date_created
+	FROM refunds AS r
+	JOIN sales AS s ON s.sale_id = r.sale_id
+	JOIN products AS p ON p.id = s.product_id
+	WHERE r.sale_id = $1 AND ($2::UUID IS NULL OR p.org_id = $2)
+	ORDER BY r.date_created`
+	if err := db.SelectContext(ctx, &list, q, saleID, user.OrgScope()); err != nil {
+		return nil, errors.Wrap(err, "selecting refunds")
+	}
+	return list, nil
+}
@@ -0,0 +1,162 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"sales_service/internal/audit"
+	"sales_service/internal/platform/auth"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrRevisionNotFound is returned for a revision a product never had.
+var ErrRevisionNotFound = errors.New("product revision not found")
+
+// selectRevisions lists the revision columns in the order Revision declares
+// them. It joins products so revisions can be limited to an organization.
+const selectRevisions = `SELECT r.product_id, r.version, r.name, r.cost, r.quantity,
+	COALESCE(r.user_id::text, '') AS user_id, r.date_created
+	FROM product_revisions AS r
+	JOIN products AS p ON p.id = r.product_id`
+
+// insertRevision records the state of p written by user inside tx.
+func insertRevision(ctx context.Context, tx *sqlx.Tx, user auth.Claims, p Product) error {
+	// Products created outside of a request, such as from the admin tool,
+	// have no author.
+	var userID *string
+	if user.Subject != "" {
+		userID = &user.Subject
+	}
+
+	const q = `INSERT INTO product_revisions (product_id, version, name, cost, quantity, user_id, date_created)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	if _, err := tx.ExecContext(ctx, q, p.ID, p.Version, p.Name, p.Cost, p.Quantity, userID, p.DateUpdated); err != nil {
+		return errors.Wrap(err, "inserting product revision")
+	}
+	return nil
+}
+
+// ListRevisions gives every revision of a product of the caller's
+// organization, oldest first. Archived products keep their history.
+func ListRevisions(ctx context.Context, db *sqlx.DB, user auth.Claims, productID string) ([]Revision, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	if _, err := retrieve(ctx, db, user.OrgScope(), productID, true); err != nil {
+		return nil, err
+	}
+
+	list := []Revision{}
+
+	const q = selectRevisions + ` WHERE r.product_id = $1 ORDER BY r.version`
+	if err := db.SelectContext(ctx, &list, q, productID); err != nil {
+		return nil, errors.Wrap(err, "selecting product revisions")
+	}
+
+	return list, nil
+}
+
+// retrieveRevision reads one revision of a product of the org organization,
+// or of any when org is nil.
+func retrieveRevision(ctx context.Context, db sqlx.QueryerContext, org *string, productID string, version int) (*Revision, error) {
+	var r Revision
+
+	const q = selectRevisions + ` WHERE r.product_id = $1 AND r.version = $2
+	AND ($3::UUID IS NULL OR p.org_id = $3)`
+	if err := sqlx.GetContext(ctx, db, &r, q, productID, version, org); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRevisionNotFound
+		}
+		return nil, errors.Wrap(err, "selecting product revision")
+	}
+
+	return &r, nil
+}
+
+// DiffRevisions compares two revisions of a product of the caller's
+// organization and lists the fields changed going from one to the other.
+func DiffRevisions(ctx context.Context, db *sqlx.DB, user auth.Claims, productID string, from, to int) (*Diff, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	a, err := retrieveRevision(ctx, db, user.OrgScope(), productID, from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := retrieveRevision(ctx, db, user.OrgScope(), productID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	d := Diff{
+		ProductID: productID,
+		From:      from,
+		To:        to,
+		Changes:   []FieldChange{},
+	}
+	if a.Name != b.Name {
+		d.Changes = append(d.Changes, FieldChange{Field: "name", From: a.Name, To: b.Name})
+	}
+	if a.Cost != b.Cost {
+		d.Changes = append(d.Changes, FieldChange{Field: "cost", From: a.Cost, To: b.Cost})
+	}
+	if a.Quantity != b.Quantity {
+		d.Changes = append(d.Changes, FieldChange{Field: "quantity", From: a.Quantity, To: b.Quantity})
+	}
+
+	return &d, nil
+}
+
+// Rollback sets the name, cost and quantity of a product of the caller's
+// organization back to those of an earlier revision. It is written as a new
+// revision, so a rollback can itself be rolled back.
+func Rollback(ctx context.Context, db *sqlx.DB, user auth.Claims, productID string, version int, now time.Time) (*Product, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	if _, err := lock(ctx, tx, user, productID); err != nil {
+		return nil, err
+	}
+
+	rev, err := retrieveRevision(ctx, tx, nil, productID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := retrieve(ctx, tx, nil, productID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	update := UpdateProduct{
+		Name:     &rev.Name,
+		Cost:     &rev.Cost,
+		Quantity: &rev.Quantity,
+	}
+	if rev.Cost != before.Cost {
+		if _, err := insertPrice(ctx, tx, user, productID, rev.Cost, now, now); err != nil {
+			return nil, err
+		}
+	}
+	p, err := apply(ctx, tx, user, audit.Rollback, before, update, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing product rollback")
+	}
+	return p, nil
+}
@@ -2,6 +2,11 @@ package product
 
 import (
 	"context"
+	"database/sql"
+	"sales_service/internal/audit"
+	"sales_service/internal/event"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/warehouse"
 	"time"
 
 	"github.com/google/uuid"
@@ -9,33 +14,202 @@ import (
 	"github.com/pkg/errors"
 )
 
-func AddSale(ctx context.Context, db *sqlx.DB, ns NewSale, ProductID string, now time.Time) (*Sale, error) {
+// AddSale records a sale of a single product as an order with one line. The
+// product row is locked for the duration of the transaction so concurrent
+// sales of the same product cannot together sell more than is available. The
+// order belongs to the organization of the product.
+func AddSale(ctx context.Context, db *sqlx.DB, user auth.Claims, ns NewSale, ProductID string, now time.Time) (*Sale, error) {
+	if _, err := uuid.Parse(ProductID); err != nil {
+		return nil, ErrInvalidID
+	}
 
 	s := Sale{
 		ID:          uuid.New().String(),
+		OrderID:     uuid.New().String(),
 		ProductID:   ProductID,
 		Quantity:    ns.Quantity,
 		Paid:        ns.Paid,
-		DateCreated: now,
+		DateCreated: now.UTC(),
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	// A variant is sold at its own cost.
+	var p *Product
+	if ns.VariantID != "" {
+		var v *Variant
+		if p, v, err = ReserveVariant(ctx, tx, user, s.ProductID, ns.VariantID, s.Quantity); err != nil {
+			return nil, err
+		}
+		s.VariantID, s.ListPrice = &v.ID, v.Cost
+	} else {
+		if p, err = Reserve(ctx, tx, user, s.ProductID, s.Quantity); err != nil {
+			return nil, err
+		}
+		if s.ListPrice, err = PriceAt(ctx, tx, s.ProductID, now); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := warehouse.Draw(ctx, tx, user, ns.WarehouseID, s.ProductID, ns.VariantID, s.Quantity, now); err != nil {
+		return nil, err
+	}
+	if ns.WarehouseID != "" {
+		s.WarehouseID = &ns.WarehouseID
+	}
+
+	const qo = `INSERT INTO orders (order_id, user_id, org_id, date_created) VALUES ($1, $2, $3, $4)`
+	if _, err := tx.ExecContext(ctx, qo, s.OrderID, user.Subject, p.OrgID, s.DateCreated); err != nil {
+		return nil, errors.Wrap(err, "inserting order")
+	}
+
+	if err := InsertSale(ctx, tx, s); err != nil {
+		return nil, err
+	}
+
+	change := audit.Change{
+		OrgID:      p.OrgID,
+		Action:     audit.Create,
+		Resource:   audit.Sale,
+		ResourceID: s.ID,
+		After:      s,
+	}
+	if err := audit.Record(ctx, tx, user, change, now); err != nil {
+		return nil, err
+	}
+
+	if err := event.Publish(ctx, tx, p.OrgID, event.SaleCreated, s, now); err != nil {
+		return nil, err
+	}
+	if err := PublishSoldOut(ctx, tx, *p, s.Quantity, now); err != nil {
+		return nil, err
 	}
 
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing sale")
+	}
+
+	return &s, nil
+}
+
+// InsertSale writes a sale row inside tx and bumps the version of the
+// product. Callers are expected to have checked the stock with Reserve in the
+// same transaction.
+func InsertSale(ctx context.Context, tx *sqlx.Tx, s Sale) error {
 	const q = `
-	INSERT INTO sales (sale_id, product_id, quantity, paid, date_created)
-	VALUES ($1, $2, $3, $4, $5)`
-	_, err := db.ExecContext(ctx, q, s.ID, s.ProductID, s.Quantity, s.Paid, s.DateCreated)
+	INSERT INTO sales (sale_id, order_id, product_id, variant_id, warehouse_id, quantity, list_price, paid, date_created)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := tx.ExecContext(ctx, q, s.ID, s.OrderID, s.ProductID, s.VariantID, s.WarehouseID, s.Quantity, s.ListPrice, s.Paid, s.DateCreated)
 
+	if err != nil {
+		return errors.Wrap(err, "inserting sale")
+	}
+
+	if err := bumpVersion(ctx, tx, s.ProductID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// bumpVersion increases the version of a product whose sales changed.
+func bumpVersion(ctx context.Context, tx *sqlx.Tx, productID string) error {
+	const q = `UPDATE products SET version = version + 1 WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, q, productID); err != nil {
+		return errors.Wrap(err, "updating product version")
+	}
+	return nil
+}
+
+// Reserve locks the product row and checks that quantity more units can be
+// sold, returning the locked product. The lock is held until tx ends, so the
+// caller must insert the sale in the same transaction. When several products
+// are reserved in one transaction they should be reserved in a stable order
+// to avoid deadlocks. Only products of the caller's organization can be
+// reserved, and archived products cannot be sold. Products with variants are
+// reserved with ReserveVariant instead.
+func Reserve(ctx context.Context, tx *sqlx.Tx, user auth.Claims, productID string, quantity int) (*Product, error) {
+	p, err := reserve(ctx, tx, user, productID)
 	if err != nil {
 		return nil, err
 	}
 
-	return &s, nil
+	has, err := hasVariants(ctx, tx, productID)
+	if err != nil {
+		return nil, err
+	}
+	if has {
+		return nil, ErrVariantRequired
+	}
+
+	if quantity > p.Available {
+		return nil, ErrInsufficientStock
+	}
+
+	return p, nil
+}
+
+// reserve locks the product row and computes its stock, without checking it.
+func reserve(ctx context.Context, tx *sqlx.Tx, user auth.Claims, productID string) (*Product, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var p Product
+
+	const lock = `SELECT id, name, cost, quantity, user_id, org_id, date_created, date_updated, version
+	FROM products WHERE id = $1 AND ($2::UUID IS NULL OR org_id = $2)
+	AND date_deleted IS NULL FOR UPDATE`
+	if err := tx.GetContext(ctx, &p, lock, productID, user.OrgScope()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "locking product")
+	}
+
+	const q = `SELECT COALESCE(SUM(quantity),0) FROM net_sales WHERE product_id = $1`
+	if err := tx.GetContext(ctx, &p.Sold, q, productID); err != nil {
+		return nil, errors.Wrap(err, "counting sold units")
+	}
+
+	p.Available = p.Quantity - p.Sold
+
+	return &p, nil
+}
+
+// PublishSoldOut publishes the out of stock event of p when selling quantity
+// more units in tx used up its stock. p is the product as returned by
+// Reserve for the same transaction.
+func PublishSoldOut(ctx context.Context, tx *sqlx.Tx, p Product, quantity int, now time.Time) error {
+	if quantity <= 0 || quantity < p.Available {
+		return nil
+	}
+
+	p.Sold += quantity
+	p.Available -= quantity
+
+	// Every sale line bumped the version, read back where it ended up.
+	const q = `SELECT version FROM products WHERE id = $1`
+	if err := tx.GetContext(ctx, &p.Version, q, p.ID); err != nil {
+		return errors.Wrap(err, "reading product version")
+	}
+
+	return event.Publish(ctx, tx, p.OrgID, event.ProductOutOfStock, p, now)
 }
 
-func ListSales(ctx context.Context, db *sqlx.DB, productID string) ([]Sale, error) {
+// ListSales gives all sales of a product of the caller's organization.
+func ListSales(ctx context.Context, db *sqlx.DB, user auth.Claims, productID string) ([]Sale, error) {
 	list := []Sale{}
 
-	const q = `SELECT * FROM sales WHERE product_id = $1`
-	if err := db.SelectContext(ctx, &list, q, productID); err != nil {
+	const q = `SELECT s.sale_id, s.order_id, s.product_id, s.variant_id, s.warehouse_id, s.quantity, s.list_price, s.paid, s.date_created
+	FROM sales AS s
+	JOIN products AS p ON p.id = s.product_id
+	WHERE s.product_id = $1 AND ($2::UUID IS NULL OR p.org_id = $2)
+	ORDER BY s.date_created`
+	if err := db.SelectContext(ctx, &list, q, productID, user.OrgScope()); err != nil {
 		return nil, errors.Wrap(err, "selecting sales")
 	}
 	return list, nil
@@ -0,0 +1,166 @@
+package product
+
+import (
+	"context"
+	"html"
+	"sales_service/internal/platform/auth"
+	"strings"
+	"unicode"
+
+	"github.com/go-faster/errors"
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrEmptySearch is returned when a search query has no words to look for.
+var ErrEmptySearch = errors.New("search query has no words")
+
+// Search finds up to limit products of the caller's organization, archived
+// ones excepted, matching the words of query. Products whose name or tags
+// start with every word are found through the full text index and come
+// first; products whose name is only close to the query, as with typos, are
+// found through the trigram index and follow.
+func Search(ctx context.Context, db *sqlx.DB, user auth.Claims, query string, limit int) ([]SearchResult, error) {
+	words := searchWords(query)
+	if len(words) == 0 {
+		return nil, ErrEmptySearch
+	}
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	// Every word is matched as a prefix. The words only hold letters and
+	// digits, so they cannot change the meaning of the tsquery.
+	prefixes := make([]string, len(words))
+	for i, w := range words {
+		prefixes[i] = w + ":*"
+	}
+	tsq := strings.Join(prefixes, " & ")
+	term := strings.Join(words, " ")
+
+	// The matches are ranked before the sales aggregates are computed, so
+	// only the page of results is joined with the sales.
+	const q = `WITH matches AS (
+		SELECT p.id, p.search @@ to_tsquery('simple', $1) AS exact,
+			ts_rank(p.search, to_tsquery('simple', $1)) + word_similarity($2, p.name) AS rank
+		FROM products AS p
+		WHERE ($3::UUID IS NULL OR p.org_id = $3) AND p.date_deleted IS NULL
+		AND (p.search @@ to_tsquery('simple', $1) OR $2 <% p.name)
+		ORDER BY exact DESC, rank DESC, p.name, p.id
+		LIMIT $4
+	)
+	SELECT p.*, m.rank FROM (` + selectProducts + ` WHERE p.id IN (SELECT id FROM matches) ` + groupProducts + `) AS p
+	JOIN matches AS m ON m.id = p.id
+	ORDER BY m.exact DESC, m.rank DESC, p.name, p.id`
+
+	list := []SearchResult{}
+	if err := db.SelectContext(ctx, &list, q, tsq, term, user.OrgScope(), limit); err != nil {
+		return nil, errors.Wrap(err, "searching products")
+	}
+
+	for i := range list {
+		list[i].Highlight = highlight(list[i].Name, words)
+	}
+
+	return list, nil
+}
+
+// searchWords splits a query into lowercase words of letters and digits.
+// Everything else separates words.
+func searchWords(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// highlight escapes name for HTML and wraps each of its words matching one of
+// the query words in a <mark> element. A word matches when it starts with a
+// query word, or is within a typo or two of it.
+func highlight(name string, words []string) string {
+	var b strings.Builder
+
+	runes := []rune(name)
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+			j++
+		}
+
+		// Copy the separators up to the next word as they are.
+		if j == i {
+			for j < len(runes) && !unicode.IsLetter(runes[j]) && !unicode.IsDigit(runes[j]) {
+				j++
+			}
+			b.WriteString(html.EscapeString(string(runes[i:j])))
+			i = j
+			continue
+		}
+
+		word := string(runes[i:j])
+		if matchesAny(strings.ToLower(word), words) {
+			b.WriteString("<mark>" + html.EscapeString(word) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(word))
+		}
+		i = j
+	}
+
+	return b.String()
+}
+
+// matchesAny reports whether word starts with one of the query words or is
+// close enough to one of them to be a typo of it. Longer words allow more
+// typos; words shorter than four letters must match exactly.
+func matchesAny(word string, words []string) bool {
+	for _, w := range words {
+		if strings.HasPrefix(word, w) {
+			return true
+		}
+
+		n := len([]rune(w))
+		allowed := 0
+		switch {
+		case n >= 8:
+			allowed = 2
+		case n >= 4:
+			allowed = 1
+		}
+		if allowed > 0 && distance(word, w) <= allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// distance is the edit distance between a and b: the fewest single letter
+// insertions, deletions, substitutions and swaps of adjacent letters turning
+// one into the other.
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(ra)][len(rb)]
+}
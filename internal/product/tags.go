@@ -0,0 +1,47 @@
+package product
+
+import (
+	"context"
+	"sales_service/internal/platform/auth"
+	"sort"
+	"strings"
+
+	"github.com/go-faster/errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// normalizeTags trims and lowercases tags, drops the empty ones and the
+// duplicates, and sorts the rest so equal sets of tags compare equal.
+func normalizeTags(tags []string) pq.StringArray {
+	seen := make(map[string]bool, len(tags))
+	out := pq.StringArray{}
+
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+
+	sort.Strings(out)
+	return out
+}
+
+// ListTags gives every tag carried by the products of the caller's
+// organization that are not archived, with how many products carry it.
+func ListTags(ctx context.Context, db *sqlx.DB, user auth.Claims) ([]Tag, error) {
+	list := []Tag{}
+
+	const q = `SELECT t.tag, COUNT(*) AS products
+	FROM products AS p, unnest(p.tags) AS t(tag)
+	WHERE p.date_deleted IS NULL AND ($1::UUID IS NULL OR p.org_id = $1)
+	GROUP BY t.tag ORDER BY t.tag`
+	if err := db.SelectContext(ctx, &list, q, user.OrgScope()); err != nil {
+		return nil, errors.Wrap(err, "selecting tags")
+	}
+
+	return list, nil
+}
@@ -0,0 +1,408 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"sales_service/internal/audit"
+	"sales_service/internal/event"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/warehouse"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrVariantNotFound  = errors.New("variant not found")
+	ErrInvalidVariantID = errors.New("invalid variant ID format")
+
+	// ErrVariantRequired is returned when selling a product with variants
+	// without naming the variant sold.
+	ErrVariantRequired = errors.New("product is sold by variant, a variant must be given")
+
+	// ErrHasVariants is returned when setting the quantity of a product with
+	// variants, which is the sum of theirs.
+	ErrHasVariants = errors.New("quantity of a product with variants is set on its variants")
+
+	// ErrStocked is returned when setting the quantity of a product, or
+	// variant, stocked in warehouses, which follows its stock levels, or
+	// when adding variants to a product stocked without them.
+	ErrStocked = errors.New("product is stocked in warehouses, its quantity follows the stock levels")
+
+	// ErrVariantSold is returned when removing a variant that was sold. Its
+	// sales still count towards the product and keep referring to it, even
+	// once fully refunded.
+	ErrVariantSold = errors.New("variant has sales and cannot be removed")
+
+	// ErrVariantStocked is returned when removing a variant still stocked
+	// in warehouses.
+	ErrVariantStocked = errors.New("variant is stocked in warehouses and cannot be removed")
+)
+
+// selectVariants is the base query that joins variants with their sales to
+// compute the sold and revenue aggregates, like selectProducts does for
+// products. It must be followed by an optional WHERE clause on v and then
+// groupVariants.
+const selectVariants = `SELECT v.variant_id, v.product_id, COALESCE(v.sku, '') AS sku, v.attributes, v.cost, v.quantity,
+	COALESCE(SUM(s.paid),0) AS revenue,
+	COALESCE(SUM(s.quantity),0) AS sold,
+	v.quantity - COALESCE(SUM(s.quantity),0) AS available,
+	v.date_created, v.date_updated FROM product_variants AS v
+	LEFT JOIN net_sales AS s ON s.variant_id = v.variant_id`
+
+// groupVariants closes a selectVariants query.
+const groupVariants = `GROUP BY v.variant_id`
+
+// ListVariants gives the variants of a product of the caller's organization,
+// oldest first, with their sales totals.
+func ListVariants(ctx context.Context, db *sqlx.DB, user auth.Claims, productID string) ([]Variant, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	list, err := variants(ctx, db, user.OrgScope(), productID)
+	if err != nil {
+		return nil, err
+	}
+	if list == nil {
+		list = []Variant{}
+	}
+	return list, nil
+}
+
+// variants reads the variants of a product of the org organization, or of
+// any when org is nil, through db or a transaction. It gives nil when the
+// product has none.
+func variants(ctx context.Context, db sqlx.QueryerContext, org *string, productID string) ([]Variant, error) {
+	var list []Variant
+
+	const q = selectVariants + ` WHERE v.product_id = $1 AND ($2::UUID IS NULL OR v.org_id = $2) ` + groupVariants + `
+	ORDER BY v.date_created, v.variant_id`
+	if err := sqlx.SelectContext(ctx, db, &list, q, productID, org); err != nil {
+		return nil, errors.Wrap(err, "selecting variants")
+	}
+
+	return list, nil
+}
+
+// AddVariant adds a variant to a product of the caller's organization. The
+// quantity of the product becomes the sum of its variants, so adding the
+// first variant replaces the quantity the product had. Callers need the
+// product:write permission for their own products or for any product.
+func AddVariant(ctx context.Context, db *sqlx.DB, user auth.Claims, productID string, nv NewVariant, now time.Time) (*Variant, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	owner, err := lock(ctx, tx, user, productID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.Can(auth.PermProductWrite, owner) {
+		return nil, ErrForbidden
+	}
+
+	// Stock counted for the product as a whole cannot be split by variant.
+	has, err := hasVariants(ctx, tx, productID)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		stocked, err := warehouse.Stocked(ctx, tx, productID, "")
+		if err != nil {
+			return nil, err
+		}
+		if stocked {
+			return nil, ErrStocked
+		}
+	}
+
+	now = now.UTC().Truncate(time.Microsecond)
+
+	v := Variant{
+		ID:          uuid.New().String(),
+		ProductID:   productID,
+		SKU:         nv.SKU,
+		Attributes:  nv.Attributes,
+		Cost:        nv.Cost,
+		Quantity:    nv.Quantity,
+		Available:   nv.Quantity,
+		DateCreated: now,
+		DateUpdated: now,
+	}
+
+	const q = `INSERT INTO product_variants (variant_id, product_id, org_id, sku, attributes, cost, quantity, date_created, date_updated)
+	SELECT $1, id, org_id, NULLIF($3, ''), $4, $5, $6, $7, $8 FROM products WHERE id = $2`
+	if _, err := tx.ExecContext(ctx, q, v.ID, v.ProductID, v.SKU, v.Attributes, v.Cost, v.Quantity, v.DateCreated, v.DateUpdated); err != nil {
+		if dup := duplicateError(err); dup != nil {
+			return nil, dup
+		}
+		return nil, errors.Wrap(err, "inserting variant")
+	}
+
+	change := audit.Change{
+		Action:     audit.Create,
+		Resource:   audit.Variant,
+		ResourceID: v.ID,
+		After:      v,
+	}
+	if err := rollUp(ctx, tx, user, productID, change, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing variant")
+	}
+
+	return &v, nil
+}
+
+// ModifyVariant modifies a variant of a product of the caller's
+// organization. Callers need the product:write permission for their own
+// products or for any product.
+func ModifyVariant(ctx context.Context, db *sqlx.DB, user auth.Claims, productID, variantID string, upd UpdateVariant, now time.Time) (*Variant, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidID
+	}
+	if _, err := uuid.Parse(variantID); err != nil {
+		return nil, ErrInvalidVariantID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	owner, err := lock(ctx, tx, user, productID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.Can(auth.PermProductWrite, owner) {
+		return nil, ErrForbidden
+	}
+
+	before, err := retrieveVariant(ctx, tx, productID, variantID)
+	if err != nil {
+		return nil, err
+	}
+
+	v := *before
+	if upd.SKU != nil {
+		v.SKU = *upd.SKU
+	}
+	if upd.Attributes != nil {
+		v.Attributes = upd.Attributes
+	}
+	if upd.Cost != nil {
+		v.Cost = *upd.Cost
+	}
+	if upd.Quantity != nil && *upd.Quantity != v.Quantity {
+		stocked, err := warehouse.Stocked(ctx, tx, productID, variantID)
+		if err != nil {
+			return nil, err
+		}
+		if stocked {
+			return nil, ErrStocked
+		}
+		v.Quantity = *upd.Quantity
+		v.Available = v.Quantity - v.Sold
+	}
+	v.DateUpdated = now.UTC().Truncate(time.Microsecond)
+
+	const q = `UPDATE product_variants SET sku = NULLIF($1, ''), attributes = $2, cost = $3, quantity = $4, date_updated = $5
+	WHERE variant_id = $6`
+	if _, err := tx.ExecContext(ctx, q, v.SKU, v.Attributes, v.Cost, v.Quantity, v.DateUpdated, v.ID); err != nil {
+		if dup := duplicateError(err); dup != nil {
+			return nil, dup
+		}
+		return nil, errors.Wrap(err, "updating variant")
+	}
+
+	change := audit.Change{
+		Action:     audit.Update,
+		Resource:   audit.Variant,
+		ResourceID: v.ID,
+		Before:     before,
+		After:      v,
+	}
+	if err := rollUp(ctx, tx, user, productID, change, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing variant update")
+	}
+
+	return &v, nil
+}
+
+// RemoveVariant removes a variant that was never sold nor stocked in
+// warehouses from a product of the caller's organization. Sales are kept for
+// history, refunded or not, so a variant with any of them cannot be removed,
+// even when every unit was refunded; its quantity can be set to zero
+// instead. Callers need the product:write permission for their own products
+// or for any product.
+func RemoveVariant(ctx context.Context, db *sqlx.DB, user auth.Claims, productID, variantID string, now time.Time) error {
+	if _, err := uuid.Parse(productID); err != nil {
+		return ErrInvalidID
+	}
+	if _, err := uuid.Parse(variantID); err != nil {
+		return ErrInvalidVariantID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	owner, err := lock(ctx, tx, user, productID)
+	if err != nil {
+		return err
+	}
+	if !user.Can(auth.PermProductWrite, owner) {
+		return ErrForbidden
+	}
+
+	before, err := retrieveVariant(ctx, tx, productID, variantID)
+	if err != nil {
+		return err
+	}
+
+	var sold bool
+
+	// Refunds are not netted: the sales rows refer to the variant.
+	const q1 = `SELECT EXISTS (SELECT 1 FROM sales WHERE variant_id = $1)`
+	if err := tx.GetContext(ctx, &sold, q1, variantID); err != nil {
+		return errors.Wrap(err, "counting variant sales")
+	}
+	if sold {
+		return ErrVariantSold
+	}
+
+	stocked, err := warehouse.Stocked(ctx, tx, productID, variantID)
+	if err != nil {
+		return err
+	}
+	if stocked {
+		return ErrVariantStocked
+	}
+
+	const q2 = `DELETE FROM product_variants WHERE variant_id = $1`
+	if _, err := tx.ExecContext(ctx, q2, variantID); err != nil {
+		return errors.Wrap(err, "deleting variant")
+	}
+
+	change := audit.Change{
+		Action:     audit.Delete,
+		Resource:   audit.Variant,
+		ResourceID: variantID,
+		Before:     before,
+	}
+	if err := rollUp(ctx, tx, user, productID, change, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing variant delete")
+	}
+	return nil
+}
+
+// ReserveVariant is Reserve for a variant of a product: both rows are locked
+// and quantity more units of the variant must be available. Only the stock
+// of the variant is checked, a product with variants has no stock of its
+// own.
+func ReserveVariant(ctx context.Context, tx *sqlx.Tx, user auth.Claims, productID, variantID string, quantity int) (*Product, *Variant, error) {
+	if _, err := uuid.Parse(variantID); err != nil {
+		return nil, nil, ErrInvalidVariantID
+	}
+
+	p, err := reserve(ctx, tx, user, productID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	v, err := retrieveVariant(ctx, tx, productID, variantID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if quantity > v.Available {
+		return nil, nil, ErrInsufficientStock
+	}
+
+	return p, v, nil
+}
+
+// retrieveVariant reads a variant of a product, with its sales totals,
+// inside tx and locks its row until tx ends. The totals are summed in a
+// lateral subquery since a grouped query cannot lock rows.
+func retrieveVariant(ctx context.Context, tx *sqlx.Tx, productID, variantID string) (*Variant, error) {
+	var v Variant
+
+	const q = `SELECT v.variant_id, v.product_id, COALESCE(v.sku, '') AS sku, v.attributes, v.cost, v.quantity,
+	COALESCE(s.revenue, 0) AS revenue,
+	COALESCE(s.sold, 0) AS sold,
+	v.quantity - COALESCE(s.sold, 0) AS available,
+	v.date_created, v.date_updated FROM product_variants AS v
+	LEFT JOIN LATERAL (
+		SELECT SUM(paid) AS revenue, SUM(quantity) AS sold FROM net_sales WHERE variant_id = v.variant_id
+	) AS s ON true
+	WHERE v.variant_id = $1 AND v.product_id = $2
+	FOR UPDATE OF v`
+	if err := tx.GetContext(ctx, &v, q, variantID, productID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrVariantNotFound
+		}
+		return nil, errors.Wrap(err, "locking variant")
+	}
+
+	return &v, nil
+}
+
+// hasVariants reports whether a product has any variant.
+func hasVariants(ctx context.Context, tx *sqlx.Tx, productID string) (bool, error) {
+	var exists bool
+
+	const q = `SELECT EXISTS (SELECT 1 FROM product_variants WHERE product_id = $1)`
+	if err := tx.GetContext(ctx, &exists, q, productID); err != nil {
+		return false, errors.Wrap(err, "selecting variants")
+	}
+	return exists, nil
+}
+
+// rollUp follows a change to the variants of a product locked in tx: the
+// quantity of the product becomes the sum of its variants and its version
+// is bumped, since the product is read with its variants. The change is
+// recorded in the audit log and the product published as updated.
+func rollUp(ctx context.Context, tx *sqlx.Tx, user auth.Claims, productID string, change audit.Change, now time.Time) error {
+	const q = `UPDATE products SET
+	quantity = (SELECT COALESCE(SUM(quantity), 0) FROM product_variants WHERE product_id = $1),
+	version = version + 1, date_updated = $2
+	WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, q, productID, now.UTC()); err != nil {
+		return errors.Wrap(err, "rolling up variants")
+	}
+
+	p, err := retrieve(ctx, tx, nil, productID, false)
+	if err != nil {
+		return err
+	}
+
+	change.OrgID = p.OrgID
+	if err := audit.Record(ctx, tx, user, change, now); err != nil {
+		return err
+	}
+
+	return event.Publish(ctx, tx, p.OrgID, event.ProductUpdated, p, now)
+}
@@ -0,0 +1,122 @@
+package report
+
+import (
+	"strconv"
+	"time"
+)
+
+// Period holds the sales totals of one time bucket. Discount is how much
+// less than the list price the sales were paid.
+type Period struct {
+	Start    time.Time `db:"period" json:"period"`
+	Revenue  int       `db:"revenue" json:"revenue"`
+	Discount int       `db:"discount" json:"discount"`
+	Units    int       `db:"units" json:"units"`
+}
+
+// Periods is a revenue-over-time report.
+type Periods []Period
+
+// CSV returns the report as CSV records, starting with a header.
+func (ps Periods) CSV() [][]string {
+	records := [][]string{{"period", "revenue", "discount", "units"}}
+	for _, p := range ps {
+		records = append(records, []string{
+			p.Start.Format(time.RFC3339),
+			strconv.Itoa(p.Revenue),
+			strconv.Itoa(p.Discount),
+			strconv.Itoa(p.Units),
+		})
+	}
+	return records
+}
+
+// ProductTotal holds the sales totals of a single product.
+type ProductTotal struct {
+	ProductID string `db:"product_id" json:"product_id"`
+	Name      string `db:"name" json:"name"`
+	Revenue   int    `db:"revenue" json:"revenue"`
+	Discount  int    `db:"discount" json:"discount"`
+	Units     int    `db:"units" json:"units"`
+}
+
+// ProductTotals is a top products report.
+type ProductTotals []ProductTotal
+
+// CSV returns the report as CSV records, starting with a header.
+func (ps ProductTotals) CSV() [][]string {
+	records := [][]string{{"product_id", "name", "revenue", "discount", "units"}}
+	for _, p := range ps {
+		records = append(records, []string{
+			p.ProductID,
+			p.Name,
+			strconv.Itoa(p.Revenue),
+			strconv.Itoa(p.Discount),
+			strconv.Itoa(p.Units),
+		})
+	}
+	return records
+}
+
+// OwnerTotal holds the sales totals of all products owned by one user.
+type OwnerTotal struct {
+	UserID   string `db:"user_id" json:"user_id"`
+	Name     string `db:"name" json:"name"`
+	Email    string `db:"email" json:"email"`
+	Revenue  int    `db:"revenue" json:"revenue"`
+	Discount int    `db:"discount" json:"discount"`
+	Units    int    `db:"units" json:"units"`
+}
+
+// OwnerTotals is a revenue per product owner report.
+type OwnerTotals []OwnerTotal
+
+// CSV returns the report as CSV records, starting with a header.
+func (os OwnerTotals) CSV() [][]string {
+	records := [][]string{{"user_id", "name", "email", "revenue", "discount", "units"}}
+	for _, o := range os {
+		records = append(records, []string{
+			o.UserID,
+			o.Name,
+			o.Email,
+			strconv.Itoa(o.Revenue),
+			strconv.Itoa(o.Discount),
+			strconv.Itoa(o.Units),
+		})
+	}
+	return records
+}
+
+// CategoryTotal holds the sales totals of the products of a category and of
+// all of its subcategories.
+type CategoryTotal struct {
+	CategoryID string  `db:"category_id" json:"category_id"`
+	ParentID   *string `db:"parent_id" json:"parent_id"`
+	Name       string  `db:"name" json:"name"`
+	Revenue    int     `db:"revenue" json:"revenue"`
+	Discount   int     `db:"discount" json:"discount"`
+	Units      int     `db:"units" json:"units"`
+}
+
+// CategoryTotals is a revenue per category report.
+type CategoryTotals []CategoryTotal
+
+// CSV returns the report as CSV records, starting with a header.
+func (cs CategoryTotals) CSV() [][]string {
+	records := [][]string{{"category_id", "parent_id", "name", "revenue", "discount", "units"}}
+	for _, c := range cs {
+		var parent string
+		if c.ParentID != nil {
+			parent = *c.ParentID
+		}
+		records = append(records, []string{
+			c.CategoryID,
+			parent,
+			c.Name,
+			strconv.Itoa(c.Revenue),
+			strconv.Itoa(c.Discount),
+			strconv.Itoa(c.Units),
+		})
+	}
+	return records
+}
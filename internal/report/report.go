@@ -0,0 +1,187 @@
+package report
+
+import (
+	"context"
+	"sales_service/internal/platform/auth"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrInvalidRange  = errors.New("from must be before to")
+	ErrInvalidBucket = errors.New("bucket must be day, week or month")
+	ErrInvalidMetric = errors.New("metric must be revenue or units")
+)
+
+// Buckets a revenue-over-time report can be grouped by.
+const (
+	Day   = "day"
+	Week  = "week"
+	Month = "month"
+)
+
+// Metrics products can be ranked by.
+const (
+	Revenue = "revenue"
+	Units   = "units"
+)
+
+// salesJoin is the sales and products join used by product.List, read net of
+// refunds.
+const salesJoin = `FROM net_sales AS s
+	JOIN products AS p ON p.id = s.product_id`
+
+// inRange filters salesJoin by the sale date. Every report passes the range
+// as $1 and $2, and follows it with a condition limiting the products to the
+// caller's organization.
+const inRange = `WHERE s.date_created >= $1 AND s.date_created < $2`
+
+// discount sums how much less than the list price the sales of a group were
+// paid.
+const discount = `SUM(s.list_price * s.quantity - s.paid) AS discount`
+
+// RevenueOverTime sums revenue and units sold per bucket between from and to.
+// Buckets are aligned to midnight in loc and every bucket of the range is
+// present, including those without sales.
+func RevenueOverTime(ctx context.Context, db *sqlx.DB, user auth.Claims, from, to time.Time, bucket string, loc *time.Location) (Periods, error) {
+	if !from.Before(to) {
+		return nil, ErrInvalidRange
+	}
+	switch bucket {
+	case Day, Week, Month:
+	default:
+		return nil, ErrInvalidBucket
+	}
+
+	// Sales are stored in UTC. They are shifted to the wall clock of loc
+	// before truncating so that days start at local midnight.
+	const q = `WITH buckets AS (
+		SELECT generate_series(
+			date_trunc($3, $5::timestamp),
+			date_trunc($3, $6::timestamp - interval '1 microsecond'),
+			('1 ' || $3)::interval
+		) AS period
+	), totals AS (
+		SELECT date_trunc($3, (s.date_created AT TIME ZONE 'UTC') AT TIME ZONE $4) AS period,
+			SUM(s.paid) AS revenue, ` + discount + `, SUM(s.quantity) AS units
+		` + salesJoin + `
+		` + inRange + ` AND ($7::UUID IS NULL OR p.org_id = $7)
+		GROUP BY 1
+	)
+	SELECT b.period, COALESCE(t.revenue, 0) AS revenue, COALESCE(t.discount, 0) AS discount,
+		COALESCE(t.units, 0) AS units
+	FROM buckets AS b
+	LEFT JOIN totals AS t ON t.period = b.period
+	ORDER BY b.period`
+
+	const wall = "2006-01-02 15:04:05.999999"
+
+	list := Periods{}
+	err := db.SelectContext(ctx, &list, q,
+		from.UTC(), to.UTC(), bucket, loc.String(),
+		from.In(loc).Format(wall), to.In(loc).Format(wall), user.OrgScope(),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "selecting revenue over time")
+	}
+
+	// The database returns local wall times; attach the location to them.
+	for i, p := range list {
+		s := p.Start
+		list[i].Start = time.Date(s.Year(), s.Month(), s.Day(), s.Hour(), s.Minute(), s.Second(), s.Nanosecond(), loc)
+	}
+
+	return list, nil
+}
+
+// TopProducts ranks the n best selling products between from and to by
+// revenue or by units sold.
+func TopProducts(ctx context.Context, db *sqlx.DB, user auth.Claims, from, to time.Time, metric string, n int) (ProductTotals, error) {
+	if !from.Before(to) {
+		return nil, ErrInvalidRange
+	}
+
+	// The metric is interpolated into the query, so only allow known values.
+	switch metric {
+	case Revenue, Units:
+	default:
+		return nil, ErrInvalidMetric
+	}
+
+	q := `SELECT p.id AS product_id, p.name,
+		SUM(s.paid) AS revenue, ` + discount + `, SUM(s.quantity) AS units
+	` + salesJoin + `
+	` + inRange + ` AND ($4::UUID IS NULL OR p.org_id = $4)
+	GROUP BY p.id
+	ORDER BY ` + metric + ` DESC, p.id
+	LIMIT $3`
+
+	list := ProductTotals{}
+	if err := db.SelectContext(ctx, &list, q, from.UTC(), to.UTC(), n, user.OrgScope()); err != nil {
+		return nil, errors.Wrap(err, "selecting top products")
+	}
+
+	return list, nil
+}
+
+// RevenueByOwner sums revenue and units sold between from and to per user
+// owning the products.
+func RevenueByOwner(ctx context.Context, db *sqlx.DB, user auth.Claims, from, to time.Time) (OwnerTotals, error) {
+	if !from.Before(to) {
+		return nil, ErrInvalidRange
+	}
+
+	const q = `SELECT p.user_id, COALESCE(u.name, '') AS name, COALESCE(u.email, '') AS email,
+		SUM(s.paid) AS revenue, ` + discount + `, SUM(s.quantity) AS units
+	` + salesJoin + `
+	LEFT JOIN users AS u ON u.user_id = p.user_id
+	` + inRange + ` AND ($3::UUID IS NULL OR p.org_id = $3)
+	GROUP BY p.user_id, u.name, u.email
+	ORDER BY revenue DESC, p.user_id`
+
+	list := OwnerTotals{}
+	if err := db.SelectContext(ctx, &list, q, from.UTC(), to.UTC(), user.OrgScope()); err != nil {
+		return nil, errors.Wrap(err, "selecting revenue by owner")
+	}
+
+	return list, nil
+}
+
+// RevenueByCategory sums revenue and units sold between from and to per
+// category. The totals of a category include those of its subcategories at
+// any depth, so a product counts towards every category above it. Every
+// category is present, including those without sales.
+func RevenueByCategory(ctx context.Context, db *sqlx.DB, user auth.Claims, from, to time.Time) (CategoryTotals, error) {
+	if !from.Before(to) {
+		return nil, ErrInvalidRange
+	}
+
+	// The tree pairs every category with itself and each of its
+	// descendants. UNION keeps it finite should the tree ever hold a cycle.
+	const q = `WITH RECURSIVE tree AS (
+		SELECT category_id AS root, category_id FROM categories
+		WHERE ($3::UUID IS NULL OR org_id = $3)
+		UNION
+		SELECT t.root, c.category_id FROM categories AS c JOIN tree AS t ON c.parent_id = t.category_id
+	)
+	SELECT c.category_id, c.parent_id, c.name,
+		COALESCE(SUM(s.paid), 0) AS revenue,
+		COALESCE(SUM(s.list_price * s.quantity - s.paid), 0) AS discount,
+		COALESCE(SUM(s.quantity), 0) AS units
+	FROM tree AS t
+	JOIN categories AS c ON c.category_id = t.root
+	LEFT JOIN products AS p ON p.category_id = t.category_id
+	LEFT JOIN net_sales AS s ON s.product_id = p.id
+		AND s.date_created >= $1 AND s.date_created < $2
+	GROUP BY c.category_id
+	ORDER BY revenue DESC, c.name, c.category_id`
+
+	list := CategoryTotals{}
+	if err := db.SelectContext(ctx, &list, q, from.UTC(), to.UTC(), user.OrgScope()); err != nil {
+		return nil, errors.Wrap(err, "selecting revenue by category")
+	}
+
+	return list, nil
+}
@@ -0,0 +1,176 @@
+package report
+
+import (
+	"context"
+	"sales_service/internal/organization"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/database/databasetest"
+	"sales_service/internal/product"
+	"sales_service/internal/schema"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	lego  = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a21"
+	chima = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"
+)
+
+// sell records a sale of quantity units listed at listPrice each, made at
+// the given time, and refunds refunded of those units in full.
+func sell(t *testing.T, db *sqlx.DB, productID string, quantity, listPrice, paid int, at time.Time, refunded int) {
+	t.Helper()
+
+	orderID, saleID := uuid.New().String(), uuid.New().String()
+
+	const q1 = `INSERT INTO orders (order_id, date_created) VALUES ($1, $2)`
+	if _, err := db.Exec(q1, orderID, at.UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	const q2 = `INSERT INTO sales (sale_id, order_id, product_id, quantity, list_price, paid, date_created)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	if _, err := db.Exec(q2, saleID, orderID, productID, quantity, listPrice, paid, at.UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	if refunded == 0 {
+		return
+	}
+
+	const q3 = `INSERT INTO refunds (refund_id, sale_id, quantity, amount, date_created) VALUES ($1, $2, $3, $4, $5)`
+	if _, err := db.Exec(q3, uuid.New().String(), saleID, refunded, refunded*paid/quantity, at.UTC()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReports(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+
+	if err := schema.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+
+	store, err := organization.Create(ctx, db, organization.NewOrganization{Name: "Second store"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a04", store.ID, []string{auth.RoleAdmin}, now, time.Hour)
+	other.Permissions = []string{auth.Any(auth.PermProductWrite)}
+
+	duplo, err := product.Create(ctx, db, other, product.NewProduct{Name: "Duplo", Cost: 1000, Quantity: 5}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// New York moved its clocks forward on 2024-03-10, which makes that day
+	// 23 hours long: from 05:00 UTC to 04:00 UTC the next day.
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sell(t, db, lego, 1, 3000, 3000, time.Date(2024, 3, 10, 4, 59, 0, 0, time.UTC), 0)  // 9th, 23:59 EST
+	sell(t, db, lego, 2, 3000, 5000, time.Date(2024, 3, 10, 5, 0, 0, 0, time.UTC), 0)   // 10th, 00:00 EST
+	sell(t, db, lego, 2, 3000, 6000, time.Date(2024, 3, 11, 3, 59, 0, 0, time.UTC), 1)  // 10th, 23:59 EDT
+	sell(t, db, lego, 1, 3000, 3000, time.Date(2024, 3, 11, 4, 0, 0, 0, time.UTC), 0)   // 11th, 00:00 EDT
+	sell(t, db, chima, 6, 2000, 12000, time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC), 0) // 9th
+	sell(t, db, duplo.ID, 1, 1000, 1000, time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC), 0)
+
+	from := time.Date(2024, 3, 8, 0, 0, 0, 0, ny)
+	to := time.Date(2024, 3, 13, 0, 0, 0, 0, ny)
+
+	t.Run("RevenueOverTime", func(t *testing.T) {
+		got, err := RevenueOverTime(ctx, db, claims, from, to, Day, ny)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Days without sales are present, and the refunded unit of the sale
+		// late on the 10th is taken off its revenue.
+		day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, ny) }
+		want := Periods{
+			{Start: day(8)},
+			{Start: day(9), Revenue: 15000, Units: 7},
+			{Start: day(10), Revenue: 8000, Discount: 1000, Units: 3},
+			{Start: day(11), Revenue: 3000, Units: 1},
+			{Start: day(12)},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("mismatch (-want +got):\n%s", diff)
+		}
+
+		got, err = RevenueOverTime(ctx, db, other, from, to, Week, ny)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = Periods{
+			{Start: time.Date(2024, 3, 4, 0, 0, 0, 0, ny)},
+			{Start: time.Date(2024, 3, 11, 0, 0, 0, 0, ny)},
+		}
+		want[0].Revenue, want[0].Units = 1000, 1
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("TopProducts", func(t *testing.T) {
+		byRevenue, err := TopProducts(ctx, db, claims, from, to, Revenue, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := ProductTotals{
+			{ProductID: lego, Name: "Lego City", Revenue: 14000, Discount: 1000, Units: 5},
+			{ProductID: chima, Name: "Lego Chima", Revenue: 12000, Units: 6},
+		}
+		if diff := cmp.Diff(want, byRevenue); diff != "" {
+			t.Fatalf("mismatch (-want +got):\n%s", diff)
+		}
+
+		byUnits, err := TopProducts(ctx, db, claims, from, to, Units, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want[1:], byUnits); diff != "" {
+			t.Fatalf("mismatch (-want +got):\n%s", diff)
+		}
+
+		if _, err := TopProducts(ctx, db, claims, from, to, "name", 10); err != ErrInvalidMetric {
+			t.Fatalf("expected ErrInvalidMetric, got %v", err)
+		}
+	})
+
+	t.Run("RevenueByOwner", func(t *testing.T) {
+		got, err := RevenueByOwner(ctx, db, claims, from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := OwnerTotals{
+			{UserID: "00000000-0000-0000-0000-000000000000", Revenue: 26000, Discount: 1000, Units: 11},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("mismatch (-want +got):\n%s", diff)
+		}
+
+		got, err = RevenueByOwner(ctx, db, other, from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = OwnerTotals{
+			{UserID: "a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a04", Name: "User", Email: "user1@mail.ru", Revenue: 1000, Units: 1},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
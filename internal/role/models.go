@@ -0,0 +1,36 @@
+package role
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Role is a named set of permissions that can be given to users.
+type Role struct {
+	Name        string         `db:"name" json:"name"`
+	Description string         `db:"description" json:"description"`
+	Permissions pq.StringArray `db:"permissions" json:"permissions"`
+	DateCreated time.Time      `db:"date_created" json:"date_created"`
+	DateUpdated time.Time      `db:"date_updated" json:"date_updated"`
+}
+
+// Permission is an action a role can allow.
+type Permission struct {
+	Name        string `db:"name" json:"name"`
+	Description string `db:"description" json:"description"`
+}
+
+// NewRole is what we require from clients when adding a role.
+type NewRole struct {
+	Name        string   `json:"name" validate:"required"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions" validate:"dive,required"`
+}
+
+// UpdateRole defines what information may be provided to modify an existing
+// role. All fields are optional. Permissions replace the current ones.
+type UpdateRole struct {
+	Description *string  `json:"description"`
+	Permissions []string `json:"permissions" validate:"omitempty,dive,required"`
+}
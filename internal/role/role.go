@@ -0,0 +1,277 @@
+package role
+
+import (
+	"context"
+	"database/sql"
+	"sales_service/internal/platform/auth"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrNotFound          = errors.New("role not found")
+	ErrExists            = errors.New("role already exists")
+	ErrUnknownRole       = errors.New("unknown role")
+	ErrUnknownPermission = errors.New("unknown permission")
+	ErrInUse             = errors.New("role is still given to users or api keys")
+
+	// ErrBuiltIn is returned when changing the admin roles, so the service
+	// always keeps a role that can manage the others.
+	ErrBuiltIn = errors.New("the admin roles cannot be changed")
+)
+
+// selectRoles reads roles with their permissions aggregated into an array.
+const selectRoles = `SELECT r.name, r.description, r.date_created, r.date_updated,
+	COALESCE(ARRAY_AGG(rp.permission ORDER BY rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}') AS permissions
+	FROM roles AS r
+	LEFT JOIN role_permissions AS rp ON rp.role = r.name`
+
+// PermissionsFor returns the permissions granted by any of the roles.
+func PermissionsFor(ctx context.Context, db sqlx.QueryerContext, roles []string) ([]string, error) {
+	perms := []string{}
+
+	const q = `SELECT DISTINCT permission FROM role_permissions WHERE role = ANY($1) ORDER BY permission`
+	if err := sqlx.SelectContext(ctx, db, &perms, q, pq.Array(roles)); err != nil {
+		return nil, errors.Wrap(err, "selecting permissions")
+	}
+
+	return perms, nil
+}
+
+// Within reports whether roles grant nothing beyond what claims hold: every
+// permission of the roles must be held by claims, and only super-admins are
+// within the super-admin role. Callers use it before giving roles away so
+// nobody can raise anyone, or anything, above their own rights.
+func Within(ctx context.Context, db sqlx.QueryerContext, claims auth.Claims, roles []string) (bool, error) {
+	if claims.HasRole(auth.RoleSuperAdmin) {
+		return true, nil
+	}
+	for _, r := range roles {
+		if r == auth.RoleSuperAdmin {
+			return false, nil
+		}
+	}
+
+	perms, err := PermissionsFor(ctx, db, roles)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range perms {
+		if !claims.HasPermission(p) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// CheckExist returns ErrUnknownRole unless every one of roles exists.
+func CheckExist(ctx context.Context, db *sqlx.DB, roles []string) error {
+	var n int
+
+	const q = `SELECT COUNT(*) FROM roles WHERE name = ANY($1)`
+	if err := db.GetContext(ctx, &n, q, pq.Array(roles)); err != nil {
+		return errors.Wrap(err, "counting roles")
+	}
+
+	if n != len(unique(roles)) {
+		return ErrUnknownRole
+	}
+	return nil
+}
+
+// ListPermissions gives every permission a role can be granted.
+func ListPermissions(ctx context.Context, db *sqlx.DB) ([]Permission, error) {
+	perms := []Permission{}
+
+	const q = `SELECT name, description FROM permissions ORDER BY name`
+	if err := db.SelectContext(ctx, &perms, q); err != nil {
+		return nil, errors.Wrap(err, "selecting permissions")
+	}
+
+	return perms, nil
+}
+
+// List gives every role with its permissions.
+func List(ctx context.Context, db *sqlx.DB) ([]Role, error) {
+	roles := []Role{}
+
+	const q = selectRoles + ` GROUP BY r.name ORDER BY r.name`
+	if err := db.SelectContext(ctx, &roles, q); err != nil {
+		return nil, errors.Wrap(err, "selecting roles")
+	}
+
+	return roles, nil
+}
+
+// Retrieve gets a single role with its permissions.
+func Retrieve(ctx context.Context, db *sqlx.DB, name string) (*Role, error) {
+	var r Role
+
+	const q = selectRoles + ` WHERE r.name = $1 GROUP BY r.name`
+	if err := db.GetContext(ctx, &r, q, name); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting role %q", name)
+	}
+
+	return &r, nil
+}
+
+// Create adds a role granting the given permissions.
+func Create(ctx context.Context, db *sqlx.DB, nr NewRole, now time.Time) (*Role, error) {
+	now = now.UTC().Truncate(time.Microsecond)
+
+	r := Role{
+		Name:        nr.Name,
+		Description: nr.Description,
+		Permissions: unique(nr.Permissions),
+		DateCreated: now,
+		DateUpdated: now,
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	const q = `INSERT INTO roles (name, description, date_created, date_updated)
+	VALUES ($1, $2, $3, $4) ON CONFLICT (name) DO NOTHING`
+
+	res, err := tx.ExecContext(ctx, q, r.Name, r.Description, r.DateCreated, r.DateUpdated)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting role")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, ErrExists
+	}
+
+	if err := grant(ctx, tx, r.Name, r.Permissions); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing role")
+	}
+
+	return &r, nil
+}
+
+// Update changes the description of a role or replaces its permissions.
+func Update(ctx context.Context, db *sqlx.DB, name string, upd UpdateRole, now time.Time) error {
+	if isBuiltIn(name) {
+		return ErrBuiltIn
+	}
+
+	r, err := Retrieve(ctx, db, name)
+	if err != nil {
+		return err
+	}
+
+	if upd.Description != nil {
+		r.Description = *upd.Description
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	const q = `UPDATE roles SET description = $1, date_updated = $2 WHERE name = $3`
+	if _, err := tx.ExecContext(ctx, q, r.Description, now.UTC(), name); err != nil {
+		return errors.Wrap(err, "updating role")
+	}
+
+	if upd.Permissions != nil {
+		const del = `DELETE FROM role_permissions WHERE role = $1`
+		if _, err := tx.ExecContext(ctx, del, name); err != nil {
+			return errors.Wrap(err, "removing role permissions")
+		}
+		if err := grant(ctx, tx, name, unique(upd.Permissions)); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing role")
+	}
+
+	return nil
+}
+
+// Delete removes a role. Roles still given to a user or an API key cannot be
+// removed.
+func Delete(ctx context.Context, db *sqlx.DB, name string) error {
+	if isBuiltIn(name) {
+		return ErrBuiltIn
+	}
+
+	var inUse bool
+
+	const used = `SELECT EXISTS (SELECT 1 FROM users WHERE $1 = ANY(roles))
+	OR EXISTS (SELECT 1 FROM api_keys WHERE $1 = ANY(roles) AND date_revoked IS NULL)`
+	if err := db.GetContext(ctx, &inUse, used, name); err != nil {
+		return errors.Wrap(err, "checking role use")
+	}
+	if inUse {
+		return ErrInUse
+	}
+
+	const q = `DELETE FROM roles WHERE name = $1`
+	res, err := db.ExecContext(ctx, q, name)
+	if err != nil {
+		return errors.Wrap(err, "deleting role")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// grant gives the role each of perms inside tx.
+func grant(ctx context.Context, tx *sqlx.Tx, name string, perms []string) error {
+	if len(perms) == 0 {
+		return nil
+	}
+
+	var n int
+
+	const known = `SELECT COUNT(*) FROM permissions WHERE name = ANY($1)`
+	if err := tx.GetContext(ctx, &n, known, pq.Array(perms)); err != nil {
+		return errors.Wrap(err, "counting permissions")
+	}
+	if n != len(perms) {
+		return ErrUnknownPermission
+	}
+
+	const q = `INSERT INTO role_permissions (role, permission) SELECT $1, UNNEST($2::TEXT[])`
+	if _, err := tx.ExecContext(ctx, q, name, pq.Array(perms)); err != nil {
+		return errors.Wrap(err, "granting permissions")
+	}
+
+	return nil
+}
+
+// isBuiltIn reports whether name is one of the admin roles.
+func isBuiltIn(name string) bool {
+	return name == auth.RoleAdmin || name == auth.RoleSuperAdmin
+}
+
+// unique returns list without duplicates, keeping the first occurrence.
+func unique(list []string) []string {
+	seen := make(map[string]bool, len(list))
+	out := []string{}
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package role
+
+import (
+	"context"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/database/databasetest"
+	"testing"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRoles(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	nr := NewRole{
+		Name:        "CASHIER",
+		Description: "Records sales",
+		Permissions: []string{auth.PermSaleCreate, auth.PermProductRead},
+	}
+	if _, err := Create(ctx, db, nr, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Create(ctx, db, nr, now); !errors.Is(err, ErrExists) {
+		t.Fatalf("expected ErrExists, got %v", err)
+	}
+
+	perms, err := PermissionsFor(ctx, db, []string{"CASHIER"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{auth.PermProductRead, auth.PermSaleCreate}, perms); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+
+	upd := UpdateRole{Permissions: []string{auth.PermSaleCreate, auth.PermSaleRefund}}
+	if err := Update(ctx, db, "CASHIER", upd, now); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Retrieve(ctx, db, "CASHIER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{auth.PermSaleCreate, auth.PermSaleRefund}, []string(got.Permissions)); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := Update(ctx, db, "CASHIER", UpdateRole{Permissions: []string{"sale:steal"}}, now); !errors.Is(err, ErrUnknownPermission) {
+		t.Fatalf("expected ErrUnknownPermission, got %v", err)
+	}
+	if err := Update(ctx, db, auth.RoleAdmin, upd, now); !errors.Is(err, ErrBuiltIn) {
+		t.Fatalf("expected ErrBuiltIn, got %v", err)
+	}
+
+	if err := CheckExist(ctx, db, []string{"CASHIER", auth.RoleUser}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Delete(ctx, db, "CASHIER"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckExist(ctx, db, []string{"CASHIER"}); !errors.Is(err, ErrUnknownRole) {
+		t.Fatalf("expected ErrUnknownRole, got %v", err)
+	}
+}
@@ -61,6 +61,566 @@ var migrations = []darwin.Migration{
 		ADD COLUMN user_id UUID DEFAULT '00000000-0000-0000-0000-000000000000'
 		`,
 	},
+	{
+		Version:     5,
+		Description: "Add orders and move sales into them",
+		Script: `
+	CREATE TABLE orders (
+		order_id	UUID,
+		user_id	UUID DEFAULT '00000000-0000-0000-0000-000000000000',
+		date_created	TIMESTAMP,
+
+		PRIMARY KEY (order_id)
+	);
+
+	ALTER TABLE sales
+		ADD COLUMN order_id UUID REFERENCES orders(order_id) ON DELETE CASCADE;
+
+	INSERT INTO orders (order_id, date_created)
+		SELECT sale_id, date_created FROM sales;
+
+	UPDATE sales SET order_id = sale_id;
+
+	ALTER TABLE sales
+		ALTER COLUMN order_id SET NOT NULL;
+
+	CREATE INDEX sales_order_id_idx ON sales (order_id);
+	CREATE INDEX sales_product_id_idx ON sales (product_id);`,
+	},
+	{
+		Version:     6,
+		Description: "Add refunds and net sales view",
+		Script: `
+	CREATE TABLE refunds (
+		refund_id	UUID,
+		sale_id	UUID NOT NULL REFERENCES sales(sale_id) ON DELETE CASCADE,
+		quantity	INT,
+		amount	INT,
+		reason	TEXT,
+		user_id	UUID,
+		date_created	TIMESTAMP,
+
+		PRIMARY KEY (refund_id)
+	);
+
+	CREATE INDEX refunds_sale_id_idx ON refunds (sale_id);
+
+	CREATE VIEW net_sales AS
+		SELECT s.sale_id, s.order_id, s.product_id,
+			s.quantity - COALESCE(r.quantity, 0) AS quantity,
+			s.paid - COALESCE(r.amount, 0) AS paid,
+			s.date_created
+		FROM sales AS s
+		LEFT JOIN (
+			SELECT sale_id, SUM(quantity) AS quantity, SUM(amount) AS amount
+			FROM refunds GROUP BY sale_id
+		) AS r ON r.sale_id = s.sale_id;`,
+	},
+	{
+		Version:     7,
+		Description: "Add refresh tokens and revoked access tokens",
+		Script: `
+	CREATE TABLE refresh_tokens (
+		token_id	UUID,
+		family_id	UUID NOT NULL,
+		user_id	UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+		token_hash	TEXT NOT NULL UNIQUE,
+		date_created	TIMESTAMP,
+		date_expires	TIMESTAMP,
+		date_used	TIMESTAMP,
+		date_revoked	TIMESTAMP,
+
+		PRIMARY KEY (token_id)
+	);
+
+	CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+	CREATE TABLE revoked_tokens (
+		jti	TEXT,
+		date_expires	TIMESTAMP,
+
+		PRIMARY KEY (jti)
+	);`,
+	},
+	{
+		Version:     8,
+		Description: "Add API keys",
+		Script: `
+	CREATE TABLE api_keys (
+		key_id	UUID,
+		user_id	UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+		name	TEXT,
+		prefix	TEXT,
+		key_hash	TEXT NOT NULL UNIQUE,
+		roles	TEXT[],
+		date_created	TIMESTAMP,
+		date_last_used	TIMESTAMP,
+		date_revoked	TIMESTAMP,
+
+		PRIMARY KEY (key_id)
+	);
+
+	CREATE INDEX api_keys_user_id_idx ON api_keys (user_id);`,
+	},
+	{
+		Version:     9,
+		Description: "Add roles and permissions",
+		Script: `
+	CREATE TABLE roles (
+		name	TEXT,
+		description	TEXT,
+		date_created	TIMESTAMP,
+		date_updated	TIMESTAMP,
+
+		PRIMARY KEY (name)
+	);
+
+	CREATE TABLE permissions (
+		name	TEXT,
+		description	TEXT,
+
+		PRIMARY KEY (name)
+	);
+
+	CREATE TABLE role_permissions (
+		role	TEXT REFERENCES roles(name) ON DELETE CASCADE,
+		permission	TEXT REFERENCES permissions(name) ON DELETE CASCADE,
+
+		PRIMARY KEY (role, permission)
+	);
+
+	INSERT INTO permissions (name, description) VALUES
+		('product:read', 'List and view products'),
+		('product:create', 'Create products'),
+		('product:write', 'Update own products'),
+		('product:write:any', 'Update any product'),
+		('product:delete', 'Delete own products'),
+		('product:delete:any', 'Delete any product'),
+		('sale:read', 'View sales and refunds'),
+		('sale:create', 'Record sales'),
+		('sale:refund', 'Refund sales'),
+		('order:read', 'View own orders'),
+		('order:read:any', 'View any order'),
+		('order:create', 'Create orders'),
+		('report:read', 'View sales reports'),
+		('user:read', 'View own profile'),
+		('user:read:any', 'List and view any user'),
+		('user:create', 'Create users'),
+		('user:write', 'Update own profile'),
+		('user:write:any', 'Update any user'),
+		('user:delete', 'Delete users'),
+		('user:roles', 'Change the roles of users'),
+		('apikey:manage', 'Manage own API keys'),
+		('apikey:manage:any', 'Manage the API keys of any user'),
+		('role:manage', 'Manage roles and their permissions');
+
+	INSERT INTO roles (name, description, date_created, date_updated) VALUES
+		('ADMIN', 'Full access', NOW(), NOW()),
+		('USER', 'Regular user', NOW(), NOW());
+
+	INSERT INTO role_permissions (role, permission)
+		SELECT 'ADMIN', name FROM permissions;
+
+	INSERT INTO role_permissions (role, permission) VALUES
+		('USER', 'product:read'),
+		('USER', 'product:create'),
+		('USER', 'product:write'),
+		('USER', 'sale:read'),
+		('USER', 'order:read'),
+		('USER', 'user:read'),
+		('USER', 'user:write'),
+		('USER', 'apikey:manage');`,
+	},
+	{
+		Version:     10,
+		Description: "Add organizations and move existing data into a default one",
+		Script: `
+	CREATE TABLE organizations (
+		org_id	UUID,
+		name	TEXT,
+		date_created	TIMESTAMP,
+		date_updated	TIMESTAMP,
+
+		PRIMARY KEY (org_id)
+	);
+
+	INSERT INTO organizations (org_id, name, date_created, date_updated) VALUES
+		('d0eebc99-9c0b-4ef8-bb6d-6bb9bd390a01', 'Default', NOW(), NOW());
+
+	ALTER TABLE users
+		ADD COLUMN org_id UUID NOT NULL DEFAULT 'd0eebc99-9c0b-4ef8-bb6d-6bb9bd390a01' REFERENCES organizations(org_id);
+	ALTER TABLE products
+		ADD COLUMN org_id UUID NOT NULL DEFAULT 'd0eebc99-9c0b-4ef8-bb6d-6bb9bd390a01' REFERENCES organizations(org_id);
+	ALTER TABLE orders
+		ADD COLUMN org_id UUID NOT NULL DEFAULT 'd0eebc99-9c0b-4ef8-bb6d-6bb9bd390a01' REFERENCES organizations(org_id);
+
+	CREATE INDEX users_org_id_idx ON users (org_id);
+	CREATE INDEX products_org_id_idx ON products (org_id);
+	CREATE INDEX orders_org_id_idx ON orders (org_id);
+
+	INSERT INTO permissions (name, description) VALUES
+		('organization:manage', 'Manage organizations');
+
+	INSERT INTO roles (name, description, date_created, date_updated) VALUES
+		('SUPERADMIN', 'Full access to every organization', NOW(), NOW());
+
+	INSERT INTO role_permissions (role, permission)
+		SELECT 'SUPERADMIN', name FROM permissions;
+
+	-- Roles are shared by every organization, so only super-admins may
+	-- change them.
+	DELETE FROM role_permissions WHERE role = 'ADMIN' AND permission = 'role:manage';`,
+	},
+	{
+		Version:     11,
+		Description: "Add audit log",
+		Script: `
+	CREATE TABLE audit_log (
+		audit_id	UUID,
+		org_id	UUID,
+		actor_id	UUID,
+		action	TEXT NOT NULL,
+		resource	TEXT NOT NULL,
+		resource_id	TEXT NOT NULL,
+		before	JSONB,
+		after	JSONB,
+		trace_id	TEXT,
+		date_created	TIMESTAMP,
+
+		PRIMARY KEY (audit_id)
+	);
+
+	CREATE INDEX audit_log_org_id_date_created_idx ON audit_log (org_id, date_created);
+	CREATE INDEX audit_log_resource_idx ON audit_log (resource, resource_id);
+	CREATE INDEX audit_log_actor_id_idx ON audit_log (actor_id);
+
+	INSERT INTO permissions (name, description) VALUES
+		('audit:read', 'View the audit log');
+
+	INSERT INTO role_permissions (role, permission) VALUES
+		('ADMIN', 'audit:read'),
+		('SUPERADMIN', 'audit:read');`,
+	},
+	{
+		Version:     12,
+		Description: "Add event outbox and webhooks",
+		Script: `
+	CREATE TABLE outbox (
+		event_id	UUID,
+		org_id	UUID NOT NULL REFERENCES organizations(org_id) ON DELETE CASCADE,
+		type	TEXT NOT NULL,
+		payload	JSONB NOT NULL,
+		date_created	TIMESTAMP,
+		date_dispatched	TIMESTAMP,
+
+		PRIMARY KEY (event_id)
+	);
+
+	CREATE INDEX outbox_pending_idx ON outbox (date_created) WHERE date_dispatched IS NULL;
+
+	CREATE TABLE webhook_subscriptions (
+		subscription_id	UUID,
+		org_id	UUID NOT NULL REFERENCES organizations(org_id) ON DELETE CASCADE,
+		url	TEXT NOT NULL,
+		secret	TEXT NOT NULL,
+		events	TEXT[] NOT NULL,
+		date_created	TIMESTAMP,
+		date_updated	TIMESTAMP,
+
+		PRIMARY KEY (subscription_id)
+	);
+
+	CREATE INDEX webhook_subscriptions_org_id_idx ON webhook_subscriptions (org_id);
+
+	CREATE TABLE webhook_deliveries (
+		delivery_id	UUID,
+		subscription_id	UUID NOT NULL REFERENCES webhook_subscriptions(subscription_id) ON DELETE CASCADE,
+		event_id	UUID NOT NULL REFERENCES outbox(event_id) ON DELETE CASCADE,
+		status	TEXT NOT NULL,
+		attempts	INT NOT NULL DEFAULT 0,
+		next_attempt	TIMESTAMP NOT NULL,
+		last_error	TEXT NOT NULL DEFAULT '',
+		response_code	INT,
+		date_created	TIMESTAMP,
+		date_delivered	TIMESTAMP,
+
+		PRIMARY KEY (delivery_id)
+	);
+
+	CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (next_attempt) WHERE status = 'pending';
+	CREATE INDEX webhook_deliveries_subscription_id_idx ON webhook_deliveries (subscription_id, status);
+
+	INSERT INTO permissions (name, description) VALUES
+		('webhook:manage', 'Manage webhook subscriptions and replay deliveries');
+
+	INSERT INTO role_permissions (role, permission) VALUES
+		('ADMIN', 'webhook:manage'),
+		('SUPERADMIN', 'webhook:manage');`,
+	},
+	{
+		Version:     13,
+		Description: "Archive products instead of deleting them",
+		Script: `
+	ALTER TABLE products ADD COLUMN date_deleted TIMESTAMP;
+
+	CREATE INDEX products_date_deleted_idx ON products (date_deleted) WHERE date_deleted IS NOT NULL;
+
+	INSERT INTO permissions (name, description) VALUES
+		('product:restore', 'Restore archived products'),
+		('product:purge', 'Permanently remove archived products');
+
+	INSERT INTO role_permissions (role, permission) VALUES
+		('ADMIN', 'product:restore'),
+		('ADMIN', 'product:purge'),
+		('SUPERADMIN', 'product:restore'),
+		('SUPERADMIN', 'product:purge');`,
+	},
+	{
+		Version:     14,
+		Description: "Add product versions",
+		Script: `
+	ALTER TABLE products ADD COLUMN version INT NOT NULL DEFAULT 1;`,
+	},
+	{
+		Version:     15,
+		Description: "Add product revisions",
+		Script: `
+	CREATE TABLE product_revisions (
+		product_id	UUID REFERENCES products(id) ON DELETE CASCADE,
+		version	INT,
+		name	TEXT NOT NULL,
+		cost	INT NOT NULL,
+		quantity	INT NOT NULL,
+		user_id	UUID,
+		date_created	TIMESTAMP,
+
+		PRIMARY KEY (product_id, version)
+	);
+
+	-- Start the history of existing products with their current state.
+	INSERT INTO product_revisions (product_id, version, name, cost, quantity, user_id, date_created)
+		SELECT id, version, name, cost, quantity, user_id, date_updated FROM products;
+
+	INSERT INTO permissions (name, description) VALUES
+		('product:rollback', 'Roll products back to an earlier revision');
+
+	INSERT INTO role_permissions (role, permission) VALUES
+		('ADMIN', 'product:rollback'),
+		('SUPERADMIN', 'product:rollback');`,
+	},
+	{
+		Version:     16,
+		Description: "Add effective dated prices and sale list prices",
+		Script: `
+	CREATE TABLE product_prices (
+		price_id	UUID,
+		product_id	UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+		cost	INT NOT NULL,
+		effective_from	TIMESTAMP NOT NULL,
+		user_id	UUID,
+		date_created	TIMESTAMP,
+
+		PRIMARY KEY (price_id),
+		UNIQUE (product_id, effective_from)
+	);
+
+	-- Existing products have had their current price since they were created.
+	INSERT INTO product_prices (price_id, product_id, cost, effective_from, user_id, date_created)
+		SELECT md5(id::text || '-price')::UUID, id, cost, date_created, user_id, date_created FROM products;
+
+	-- The list price is the unit price of the product when it was sold. Older
+	-- sales only have the current price to go by.
+	ALTER TABLE sales ADD COLUMN list_price INT;
+	UPDATE sales SET list_price = p.cost FROM products AS p WHERE p.id = sales.product_id;
+	ALTER TABLE sales ALTER COLUMN list_price SET NOT NULL;
+
+	CREATE OR REPLACE VIEW net_sales AS
+		SELECT s.sale_id, s.order_id, s.product_id,
+			s.quantity - COALESCE(r.quantity, 0) AS quantity,
+			s.paid - COALESCE(r.amount, 0) AS paid,
+			s.date_created,
+			s.list_price
+		FROM sales AS s
+		LEFT JOIN (
+			SELECT sale_id, SUM(quantity) AS quantity, SUM(amount) AS amount
+			FROM refunds GROUP BY sale_id
+		) AS r ON r.sale_id = s.sale_id;`,
+	},
+	{
+		Version:     17,
+		Description: "Add product categories and tags",
+		Script: `
+	CREATE TABLE categories (
+		category_id	UUID,
+		org_id	UUID NOT NULL REFERENCES organizations(org_id),
+		parent_id	UUID REFERENCES categories(category_id),
+		name	TEXT NOT NULL,
+		date_created	TIMESTAMP,
+		date_updated	TIMESTAMP,
+
+		PRIMARY KEY (category_id)
+	);
+
+	CREATE INDEX categories_parent_id_idx ON categories (parent_id);
+
+	ALTER TABLE products
+		ADD COLUMN category_id UUID REFERENCES categories(category_id) ON DELETE SET NULL,
+		ADD COLUMN tags TEXT[] NOT NULL DEFAULT '{}';
+
+	CREATE INDEX products_category_id_idx ON products (category_id);
+	CREATE INDEX products_tags_idx ON products USING GIN (tags);
+
+	INSERT INTO permissions (name, description) VALUES
+		('category:manage', 'Create, change and delete product categories');
+
+	INSERT INTO role_permissions (role, permission) VALUES
+		('ADMIN', 'category:manage'),
+		('SUPERADMIN', 'category:manage');`,
+	},
+	{
+		Version:     18,
+		Description: "Add full text and fuzzy search on products",
+		Script: `
+	CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+	-- product_search builds the search document of a product. Names weigh more
+	-- than tags. The simple configuration keeps words as they are, so prefixes
+	-- of product names match.
+	CREATE FUNCTION product_search(name TEXT, tags TEXT[]) RETURNS tsvector AS $$
+		SELECT setweight(to_tsvector('simple', COALESCE(name, '')), 'A') ||
+			setweight(to_tsvector('simple', array_to_string(tags, ' ')), 'B')
+	$$ LANGUAGE SQL IMMUTABLE;
+
+	ALTER TABLE products ADD COLUMN search tsvector;
+	UPDATE products SET search = product_search(name, tags);
+
+	CREATE INDEX products_search_idx ON products USING GIN (search);
+	CREATE INDEX products_name_trgm_idx ON products USING GIN (name gin_trgm_ops);`,
+	},
+	{
+		Version:     19,
+		Description: "Add product SKUs",
+		Script: `
+	ALTER TABLE products ADD COLUMN sku TEXT;
+
+	CREATE UNIQUE INDEX products_org_id_sku_idx ON products (org_id, sku);`,
+	},
+	{
+		Version:     20,
+		Description: "Add product barcodes",
+		Script: `
+	ALTER TABLE products ADD COLUMN barcode TEXT;
+
+	-- Barcodes are compared as 14 digit GTINs, so a UPC-A and the EAN-13 it
+	-- reads as with a leading zero are the same barcode.
+	CREATE UNIQUE INDEX products_org_id_barcode_idx ON products (org_id, lpad(barcode, 14, '0'));`,
+	},
+	{
+		Version:     21,
+		Description: "Add product variants",
+		Script: `
+	CREATE TABLE product_variants (
+		variant_id	UUID,
+		product_id	UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+		org_id	UUID NOT NULL REFERENCES organizations(org_id),
+		sku	TEXT,
+		attributes	JSONB NOT NULL DEFAULT '{}',
+		cost	INT,
+		quantity	INT,
+		date_created	TIMESTAMP,
+		date_updated	TIMESTAMP,
+
+		PRIMARY KEY (variant_id)
+	);
+
+	CREATE INDEX product_variants_product_id_idx ON product_variants (product_id);
+	CREATE UNIQUE INDEX product_variants_org_id_sku_idx ON product_variants (org_id, sku);
+
+	-- Sales of a product with variants name the variant sold. Variants with
+	-- sales cannot be removed, the sales still count towards the product.
+	ALTER TABLE sales ADD COLUMN variant_id UUID REFERENCES product_variants(variant_id);
+
+	CREATE INDEX sales_variant_id_idx ON sales (variant_id);
+
+	CREATE OR REPLACE VIEW net_sales AS
+		SELECT s.sale_id, s.order_id, s.product_id,
+			s.quantity - COALESCE(r.quantity, 0) AS quantity,
+			s.paid - COALESCE(r.amount, 0) AS paid,
+			s.date_created,
+			s.list_price,
+			s.variant_id
+		FROM sales AS s
+		LEFT JOIN (
+			SELECT sale_id, SUM(quantity) AS quantity, SUM(amount) AS amount
+			FROM refunds GROUP BY sale_id
+		) AS r ON r.sale_id = s.sale_id;`,
+	},
+	{
+		Version:     22,
+		Description: "Add warehouses with stock levels and transfers",
+		Script: `
+	CREATE TABLE warehouses (
+		warehouse_id	UUID,
+		org_id	UUID NOT NULL REFERENCES organizations(org_id),
+		name	TEXT NOT NULL,
+		date_created	TIMESTAMP,
+		date_updated	TIMESTAMP,
+
+		PRIMARY KEY (warehouse_id)
+	);
+
+	CREATE UNIQUE INDEX warehouses_org_id_name_idx ON warehouses (org_id, name);
+
+	-- Units of a product on hand at a warehouse. Sales drawn from the
+	-- warehouse and shipped transfers take units out, received transfers
+	-- and refunds put them back. Products with variants are stocked by
+	-- variant, the others have no variant.
+	CREATE TABLE stock_levels (
+		warehouse_id	UUID NOT NULL REFERENCES warehouses(warehouse_id),
+		product_id	UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+		variant_id	UUID REFERENCES product_variants(variant_id),
+		quantity	INT NOT NULL CHECK (quantity >= 0),
+		date_updated	TIMESTAMP
+	);
+
+	CREATE UNIQUE INDEX stock_levels_key_idx ON stock_levels
+		(warehouse_id, product_id, COALESCE(variant_id, '00000000-0000-0000-0000-000000000000'));
+	CREATE INDEX stock_levels_product_id_idx ON stock_levels (product_id);
+
+	-- Units moved between warehouses are on their way from the time they are
+	-- shipped until they are received.
+	CREATE TABLE stock_transfers (
+		transfer_id	UUID,
+		org_id	UUID NOT NULL REFERENCES organizations(org_id),
+		product_id	UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+		variant_id	UUID REFERENCES product_variants(variant_id),
+		from_warehouse_id	UUID NOT NULL REFERENCES warehouses(warehouse_id),
+		to_warehouse_id	UUID NOT NULL REFERENCES warehouses(warehouse_id),
+		quantity	INT NOT NULL,
+		status	TEXT NOT NULL,
+		shipped_by	UUID,
+		received_by	UUID,
+		date_shipped	TIMESTAMP,
+		date_received	TIMESTAMP,
+
+		PRIMARY KEY (transfer_id)
+	);
+
+	CREATE INDEX stock_transfers_org_id_idx ON stock_transfers (org_id, date_shipped);
+	CREATE INDEX stock_transfers_product_id_idx ON stock_transfers (product_id) WHERE status = 'shipped';
+
+	ALTER TABLE sales ADD COLUMN warehouse_id UUID REFERENCES warehouses(warehouse_id);
+
+	INSERT INTO permissions (name, description) VALUES
+		('warehouse:manage', 'Manage warehouses and count their stock'),
+		('stock:transfer', 'Ship and receive stock between warehouses');
+
+	INSERT INTO role_permissions (role, permission) VALUES
+		('ADMIN', 'warehouse:manage'),
+		('ADMIN', 'stock:transfer'),
+		('SUPERADMIN', 'warehouse:manage'),
+		('SUPERADMIN', 'stock:transfer');`,
+	},
 }
 
 func Migrate(db *sqlx.DB) error {
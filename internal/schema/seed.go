@@ -3,15 +3,25 @@ package schema
 import "github.com/jmoiron/sqlx"
 
 const seeds = `
-INSERT INTO products (id,name,cost,quantity,date_created,date_updated) VALUES
-('a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a21','Lego City',3000,56,'2024-05-05T12:12:12Z','2024-05-06T14:15:12Z'),
-('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11','Lego Chima',2000,50,'2024-05-05T12:12:12Z','2024-05-06T14:15:12Z')
+INSERT INTO products (id,name,cost,quantity,date_created,date_updated,search) VALUES
+('a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a21','Lego City',3000,56,'2024-05-05T12:12:12Z','2024-05-06T14:15:12Z',product_search('Lego City','{}')),
+('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11','Lego Chima',2000,50,'2024-05-05T12:12:12Z','2024-05-06T14:15:12Z',product_search('Lego Chima','{}'))
 ON CONFLICT DO NOTHING;
 
-INSERT INTO sales (sale_id,product_id,quantity,paid,date_created) VALUES
-('b0eebc99-9c0b-4ef8-bb6d-6bb9bd390a41','a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a21',1,3000,'2024-05-05T12:12:12Z'),
-('b0eebc99-9c0b-4ef8-bb6d-6bb9bd380a51','a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a21',2,6000,'2024-05-05T12:12:12Z'),
-('b0eebc99-9c0b-4ef8-bb6d-6bb9bd380a61','a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11',1,2000,'2024-05-05T12:12:12Z')
+INSERT INTO product_prices (price_id,product_id,cost,effective_from,date_created) VALUES
+('e0eebc99-9c0b-4ef8-bb6d-6bb9bd390a21','a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a21',3000,'2024-05-05T12:12:12Z','2024-05-05T12:12:12Z'),
+('e0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11','a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11',2000,'2024-05-05T12:12:12Z','2024-05-05T12:12:12Z')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO orders (order_id,user_id,date_created) VALUES
+('c0eebc99-9c0b-4ef8-bb6d-6bb9bd390a71','a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03','2024-05-05T12:12:12Z'),
+('c0eebc99-9c0b-4ef8-bb6d-6bb9bd390a81','a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03','2024-05-05T12:12:12Z')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO sales (sale_id,order_id,product_id,quantity,list_price,paid,date_created) VALUES
+('b0eebc99-9c0b-4ef8-bb6d-6bb9bd390a41','c0eebc99-9c0b-4ef8-bb6d-6bb9bd390a71','a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a21',1,3000,3000,'2024-05-05T12:12:12Z'),
+('b0eebc99-9c0b-4ef8-bb6d-6bb9bd380a51','c0eebc99-9c0b-4ef8-bb6d-6bb9bd390a81','a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a21',2,3000,6000,'2024-05-05T12:12:12Z'),
+('b0eebc99-9c0b-4ef8-bb6d-6bb9bd380a61','c0eebc99-9c0b-4ef8-bb6d-6bb9bd390a81','a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11',1,2000,2000,'2024-05-05T12:12:12Z')
 ON CONFLICT DO NOTHING;
 
 INSERT INTO users (user_id,name,email,password_hash,roles,date_created,date_updated) VALUES
@@ -13,6 +13,7 @@ type User struct {
 	Name         string         `db:"name" json:"name"`
 	Email        string         `db:"email" json:"email"`
 	Roles        pq.StringArray `db:"roles" json:"roles"`
+	OrgID        string         `db:"org_id" json:"org_id"`
 	PasswordHash []byte         `db:"password_hash" json:"-"`
 	DateCreated  time.Time      `db:"date_created" json:"date_created"`
 	DateUpdated  time.Time      `db:"date_updated" json:"date_updated"`
@@ -20,8 +21,28 @@ type User struct {
 
 type NewUser struct {
 	Name            string   `json:"name" validate:"required"`
-	Email           string   `json:"email" validate:"required"`
+	Email           string   `json:"email" validate:"required,email"`
 	Password        string   `json:"password" validate:"required"`
-	Roles           []string `json:"roles" validate:"required"`
+	Roles           []string `json:"roles" validate:"required,dive,required"`
 	PasswordConfirm string   `json:"password_confirm" validate:"eqfield=Password"`
+
+	// OrgID places the user in another organization than the caller's. Only
+	// super-admins may set it.
+	OrgID string `json:"org_id" validate:"omitempty,uuid"`
+}
+
+// UpdateUser defines what information may be provided to modify an existing
+// user. All fields are optional so clients can send just the fields they want
+// changed. A new password must be confirmed. Roles are changed separately
+// with UpdateUserRoles.
+type UpdateUser struct {
+	Name            *string `json:"name"`
+	Email           *string `json:"email" validate:"omitempty,email"`
+	Password        *string `json:"password"`
+	PasswordConfirm *string `json:"password_confirm" validate:"omitempty,eqfield=Password"`
+}
+
+// UpdateUserRoles replaces the roles of a user.
+type UpdateUserRoles struct {
+	Roles []string `json:"roles" validate:"required,min=1,dive,required"`
 }
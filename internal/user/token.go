@@ -0,0 +1,205 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"sales_service/internal/platform/auth"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// AccessTokenTTL is how long a signed access token stays valid.
+	AccessTokenTTL = time.Hour
+
+	// RefreshTokenTTL is how long a refresh token may be exchanged for a new
+	// access token.
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidToken = errors.New("refresh token is invalid or expired")
+
+	// ErrTokenReused is returned when a refresh token that was already
+	// exchanged is presented again. The whole token family is revoked since
+	// the token has most likely been stolen.
+	ErrTokenReused = errors.New("refresh token was already used")
+)
+
+// refreshToken is a stored refresh token. Only the hash of the token is kept.
+type refreshToken struct {
+	ID          string       `db:"token_id"`
+	FamilyID    string       `db:"family_id"`
+	UserID      string       `db:"user_id"`
+	DateExpires time.Time    `db:"date_expires"`
+	DateUsed    sql.NullTime `db:"date_used"`
+	DateRevoked sql.NullTime `db:"date_revoked"`
+}
+
+// IssueRefreshToken creates a refresh token for the user starting a new
+// token family. The returned token is shown to the client once and cannot be
+// recovered from the database.
+func IssueRefreshToken(ctx context.Context, db *sqlx.DB, userID string, now time.Time) (string, error) {
+	return issueRefreshToken(ctx, db, userID, uuid.New().String(), now)
+}
+
+// Refresh exchanges a refresh token for new claims and a new refresh token in
+// the same family. Each refresh token can be used once; presenting it again
+// revokes every token of its family.
+func Refresh(ctx context.Context, db *sqlx.DB, token string, now time.Time) (auth.Claims, string, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return auth.Claims{}, "", errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	var rt refreshToken
+
+	const q = `SELECT token_id, family_id, user_id, date_expires, date_used, date_revoked
+	FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &rt, q, hashToken(token)); err != nil {
+		if err == sql.ErrNoRows {
+			return auth.Claims{}, "", ErrInvalidToken
+		}
+		return auth.Claims{}, "", errors.Wrap(err, "selecting refresh token")
+	}
+
+	if rt.DateUsed.Valid {
+		if err := revokeFamily(ctx, tx, rt.FamilyID, now); err != nil {
+			return auth.Claims{}, "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return auth.Claims{}, "", errors.Wrap(err, "committing revocation")
+		}
+		return auth.Claims{}, "", ErrTokenReused
+	}
+
+	if rt.DateRevoked.Valid || !now.Before(rt.DateExpires) {
+		return auth.Claims{}, "", ErrInvalidToken
+	}
+
+	const used = `UPDATE refresh_tokens SET date_used = $1 WHERE token_id = $2`
+	if _, err := tx.ExecContext(ctx, used, now.UTC(), rt.ID); err != nil {
+		return auth.Claims{}, "", errors.Wrap(err, "marking refresh token used")
+	}
+
+	var u User
+	if err := tx.GetContext(ctx, &u, selectUsers+` WHERE user_id = $1`, rt.UserID); err != nil {
+		if err == sql.ErrNoRows {
+			return auth.Claims{}, "", ErrInvalidToken
+		}
+		return auth.Claims{}, "", errors.Wrap(err, "selecting token owner")
+	}
+
+	next, err := issueRefreshToken(ctx, tx, rt.UserID, rt.FamilyID, now)
+	if err != nil {
+		return auth.Claims{}, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return auth.Claims{}, "", errors.Wrap(err, "committing refresh")
+	}
+
+	return auth.NewClaims(u.ID, u.OrgID, u.Roles, now, AccessTokenTTL), next, nil
+}
+
+// Logout revokes the access token described by claims until it expires. When
+// a refresh token of the same user is given, its whole family is revoked too.
+func Logout(ctx context.Context, db *sqlx.DB, claims auth.Claims, refresh string, now time.Time) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	if refresh != "" {
+		var familyID string
+
+		const q = `SELECT family_id FROM refresh_tokens WHERE token_hash = $1 AND user_id = $2`
+		err := tx.GetContext(ctx, &familyID, q, hashToken(refresh), claims.Subject)
+		switch {
+		case err == sql.ErrNoRows:
+			return ErrInvalidToken
+		case err != nil:
+			return errors.Wrap(err, "selecting refresh token")
+		}
+
+		if err := revokeFamily(ctx, tx, familyID, now); err != nil {
+			return err
+		}
+	}
+
+	if claims.Id != "" {
+		// Entries are only needed until the token would have expired anyway.
+		const purge = `DELETE FROM revoked_tokens WHERE date_expires < $1`
+		if _, err := tx.ExecContext(ctx, purge, now.UTC()); err != nil {
+			return errors.Wrap(err, "purging revoked tokens")
+		}
+
+		const q = `INSERT INTO revoked_tokens (jti, date_expires) VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING`
+		if _, err := tx.ExecContext(ctx, q, claims.Id, time.Unix(claims.ExpiresAt, 0).UTC()); err != nil {
+			return errors.Wrap(err, "revoking access token")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing logout")
+	}
+
+	return nil
+}
+
+// IsTokenRevoked reports whether the access token with the given ID was
+// revoked by a logout.
+func IsTokenRevoked(ctx context.Context, db *sqlx.DB, jti string) (bool, error) {
+	var revoked bool
+
+	const q = `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`
+	if err := db.GetContext(ctx, &revoked, q, jti); err != nil {
+		return false, errors.Wrap(err, "checking revoked tokens")
+	}
+
+	return revoked, nil
+}
+
+// issueRefreshToken stores a new refresh token of the given family and
+// returns its plain text.
+func issueRefreshToken(ctx context.Context, db sqlx.ExecerContext, userID, familyID string, now time.Time) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating refresh token")
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	const q = `INSERT INTO refresh_tokens (token_id, family_id, user_id, token_hash, date_created, date_expires)
+	VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := db.ExecContext(ctx, q, uuid.New().String(), familyID, userID, hashToken(token), now.UTC(), now.Add(RefreshTokenTTL).UTC())
+	if err != nil {
+		return "", errors.Wrap(err, "inserting refresh token")
+	}
+
+	return token, nil
+}
+
+// revokeFamily revokes every refresh token descending from the same login.
+func revokeFamily(ctx context.Context, tx *sqlx.Tx, familyID string, now time.Time) error {
+	const q = `UPDATE refresh_tokens SET date_revoked = $1 WHERE family_id = $2 AND date_revoked IS NULL`
+	if _, err := tx.ExecContext(ctx, q, now.UTC(), familyID); err != nil {
+		return errors.Wrap(err, "revoking refresh tokens")
+	}
+	return nil
+}
+
+// hashToken returns the form of a refresh token kept in the database.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,108 @@
+package user
+
+import (
+	"context"
+	"sales_service/internal/organization"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/database/databasetest"
+	"sales_service/internal/schema"
+	"testing"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+func TestRefresh(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+
+	if err := schema.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	first, err := IssueRefreshToken(ctx, db, userID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotating once gives claims for the owner and a new token.
+	claims, second, err := Refresh(ctx, db, first, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != userID || claims.OrgID != organization.DefaultID {
+		t.Fatalf("expected claims of %s, got %+v", userID, claims)
+	}
+	if second == first {
+		t.Fatal("expected a new refresh token")
+	}
+
+	// Replaying the old token fails and revokes the one it was rotated to.
+	if _, _, err := Refresh(ctx, db, first, now); !errors.Is(err, ErrTokenReused) {
+		t.Fatalf("expected ErrTokenReused, got %v", err)
+	}
+	if _, _, err := Refresh(ctx, db, second, now); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+
+	// An expired token is rejected.
+	stale, err := IssueRefreshToken(ctx, db, userID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Refresh(ctx, db, stale, now.Add(RefreshTokenTTL)); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+
+	if _, _, err := Refresh(ctx, db, "unknown", now); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+
+	if err := schema.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	claims := auth.NewClaims(userID, organization.DefaultID, []string{auth.RoleUser}, now, AccessTokenTTL)
+
+	refresh, err := IssueRefreshToken(ctx, db, userID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another user's refresh token cannot be revoked.
+	other := auth.NewClaims(adminID, organization.DefaultID, []string{auth.RoleAdmin}, now, AccessTokenTTL)
+	if err := Logout(ctx, db, other, refresh, now); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+
+	if err := Logout(ctx, db, claims, refresh, now); err != nil {
+		t.Fatal(err)
+	}
+
+	revoked, err := IsTokenRevoked(ctx, db, claims.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked {
+		t.Fatal("expected the access token to be revoked")
+	}
+	if revoked, err = IsTokenRevoked(ctx, db, other.Id); err != nil || revoked {
+		t.Fatalf("expected the other access token to be valid, got %v, %v", revoked, err)
+	}
+
+	if _, _, err := Refresh(ctx, db, refresh, now); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+}
@@ -3,20 +3,58 @@ package user
 import (
 	"context"
 	"database/sql"
+	"sales_service/internal/audit"
 	"sales_service/internal/platform/auth"
+	"sales_service/internal/role"
 	"time"
 
 	"github.com/go-faster/errors"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	ErrAuthenticationFailure = errors.New("authentication failed")
+	ErrNotFound              = errors.New("user not found")
+	ErrInvalidID             = errors.New("invalid user ID format")
+	ErrForbidden             = errors.New("action not allowed")
+	ErrEmailTaken            = errors.New("email is already in use")
+	ErrPasswordConfirm       = errors.New("password confirmation does not match")
+	ErrUnknownOrg            = errors.New("organization not found")
 )
 
-func Create(ctx context.Context, db *sqlx.DB, nu NewUser, now time.Time) (*User, error) {
+const (
+	// DefaultLimit is the page size used when the caller does not ask for one.
+	DefaultLimit = 50
+
+	// MaxLimit is the largest page size a caller may request.
+	MaxLimit = 500
+)
+
+// selectUsers lists the user columns in the order User declares them.
+const selectUsers = `SELECT user_id, name, email, roles, org_id, password_hash, date_created, date_updated FROM users`
+
+// Create adds a user to the organization of the caller, or to the one asked
+// for when the caller is a super-admin. The roles of the user may only grant
+// permissions the caller holds, and only super-admins may create other
+// super-admins.
+func Create(ctx context.Context, db *sqlx.DB, claims auth.Claims, nu NewUser, now time.Time) (*User, error) {
+	orgID := claims.OrgID
+	if nu.OrgID != "" && nu.OrgID != orgID {
+		if claims.OrgScope() != nil {
+			return nil, ErrForbidden
+		}
+		orgID = nu.OrgID
+	}
+
+	if err := mayGrant(ctx, db, claims, nu.Roles); err != nil {
+		return nil, err
+	}
+	if err := role.CheckExist(ctx, db, nu.Roles); err != nil {
+		return nil, err
+	}
 
 	hash, err := bcrypt.GenerateFromPassword([]byte(nu.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -28,16 +66,300 @@ func Create(ctx context.Context, db *sqlx.DB, nu NewUser, now time.Time) (*User,
 		Name:         nu.Name,
 		Email:        nu.Email,
 		Roles:        nu.Roles,
+		OrgID:        orgID,
 		PasswordHash: hash,
 		DateCreated:  now.UTC(),
 		DateUpdated:  now.UTC(),
 	}
 
-	const q = `INSERT INTO users (user_id, name, email, roles, password_hash, date_created, date_updated)
-	VALUES($1, $2, $3, $4, $5, $6, $7)`
-	if _, err := db.ExecContext(ctx, q, u.ID, u.Name, u.Email, u.Roles, u.PasswordHash, u.DateCreated, u.DateUpdated); err != nil {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	const q = `INSERT INTO users (user_id, name, email, roles, org_id, password_hash, date_created, date_updated)
+	VALUES($1, $2, $3, $4, $5, $6, $7, $8)`
+	if _, err := tx.ExecContext(ctx, q, u.ID, u.Name, u.Email, u.Roles, u.OrgID, u.PasswordHash, u.DateCreated, u.DateUpdated); err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrEmailTaken
+		}
+		if isForeignKeyViolation(err) {
+			return nil, ErrUnknownOrg
+		}
 		return nil, errors.Wrap(err, "inserting user")
 	}
+
+	change := audit.Change{
+		OrgID:      u.OrgID,
+		Action:     audit.Create,
+		Resource:   audit.User,
+		ResourceID: u.ID,
+		After:      u,
+	}
+	if err := audit.Record(ctx, tx, claims, change, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing user")
+	}
+	return &u, nil
+}
+
+// List retrieves a page of users of the caller's organization ordered by
+// email. Pages larger than MaxLimit are cut down to it.
+func List(ctx context.Context, db *sqlx.DB, claims auth.Claims, limit, offset int) ([]User, error) {
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	users := []User{}
+
+	const q = selectUsers + ` WHERE ($1::UUID IS NULL OR org_id = $1) ORDER BY email LIMIT $2 OFFSET $3`
+	if err := db.SelectContext(ctx, &users, q, claims.OrgScope(), limit, offset); err != nil {
+		return nil, errors.Wrap(err, "selecting users")
+	}
+
+	return users, nil
+}
+
+// Retrieve gets the specified user of the caller's organization from the
+// database. Callers need the user:read permission for themselves or for any
+// user.
+func Retrieve(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string) (*User, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	if !claims.Can(auth.PermUserRead, id) {
+		return nil, ErrForbidden
+	}
+
+	return retrieve(ctx, db, claims.OrgScope(), id)
+}
+
+// retrieve gets a user of the org organization, or of any when org is nil,
+// without checking permissions.
+func retrieve(ctx context.Context, db *sqlx.DB, org *string, id string) (*User, error) {
+	var u User
+
+	const q = selectUsers + ` WHERE user_id = $1 AND ($2::UUID IS NULL OR org_id = $2)`
+	if err := db.GetContext(ctx, &u, q, id, org); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting user %q", id)
+	}
+
+	return &u, nil
+}
+
+// RetrieveByEmail gets the user with the given email. It is meant for
+// trusted callers such as the admin tool and does no permission checks.
+func RetrieveByEmail(ctx context.Context, db *sqlx.DB, email string) (*User, error) {
+	var u User
+
+	const q = selectUsers + ` WHERE email = $1`
+	if err := db.GetContext(ctx, &u, q, email); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting user %q", email)
+	}
+
+	return &u, nil
+}
+
+// Update modifies data about a user. Callers need the user:write permission
+// for themselves or for any user.
+func Update(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string, upd UpdateUser, now time.Time) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	if !claims.Can(auth.PermUserWrite, id) {
+		return ErrForbidden
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	before, err := lock(ctx, tx, claims.OrgScope(), id)
+	if err != nil {
+		return err
+	}
+
+	u := *before
+	if upd.Name != nil {
+		u.Name = *upd.Name
+	}
+	if upd.Email != nil {
+		u.Email = *upd.Email
+	}
+	if upd.Password != nil {
+		if upd.PasswordConfirm == nil || *upd.PasswordConfirm != *upd.Password {
+			return ErrPasswordConfirm
+		}
+		pw, err := bcrypt.GenerateFromPassword([]byte(*upd.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return errors.Wrap(err, "generating password hash")
+		}
+		u.PasswordHash = pw
+	}
+	u.DateUpdated = now.UTC()
+
+	const q = `UPDATE users SET
+	name = $1, email = $2, password_hash = $3,
+	date_updated = $4 WHERE user_id = $5`
+
+	if _, err := tx.ExecContext(ctx, q, u.Name, u.Email, u.PasswordHash, u.DateUpdated, u.ID); err != nil {
+		if isUniqueViolation(err) {
+			return ErrEmailTaken
+		}
+		return errors.Wrap(err, "updating user")
+	}
+
+	change := audit.Change{
+		OrgID:      u.OrgID,
+		Action:     audit.Update,
+		Resource:   audit.User,
+		ResourceID: u.ID,
+		Before:     before,
+		After:      u,
+	}
+	if err := audit.Record(ctx, tx, claims, change, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing user update")
+	}
+
+	return nil
+}
+
+// UpdateRoles replaces the roles of a user of the caller's organization. Both
+// the roles the user has and those replacing them may only grant permissions
+// the caller holds, and only super-admins may make other users super-admins
+// or take that role away.
+func UpdateRoles(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string, upd UpdateUserRoles, now time.Time) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	if err := mayGrant(ctx, db, claims, upd.Roles); err != nil {
+		return err
+	}
+	if err := role.CheckExist(ctx, db, upd.Roles); err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	before, err := lock(ctx, tx, claims.OrgScope(), id)
+	if err != nil {
+		return err
+	}
+	if err := mayGrant(ctx, tx, claims, before.Roles); err != nil {
+		return err
+	}
+
+	u := *before
+	u.Roles = upd.Roles
+	u.DateUpdated = now.UTC()
+
+	const q = `UPDATE users SET roles = $1, date_updated = $2 WHERE user_id = $3`
+	if _, err := tx.ExecContext(ctx, q, u.Roles, u.DateUpdated, id); err != nil {
+		return errors.Wrap(err, "updating user roles")
+	}
+
+	change := audit.Change{
+		OrgID:      u.OrgID,
+		Action:     audit.Update,
+		Resource:   audit.User,
+		ResourceID: u.ID,
+		Before:     before,
+		After:      u,
+	}
+	if err := audit.Record(ctx, tx, claims, change, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing user roles")
+	}
+
+	return nil
+}
+
+// Delete removes a user of the caller's organization from the database. The
+// roles of the user may only grant permissions the caller holds.
+func Delete(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string, now time.Time) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	before, err := lock(ctx, tx, claims.OrgScope(), id)
+	if err != nil {
+		return err
+	}
+	if err := mayGrant(ctx, tx, claims, before.Roles); err != nil {
+		return err
+	}
+
+	const q = `DELETE FROM users WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, q, id); err != nil {
+		return errors.Wrapf(err, "deleting user %s", id)
+	}
+
+	change := audit.Change{
+		OrgID:      before.OrgID,
+		Action:     audit.Delete,
+		Resource:   audit.User,
+		ResourceID: id,
+		Before:     before,
+	}
+	if err := audit.Record(ctx, tx, claims, change, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing user delete")
+	}
+
+	return nil
+}
+
+// lock locks the row of a user of the org organization, or of any when org
+// is nil, inside tx and returns the user.
+func lock(ctx context.Context, tx *sqlx.Tx, org *string, id string) (*User, error) {
+	var u User
+
+	const q = selectUsers + ` WHERE user_id = $1 AND ($2::UUID IS NULL OR org_id = $2) FOR UPDATE`
+	if err := tx.GetContext(ctx, &u, q, id, org); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "locking user %q", id)
+	}
+
 	return &u, nil
 }
 
@@ -52,7 +374,7 @@ func Authenticate(
 ) (auth.Claims, error) {
 
 	// Query the database for the user with the given email.
-	const q = `SELECT * FROM users WHERE email = $1`
+	const q = selectUsers + ` WHERE email = $1`
 	var u User
 	if err := db.GetContext(ctx, &u, q, email); err != nil {
 
@@ -73,9 +395,39 @@ func Authenticate(
 		return auth.Claims{}, ErrAuthenticationFailure
 	}
 
-	// Generate the user's claims with the user's ID, roles, and an expiration time.
-	claims := auth.NewClaims(u.ID, u.Roles, now, time.Hour)
+	// Generate the user's claims with the user's ID, organization, roles, and an expiration time.
+	claims := auth.NewClaims(u.ID, u.OrgID, u.Roles, now, AccessTokenTTL)
 
 	// Return the user's claims.
 	return claims, nil
 }
+
+// mayGrant returns ErrForbidden unless claims allow giving roles to a user.
+// Callers can only hand out permissions they hold themselves, so a user
+// allowed to change roles cannot raise anyone above their own rights. Only
+// super-admins can hand out the super-admin role. The same holds for the
+// roles a user already has before they are changed or the user is removed,
+// so nobody can demote or delete someone with more rights than their own.
+func mayGrant(ctx context.Context, db sqlx.QueryerContext, claims auth.Claims, roles []string) error {
+	within, err := role.Within(ctx, db, claims, roles)
+	if err != nil {
+		return err
+	}
+	if !within {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// isForeignKeyViolation reports whether err was caused by a foreign key
+// constraint.
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+// isUniqueViolation reports whether err was caused by a unique constraint.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -0,0 +1,139 @@
+package user
+
+import (
+	"context"
+	"sales_service/internal/organization"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/database/databasetest"
+	"sales_service/internal/role"
+	"sales_service/internal/schema"
+	"testing"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+const (
+	adminID = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03"
+	userID  = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a04"
+)
+
+func TestProfiles(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+
+	if err := schema.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	// A regular user is granted user:read and user:write for itself only.
+	claims := auth.NewClaims(userID, organization.DefaultID, []string{auth.RoleUser}, now, time.Hour)
+	perms, err := role.PermissionsFor(ctx, db, claims.Roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims.Permissions = perms
+
+	if _, err := Retrieve(ctx, db, claims, userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Retrieve(ctx, db, claims, adminID); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+
+	name := "Renamed"
+	if err := Update(ctx, db, claims, userID, UpdateUser{Name: &name}, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := Update(ctx, db, claims, adminID, UpdateUser{Name: &name}, now); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+
+	// user:read:any lets the caller read anyone, but not change them.
+	claims.Permissions = append(claims.Permissions, auth.Any(auth.PermUserRead))
+
+	u, err := Retrieve(ctx, db, claims, adminID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "Admin" {
+		t.Fatalf("expected the admin, got %q", u.Name)
+	}
+	if err := Update(ctx, db, claims, adminID, UpdateUser{Name: &name}, now); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+
+	u, err = Retrieve(ctx, db, claims, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != name {
+		t.Fatalf("expected %q, got %q", name, u.Name)
+	}
+}
+
+func TestGrantRoles(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+
+	if err := schema.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	// A regular user trusted with changing roles still cannot hand out
+	// permissions beyond its own.
+	claims := auth.NewClaims(userID, organization.DefaultID, []string{auth.RoleUser}, now, time.Hour)
+	perms, err := role.PermissionsFor(ctx, db, claims.Roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims.Permissions = append(perms, auth.Any(auth.PermUserRoles))
+
+	admin := UpdateUserRoles{Roles: []string{auth.RoleAdmin}}
+	if err := UpdateRoles(ctx, db, claims, userID, admin, now); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+	nu := NewUser{Name: "Mallory", Email: "mallory@mail.ru", Roles: admin.Roles, Password: "secret", PasswordConfirm: "secret"}
+	if _, err := Create(ctx, db, claims, nu, now); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+
+	if err := UpdateRoles(ctx, db, claims, adminID, UpdateUserRoles{Roles: []string{auth.RoleUser}}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// An admin holds every permission of the admin role.
+	claims = auth.NewClaims(adminID, organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+	if claims.Permissions, err = role.PermissionsFor(ctx, db, claims.Roles); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateRoles(ctx, db, claims, userID, admin, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nor can an admin demote or remove a super-admin of the organization.
+	super := auth.NewClaims(adminID, organization.DefaultID, []string{auth.RoleSuperAdmin}, now, time.Hour)
+	nu = NewUser{Name: "Root", Email: "root@mail.ru", Roles: []string{auth.RoleSuperAdmin}, Password: "secret", PasswordConfirm: "secret"}
+	root, err := Create(ctx, db, super, nu, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UpdateRoles(ctx, db, claims, root.ID, UpdateUserRoles{Roles: []string{auth.RoleUser}}, now); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+	if err := Delete(ctx, db, claims, root.ID, now); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+	if err := Delete(ctx, db, super, root.ID, now); err != nil {
+		t.Fatal(err)
+	}
+}
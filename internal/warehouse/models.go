@@ -0,0 +1,78 @@
+package warehouse
+
+import "time"
+
+// Warehouse is a location of an organization where products are stocked.
+type Warehouse struct {
+	ID          string    `db:"warehouse_id" json:"id"`
+	OrgID       string    `db:"org_id" json:"org_id"`
+	Name        string    `db:"name" json:"name"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// NewWarehouse is what we require from clients when adding a warehouse.
+type NewWarehouse struct {
+	Name string `json:"name" validate:"required,max=128"`
+}
+
+// Level is the stock of a product, or of one of its variants, at a
+// warehouse: the units on hand and those shipped to it but not received yet.
+type Level struct {
+	WarehouseID   string     `db:"warehouse_id" json:"warehouse_id"`
+	WarehouseName string     `db:"warehouse_name" json:"warehouse_name"`
+	ProductID     string     `db:"product_id" json:"product_id"`
+	ProductName   string     `db:"product_name" json:"product_name"`
+	VariantID     *string    `db:"variant_id" json:"variant_id,omitempty"`
+	VariantSKU    string     `db:"variant_sku" json:"variant_sku,omitempty"`
+	Quantity      int        `db:"quantity" json:"quantity"`
+	Incoming      int        `db:"incoming" json:"incoming"`
+	DateUpdated   *time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// Stock is the stock of a product over every warehouse. OnHand adds up the
+// units at the warehouses and InTransit those shipped between them, which
+// are counted at no warehouse until received. Total is their sum.
+type Stock struct {
+	ProductID string  `json:"product_id"`
+	Levels    []Level `json:"levels"`
+	OnHand    int     `json:"on_hand"`
+	InTransit int     `json:"in_transit"`
+	Total     int     `json:"total"`
+}
+
+// Count sets the units of a product on hand at a warehouse, as found by a
+// stock take or after a delivery. Products with variants are counted by
+// variant.
+type Count struct {
+	VariantID string `json:"variant_id" validate:"omitempty,uuid"`
+	Quantity  int    `json:"quantity" validate:"gte=0"`
+}
+
+// Transfer moves units of a product from one warehouse to another. The units
+// leave the first warehouse when shipped and only reach the second when
+// received.
+type Transfer struct {
+	ID              string     `db:"transfer_id" json:"id"`
+	OrgID           string     `db:"org_id" json:"org_id"`
+	ProductID       string     `db:"product_id" json:"product_id"`
+	VariantID       *string    `db:"variant_id" json:"variant_id,omitempty"`
+	FromWarehouseID string     `db:"from_warehouse_id" json:"from_warehouse_id"`
+	ToWarehouseID   string     `db:"to_warehouse_id" json:"to_warehouse_id"`
+	Quantity        int        `db:"quantity" json:"quantity"`
+	Status          string     `db:"status" json:"status"`
+	ShippedBy       string     `db:"shipped_by" json:"shipped_by"`
+	ReceivedBy      *string    `db:"received_by" json:"received_by,omitempty"`
+	DateShipped     time.Time  `db:"date_shipped" json:"date_shipped"`
+	DateReceived    *time.Time `db:"date_received" json:"date_received,omitempty"`
+}
+
+// NewTransfer is what we require from clients when shipping stock to another
+// warehouse. Products with variants are shipped by variant.
+type NewTransfer struct {
+	ProductID       string `json:"product_id" validate:"required,uuid"`
+	VariantID       string `json:"variant_id" validate:"omitempty,uuid"`
+	FromWarehouseID string `json:"from_warehouse_id" validate:"required,uuid"`
+	ToWarehouseID   string `json:"to_warehouse_id" validate:"required,uuid,nefield=FromWarehouseID"`
+	Quantity        int    `json:"quantity" validate:"gte=1"`
+}
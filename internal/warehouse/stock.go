@@ -0,0 +1,304 @@
+package warehouse
+
+import (
+	"context"
+	"database/sql"
+	"sales_service/internal/audit"
+	"sales_service/internal/platform/auth"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// selectLevels lists the columns of a Level in the order it declares them,
+// for a query joining w, p, v and l, the warehouse, product, variant and
+// stock level. Incoming adds up the transfers shipped to the warehouse and
+// not received yet.
+const selectLevels = `SELECT w.warehouse_id, w.name AS warehouse_name, p.id AS product_id, p.name AS product_name,
+	v.variant_id, COALESCE(v.sku, '') AS variant_sku,
+	COALESCE(l.quantity, 0) AS quantity,
+	(SELECT COALESCE(SUM(t.quantity), 0) FROM stock_transfers AS t
+		WHERE t.to_warehouse_id = w.warehouse_id AND t.product_id = p.id
+		AND t.variant_id IS NOT DISTINCT FROM v.variant_id AND t.status = 'shipped') AS incoming,
+	l.date_updated`
+
+// isLevel matches the stock level l of the product $2, or of its variant $3
+// unless it is empty, at the warehouse $1.
+const isLevel = `l.warehouse_id = $1 AND l.product_id = $2 AND l.variant_id IS NOT DISTINCT FROM NULLIF($3, '')::UUID`
+
+// levelKey is the conflict target of stock level upserts, the columns of
+// stock_levels_key_idx.
+const levelKey = `(warehouse_id, product_id, COALESCE(variant_id, '00000000-0000-0000-0000-000000000000'))`
+
+// ProductStock gives the stock of a product of the caller's organization at
+// every warehouse of the organization, those without any included, and over
+// all of them. Products with variants have a level per warehouse and
+// variant.
+func ProductStock(ctx context.Context, db *sqlx.DB, user auth.Claims, productID string) (*Stock, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidProductID
+	}
+
+	var exists bool
+
+	const q1 = `SELECT EXISTS (SELECT 1 FROM products WHERE id = $1 AND ($2::UUID IS NULL OR org_id = $2))`
+	if err := db.GetContext(ctx, &exists, q1, productID, user.OrgScope()); err != nil {
+		return nil, errors.Wrap(err, "selecting product")
+	}
+	if !exists {
+		return nil, ErrProductNotFound
+	}
+
+	s := Stock{ProductID: productID, Levels: []Level{}}
+
+	const q2 = selectLevels + ` FROM products AS p
+	JOIN warehouses AS w ON w.org_id = p.org_id
+	LEFT JOIN product_variants AS v ON v.product_id = p.id
+	LEFT JOIN stock_levels AS l ON l.warehouse_id = w.warehouse_id AND l.product_id = p.id
+		AND l.variant_id IS NOT DISTINCT FROM v.variant_id
+	WHERE p.id = $1
+	ORDER BY w.name, w.warehouse_id, v.date_created, v.variant_id`
+	if err := db.SelectContext(ctx, &s.Levels, q2, productID); err != nil {
+		return nil, errors.Wrap(err, "selecting stock levels")
+	}
+
+	for _, l := range s.Levels {
+		s.OnHand += l.Quantity
+		s.InTransit += l.Incoming
+	}
+	s.Total = s.OnHand + s.InTransit
+
+	return &s, nil
+}
+
+// ListStock gives the stock of every product kept at a warehouse of the
+// caller's organization, ordered by product name. Archived products are left
+// out.
+func ListStock(ctx context.Context, db *sqlx.DB, user auth.Claims, warehouseID string) ([]Level, error) {
+	if _, err := Retrieve(ctx, db, user, warehouseID); err != nil {
+		return nil, err
+	}
+
+	list := []Level{}
+
+	const q = selectLevels + ` FROM stock_levels AS l
+	JOIN warehouses AS w ON w.warehouse_id = l.warehouse_id
+	JOIN products AS p ON p.id = l.product_id
+	LEFT JOIN product_variants AS v ON v.variant_id = l.variant_id
+	WHERE l.warehouse_id = $1 AND p.date_deleted IS NULL
+	ORDER BY p.name, p.id, v.date_created, v.variant_id`
+	if err := db.SelectContext(ctx, &list, q, warehouseID); err != nil {
+		return nil, errors.Wrap(err, "selecting stock levels")
+	}
+
+	return list, nil
+}
+
+// SetStock records the units of a product, or of one of its variants, found
+// on hand at a warehouse of the caller's organization, replacing what was
+// recorded before. The quantity of the product follows, see reconcile.
+func SetStock(ctx context.Context, db *sqlx.DB, user auth.Claims, warehouseID, productID string, c Count, now time.Time) (*Level, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	w, err := retrieve(ctx, tx, user, warehouseID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkProduct(ctx, tx, w.OrgID, productID, c.VariantID); err != nil {
+		return nil, err
+	}
+
+	before, err := level(ctx, tx, w.ID, productID, c.VariantID)
+	if err != nil {
+		return nil, err
+	}
+
+	const q = `INSERT INTO stock_levels (warehouse_id, product_id, variant_id, quantity, date_updated)
+	VALUES ($1, $2, NULLIF($3, '')::UUID, $4, $5)
+	ON CONFLICT ` + levelKey + ` DO UPDATE SET quantity = EXCLUDED.quantity, date_updated = EXCLUDED.date_updated`
+	if _, err := tx.ExecContext(ctx, q, w.ID, productID, c.VariantID, c.Quantity, now.UTC()); err != nil {
+		return nil, errors.Wrap(err, "setting stock level")
+	}
+
+	if err := reconcile(ctx, tx, productID, c.VariantID, now); err != nil {
+		return nil, err
+	}
+
+	after, err := level(ctx, tx, w.ID, productID, c.VariantID)
+	if err != nil {
+		return nil, err
+	}
+
+	change := audit.Change{
+		OrgID:      w.OrgID,
+		Action:     audit.Update,
+		Resource:   audit.Stock,
+		ResourceID: productID,
+		Before:     before,
+		After:      after,
+	}
+	if err := audit.Record(ctx, tx, user, change, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing stock level")
+	}
+
+	return after, nil
+}
+
+// Stocked reports whether a product, or its variant when variantID is not
+// empty, is stocked in warehouses. The quantity of a stocked product follows
+// its stock levels and cannot be set directly.
+func Stocked(ctx context.Context, tx *sqlx.Tx, productID, variantID string) (bool, error) {
+	var stocked bool
+
+	const q = `SELECT EXISTS (SELECT 1 FROM stock_levels
+	WHERE product_id = $1 AND variant_id IS NOT DISTINCT FROM NULLIF($2, '')::UUID)`
+	if err := tx.GetContext(ctx, &stocked, q, productID, variantID); err != nil {
+		return false, errors.Wrap(err, "selecting stock levels")
+	}
+	return stocked, nil
+}
+
+// Draw takes quantity units of a product, or of one of its variants, out of
+// a warehouse of the caller's organization for a sale recorded in tx. The
+// product must already be locked in tx, and the stock level is locked until
+// tx ends. Products stocked in any warehouse must be drawn from one; for the
+// others warehouseID may be empty, and nothing is drawn. The quantity of the
+// product is left alone: the units drawn are now counted as sold.
+func Draw(ctx context.Context, tx *sqlx.Tx, user auth.Claims, warehouseID, productID, variantID string, quantity int, now time.Time) error {
+	if warehouseID == "" {
+		stocked, err := Stocked(ctx, tx, productID, variantID)
+		if err != nil {
+			return err
+		}
+		if stocked {
+			return ErrRequired
+		}
+		return nil
+	}
+
+	if _, err := retrieve(ctx, tx, user, warehouseID); err != nil {
+		return err
+	}
+	return take(ctx, tx, warehouseID, productID, variantID, quantity, now)
+}
+
+// Return puts quantity units of a product, or of one of its variants, back
+// into a warehouse inside tx, as when a sale drawn from it is refunded. The
+// quantity of the product is left alone: the units are no longer counted as
+// sold.
+func Return(ctx context.Context, tx *sqlx.Tx, warehouseID, productID, variantID string, quantity int, now time.Time) error {
+	const q = `INSERT INTO stock_levels (warehouse_id, product_id, variant_id, quantity, date_updated)
+	VALUES ($1, $2, NULLIF($3, '')::UUID, $4, $5)
+	ON CONFLICT ` + levelKey + ` DO UPDATE SET
+	quantity = stock_levels.quantity + EXCLUDED.quantity, date_updated = EXCLUDED.date_updated`
+	if _, err := tx.ExecContext(ctx, q, warehouseID, productID, variantID, quantity, now.UTC()); err != nil {
+		return errors.Wrap(err, "returning stock")
+	}
+	return nil
+}
+
+// Reconcile makes the quantity of a product, or of one of its variants,
+// stocked in warehouses agree with its stock levels inside tx, see
+// reconcile. The product must already be locked in tx. Products not stocked
+// are left alone.
+func Reconcile(ctx context.Context, tx *sqlx.Tx, productID, variantID string, now time.Time) error {
+	stocked, err := Stocked(ctx, tx, productID, variantID)
+	if err != nil {
+		return err
+	}
+	if !stocked {
+		return nil
+	}
+	return reconcile(ctx, tx, productID, variantID, now)
+}
+
+// reconcile makes the quantity of a product agree with its stock levels
+// after they changed inside tx, the way variants roll up into their product.
+// A stocked product, or variant, holds the units on hand at the warehouses
+// plus those sold, so that the units available for sale are the ones on
+// hand; units in transit are available again once received. A product with
+// variants holds the sum of theirs. The version of the product is bumped.
+func reconcile(ctx context.Context, tx *sqlx.Tx, productID, variantID string, now time.Time) error {
+	if variantID != "" {
+		const q = `UPDATE product_variants SET
+		quantity = (SELECT COALESCE(SUM(quantity), 0) FROM stock_levels WHERE variant_id = $1)
+			+ (SELECT COALESCE(SUM(quantity), 0) FROM net_sales WHERE variant_id = $1),
+		date_updated = $2
+		WHERE variant_id = $1`
+		if _, err := tx.ExecContext(ctx, q, variantID, now.UTC()); err != nil {
+			return errors.Wrap(err, "reconciling variant quantity")
+		}
+
+		const rollUp = `UPDATE products SET
+		quantity = (SELECT COALESCE(SUM(quantity), 0) FROM product_variants WHERE product_id = $1),
+		version = version + 1, date_updated = $2
+		WHERE id = $1`
+		if _, err := tx.ExecContext(ctx, rollUp, productID, now.UTC()); err != nil {
+			return errors.Wrap(err, "rolling up variants")
+		}
+		return nil
+	}
+
+	const q = `UPDATE products SET
+	quantity = (SELECT COALESCE(SUM(quantity), 0) FROM stock_levels WHERE product_id = $1)
+		+ (SELECT COALESCE(SUM(quantity), 0) FROM net_sales WHERE product_id = $1),
+	version = version + 1, date_updated = $2
+	WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, q, productID, now.UTC()); err != nil {
+		return errors.Wrap(err, "reconciling product quantity")
+	}
+	return nil
+}
+
+// take locks the stock level of a product, or of one of its variants, at a
+// warehouse and takes quantity units out of it.
+func take(ctx context.Context, tx *sqlx.Tx, warehouseID, productID, variantID string, quantity int, now time.Time) error {
+	var onHand int
+
+	const q1 = `SELECT l.quantity FROM stock_levels AS l WHERE ` + isLevel + ` FOR UPDATE`
+	if err := tx.GetContext(ctx, &onHand, q1, warehouseID, productID, variantID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInsufficientStock
+		}
+		return errors.Wrap(err, "locking stock level")
+	}
+	if quantity > onHand {
+		return ErrInsufficientStock
+	}
+
+	const q2 = `UPDATE stock_levels AS l SET quantity = l.quantity - $4, date_updated = $5 WHERE ` + isLevel
+	if _, err := tx.ExecContext(ctx, q2, warehouseID, productID, variantID, quantity, now.UTC()); err != nil {
+		return errors.Wrap(err, "taking stock")
+	}
+	return nil
+}
+
+// level reads the stock of a product, or of one of its variants, at a
+// warehouse inside tx, nil when it was never stocked there.
+func level(ctx context.Context, tx *sqlx.Tx, warehouseID, productID, variantID string) (*Level, error) {
+	var l Level
+
+	const q = selectLevels + ` FROM stock_levels AS l
+	JOIN warehouses AS w ON w.warehouse_id = l.warehouse_id
+	JOIN products AS p ON p.id = l.product_id
+	LEFT JOIN product_variants AS v ON v.variant_id = l.variant_id
+	WHERE ` + isLevel
+	if err := tx.GetContext(ctx, &l, q, warehouseID, productID, variantID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "selecting stock level")
+	}
+
+	return &l, nil
+}
@@ -0,0 +1,211 @@
+package warehouse
+
+import (
+	"context"
+	"database/sql"
+	"sales_service/internal/audit"
+	"sales_service/internal/event"
+	"sales_service/internal/platform/auth"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// Statuses of a transfer.
+const (
+	// StatusShipped transfers left their warehouse and are on their way.
+	StatusShipped = "shipped"
+
+	// StatusReceived transfers reached the warehouse they were shipped to.
+	StatusReceived = "received"
+)
+
+var (
+	ErrTransferNotFound  = errors.New("transfer not found")
+	ErrInvalidTransferID = errors.New("invalid transfer ID format")
+	ErrInvalidStatus     = errors.New("invalid transfer status")
+	ErrAlreadyReceived   = errors.New("transfer was already received")
+)
+
+// selectTransfers lists the transfer columns in the order Transfer declares
+// them.
+const selectTransfers = `SELECT transfer_id, org_id, product_id, variant_id, from_warehouse_id, to_warehouse_id, quantity, status,
+	shipped_by, received_by, date_shipped, date_received FROM stock_transfers`
+
+// ListTransfers gives the transfers of the caller's organization, newest
+// first, only those in status unless it is empty.
+func ListTransfers(ctx context.Context, db *sqlx.DB, user auth.Claims, status string) ([]Transfer, error) {
+	if status != "" && status != StatusShipped && status != StatusReceived {
+		return nil, ErrInvalidStatus
+	}
+
+	list := []Transfer{}
+
+	const q = selectTransfers + ` WHERE ($1::UUID IS NULL OR org_id = $1) AND ($2 = '' OR status = $2)
+	ORDER BY date_shipped DESC, transfer_id`
+	if err := db.SelectContext(ctx, &list, q, user.OrgScope(), status); err != nil {
+		return nil, errors.Wrap(err, "selecting transfers")
+	}
+
+	return list, nil
+}
+
+// Ship takes units of a product, or of one of its variants, out of a
+// warehouse of the caller's organization to send them to another. They are
+// counted at neither warehouse until received, nor as available for sale.
+func Ship(ctx context.Context, db *sqlx.DB, user auth.Claims, nt NewTransfer, now time.Time) (*Transfer, error) {
+	now = now.UTC().Truncate(time.Microsecond)
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	from, err := retrieve(ctx, tx, user, nt.FromWarehouseID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := retrieve(ctx, tx, user, nt.ToWarehouseID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only a super-admin could name warehouses of two organizations.
+	if to.OrgID != from.OrgID {
+		return nil, ErrNotFound
+	}
+
+	if err := checkProduct(ctx, tx, from.OrgID, nt.ProductID, nt.VariantID); err != nil {
+		return nil, err
+	}
+	if err := take(ctx, tx, from.ID, nt.ProductID, nt.VariantID, nt.Quantity, now); err != nil {
+		return nil, err
+	}
+
+	t := Transfer{
+		ID:              uuid.New().String(),
+		OrgID:           from.OrgID,
+		ProductID:       nt.ProductID,
+		FromWarehouseID: from.ID,
+		ToWarehouseID:   to.ID,
+		Quantity:        nt.Quantity,
+		Status:          StatusShipped,
+		ShippedBy:       user.Subject,
+		DateShipped:     now,
+	}
+	if nt.VariantID != "" {
+		t.VariantID = &nt.VariantID
+	}
+
+	const q = `INSERT INTO stock_transfers (transfer_id, org_id, product_id, variant_id, from_warehouse_id, to_warehouse_id, quantity, status, shipped_by, date_shipped)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	_, err = tx.ExecContext(ctx, q, t.ID, t.OrgID, t.ProductID, t.VariantID, t.FromWarehouseID, t.ToWarehouseID, t.Quantity, t.Status, t.ShippedBy, t.DateShipped)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting transfer")
+	}
+
+	if err := reconcile(ctx, tx, t.ProductID, nt.VariantID, now); err != nil {
+		return nil, err
+	}
+
+	change := audit.Change{
+		OrgID:      t.OrgID,
+		Action:     audit.Create,
+		Resource:   audit.Transfer,
+		ResourceID: t.ID,
+		After:      t,
+	}
+	if err := audit.Record(ctx, tx, user, change, now); err != nil {
+		return nil, err
+	}
+
+	if err := event.Publish(ctx, tx, t.OrgID, event.TransferShipped, t, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing transfer")
+	}
+
+	return &t, nil
+}
+
+// Receive puts the units of a shipped transfer of the caller's organization
+// into the warehouse they were sent to, where they are available for sale
+// again. A transfer is received once.
+func Receive(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, now time.Time) (*Transfer, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidTransferID
+	}
+
+	now = now.UTC().Truncate(time.Microsecond)
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	var before Transfer
+
+	const q1 = selectTransfers + ` WHERE transfer_id = $1 AND ($2::UUID IS NULL OR org_id = $2) FOR UPDATE`
+	if err := tx.GetContext(ctx, &before, q1, id, user.OrgScope()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTransferNotFound
+		}
+		return nil, errors.Wrap(err, "locking transfer")
+	}
+	if before.Status != StatusShipped {
+		return nil, ErrAlreadyReceived
+	}
+
+	var variantID string
+	if before.VariantID != nil {
+		variantID = *before.VariantID
+	}
+
+	if err := lockProduct(ctx, tx, before.ProductID); err != nil {
+		return nil, err
+	}
+	if err := Return(ctx, tx, before.ToWarehouseID, before.ProductID, variantID, before.Quantity, now); err != nil {
+		return nil, err
+	}
+	if err := reconcile(ctx, tx, before.ProductID, variantID, now); err != nil {
+		return nil, err
+	}
+
+	t := before
+	t.Status = StatusReceived
+	t.ReceivedBy = &user.Subject
+	t.DateReceived = &now
+
+	const q2 = `UPDATE stock_transfers SET status = $1, received_by = $2, date_received = $3 WHERE transfer_id = $4`
+	if _, err := tx.ExecContext(ctx, q2, t.Status, t.ReceivedBy, t.DateReceived, t.ID); err != nil {
+		return nil, errors.Wrap(err, "updating transfer")
+	}
+
+	change := audit.Change{
+		OrgID:      t.OrgID,
+		Action:     audit.Update,
+		Resource:   audit.Transfer,
+		ResourceID: t.ID,
+		Before:     before,
+		After:      t,
+	}
+	if err := audit.Record(ctx, tx, user, change, now); err != nil {
+		return nil, err
+	}
+
+	if err := event.Publish(ctx, tx, t.OrgID, event.TransferReceived, t, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing transfer")
+	}
+
+	return &t, nil
+}
@@ -0,0 +1,175 @@
+// Package warehouse keeps the stock of products at the locations of an
+// organization and the transfers between them.
+package warehouse
+
+import (
+	"context"
+	"database/sql"
+	"sales_service/internal/audit"
+	"sales_service/internal/platform/auth"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrNotFound          = errors.New("warehouse not found")
+	ErrInvalidID         = errors.New("invalid warehouse ID format")
+	ErrDuplicateName     = errors.New("warehouse name is already in use")
+	ErrProductNotFound   = errors.New("product not found")
+	ErrInvalidProductID  = errors.New("invalid product ID format")
+	ErrInsufficientStock = errors.New("not enough stock at the warehouse")
+	ErrVariantNotFound   = errors.New("variant not found")
+	ErrInvalidVariantID  = errors.New("invalid variant ID format")
+
+	// ErrVariantRequired is returned when counting or shipping a product
+	// with variants without naming the variant.
+	ErrVariantRequired = errors.New("product is stocked by variant, a variant must be given")
+
+	// ErrRequired is returned when selling a product stocked in warehouses
+	// without naming the warehouse the units are taken from.
+	ErrRequired = errors.New("product is stocked in warehouses, a warehouse must be given")
+)
+
+// selectWarehouses lists the warehouse columns in the order Warehouse
+// declares them.
+const selectWarehouses = `SELECT warehouse_id, org_id, name, date_created, date_updated FROM warehouses`
+
+// List gives every warehouse of the caller's organization, ordered by name.
+func List(ctx context.Context, db *sqlx.DB, user auth.Claims) ([]Warehouse, error) {
+	list := []Warehouse{}
+
+	const q = selectWarehouses + ` WHERE ($1::UUID IS NULL OR org_id = $1) ORDER BY name, warehouse_id`
+	if err := db.SelectContext(ctx, &list, q, user.OrgScope()); err != nil {
+		return nil, errors.Wrap(err, "selecting warehouses")
+	}
+
+	return list, nil
+}
+
+// Retrieve finds a warehouse of the caller's organization.
+func Retrieve(ctx context.Context, db *sqlx.DB, user auth.Claims, id string) (*Warehouse, error) {
+	return retrieve(ctx, db, user, id)
+}
+
+// Create adds a warehouse to the caller's organization. Names are unique
+// within an organization.
+func Create(ctx context.Context, db *sqlx.DB, user auth.Claims, nw NewWarehouse, now time.Time) (*Warehouse, error) {
+	now = now.UTC().Truncate(time.Microsecond)
+
+	w := Warehouse{
+		ID:          uuid.New().String(),
+		OrgID:       user.OrgID,
+		Name:        nw.Name,
+		DateCreated: now,
+		DateUpdated: now,
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	const q = `INSERT INTO warehouses (warehouse_id, org_id, name, date_created, date_updated)
+	VALUES ($1, $2, $3, $4, $5)`
+	if _, err := tx.ExecContext(ctx, q, w.ID, w.OrgID, w.Name, w.DateCreated, w.DateUpdated); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrDuplicateName
+		}
+		return nil, errors.Wrap(err, "inserting warehouse")
+	}
+
+	change := audit.Change{
+		OrgID:      w.OrgID,
+		Action:     audit.Create,
+		Resource:   audit.Warehouse,
+		ResourceID: w.ID,
+		After:      w,
+	}
+	if err := audit.Record(ctx, tx, user, change, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing warehouse")
+	}
+
+	return &w, nil
+}
+
+// retrieve reads a warehouse of the caller's organization through db or a
+// transaction.
+func retrieve(ctx context.Context, db sqlx.QueryerContext, user auth.Claims, id string) (*Warehouse, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var w Warehouse
+
+	const q = selectWarehouses + ` WHERE warehouse_id = $1 AND ($2::UUID IS NULL OR org_id = $2)`
+	if err := sqlx.GetContext(ctx, db, &w, q, id, user.OrgScope()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting warehouse")
+	}
+
+	return &w, nil
+}
+
+// checkProduct makes sure a product exists in the org organization and is
+// not archived, and locks it until tx ends, as sales do before drawing
+// stock. Products with variants are stocked by variant, so variantID must
+// name one of them; it must be empty for the others.
+func checkProduct(ctx context.Context, tx *sqlx.Tx, org, productID, variantID string) error {
+	if _, err := uuid.Parse(productID); err != nil {
+		return ErrInvalidProductID
+	}
+
+	var hasVariants bool
+
+	const q1 = `SELECT EXISTS (SELECT 1 FROM product_variants WHERE product_id = p.id) FROM products AS p
+	WHERE p.id = $1 AND p.org_id = $2 AND p.date_deleted IS NULL FOR UPDATE`
+	if err := tx.GetContext(ctx, &hasVariants, q1, productID, org); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrProductNotFound
+		}
+		return errors.Wrap(err, "locking product")
+	}
+
+	switch {
+	case variantID == "" && hasVariants:
+		return ErrVariantRequired
+	case variantID == "":
+		return nil
+	}
+	if _, err := uuid.Parse(variantID); err != nil {
+		return ErrInvalidVariantID
+	}
+
+	var exists bool
+
+	const q2 = `SELECT EXISTS (SELECT 1 FROM product_variants WHERE variant_id = $1 AND product_id = $2)`
+	if err := tx.GetContext(ctx, &exists, q2, variantID, productID); err != nil {
+		return errors.Wrap(err, "selecting variant")
+	}
+	if !exists {
+		return ErrVariantNotFound
+	}
+	return nil
+}
+
+// lockProduct locks a product until tx ends before its stock changes, as
+// sales do, even when it was archived since.
+func lockProduct(ctx context.Context, tx *sqlx.Tx, productID string) error {
+	const q = `SELECT id FROM products WHERE id = $1 FOR UPDATE`
+	if _, err := tx.ExecContext(ctx, q, productID); err != nil {
+		return errors.Wrap(err, "locking product")
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package warehouse
+
+import (
+	"context"
+	"sales_service/internal/organization"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/database/databasetest"
+	"sales_service/internal/schema"
+	"testing"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+func TestWarehouses(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+
+	if err := schema.Seed(db); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+
+	const lego = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a21"
+
+	north, err := Create(ctx, db, claims, NewWarehouse{Name: "North"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	south, err := Create(ctx, db, claims, NewWarehouse{Name: "South"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Create(ctx, db, claims, NewWarehouse{Name: "North"}, now); !errors.Is(err, ErrDuplicateName) {
+		t.Fatalf("expected ErrDuplicateName, got %v", err)
+	}
+
+	// The quantity of a stocked product is what its warehouses hold plus
+	// what was sold, 3 units, so that only the units on hand are available.
+	quantity := func(q, id string, want int) {
+		t.Helper()
+
+		var got int
+		if err := db.GetContext(ctx, &got, q, id); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("expected a quantity of %d, got %d", want, got)
+		}
+	}
+	const products = `SELECT quantity FROM products WHERE id = $1`
+
+	quantity(products, lego, 56)
+	if _, err := SetStock(ctx, db, claims, north.ID, lego, Count{Quantity: 10}, now); err != nil {
+		t.Fatal(err)
+	}
+	quantity(products, lego, 13)
+
+	// Shipped units are counted at neither warehouse until received.
+	if _, err := Ship(ctx, db, claims, NewTransfer{ProductID: lego, FromWarehouseID: north.ID, ToWarehouseID: south.ID, Quantity: 11}, now); !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("expected ErrInsufficientStock, got %v", err)
+	}
+	tr, err := Ship(ctx, db, claims, NewTransfer{ProductID: lego, FromWarehouseID: north.ID, ToWarehouseID: south.ID, Quantity: 4}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type level struct {
+		Warehouse          string
+		Quantity, Incoming int
+	}
+	check := func(wantLevels []level, onHand, inTransit int) {
+		t.Helper()
+
+		s, err := ProductStock(ctx, db, claims, lego)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []level
+		for _, l := range s.Levels {
+			got = append(got, level{l.WarehouseName, l.Quantity, l.Incoming})
+		}
+		if diff := cmp.Diff(wantLevels, got); diff != "" {
+			t.Fatalf("mismatch (-want +got):\n%s", diff)
+		}
+		if s.OnHand != onHand || s.InTransit != inTransit || s.Total != onHand+inTransit {
+			t.Fatalf("expected %d on hand and %d in transit, got %+v", onHand, inTransit, s)
+		}
+	}
+
+	check([]level{{"North", 6, 0}, {"South", 0, 4}}, 6, 4)
+	quantity(products, lego, 9)
+
+	if _, err := Receive(ctx, db, claims, tr.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Receive(ctx, db, claims, tr.ID, now); !errors.Is(err, ErrAlreadyReceived) {
+		t.Fatalf("expected ErrAlreadyReceived, got %v", err)
+	}
+
+	check([]level{{"North", 6, 0}, {"South", 4, 0}}, 10, 0)
+	quantity(products, lego, 13)
+
+	// Once stocked, the product is drawn from a named warehouse.
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if err := Draw(ctx, tx, claims, "", lego, "", 1, now); !errors.Is(err, ErrRequired) {
+		t.Fatalf("expected ErrRequired, got %v", err)
+	}
+	if err := Draw(ctx, tx, claims, south.ID, lego, "", 5, now); !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("expected ErrInsufficientStock, got %v", err)
+	}
+	if err := Draw(ctx, tx, claims, south.ID, lego, "", 4, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// The other product was never stocked in a warehouse.
+	const chima = "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"
+	if err := Draw(ctx, tx, claims, "", chima, "", 1, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Products with variants are stocked by variant.
+	red := uuid.New().String()
+	const q = `INSERT INTO product_variants (variant_id, product_id, org_id, sku, cost, quantity, date_created, date_updated)
+	VALUES ($1, $2, $3, 'CHIMA-RED', 2000, 0, $4, $4)`
+	if _, err := db.ExecContext(ctx, q, red, chima, organization.DefaultID, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := SetStock(ctx, db, claims, north.ID, chima, Count{Quantity: 7}, now); !errors.Is(err, ErrVariantRequired) {
+		t.Fatalf("expected ErrVariantRequired, got %v", err)
+	}
+	l, err := SetStock(ctx, db, claims, north.ID, chima, Count{VariantID: red, Quantity: 7}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.VariantID == nil || *l.VariantID != red || l.VariantSKU != "CHIMA-RED" || l.Quantity != 7 {
+		t.Fatalf("expected 7 units of the variant, got %+v", l)
+	}
+	quantity(`SELECT quantity FROM product_variants WHERE variant_id = $1`, red, 7)
+	quantity(products, chima, 7)
+
+	tx, err = db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if err := Draw(ctx, tx, claims, "", chima, red, 1, now); !errors.Is(err, ErrRequired) {
+		t.Fatalf("expected ErrRequired, got %v", err)
+	}
+	if err := Draw(ctx, tx, claims, north.ID, chima, red, 7, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Archived products are left out of the stock of a warehouse.
+	names := func(want ...string) {
+		t.Helper()
+
+		list, err := ListStock(ctx, db, claims, north.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, l := range list {
+			got = append(got, l.ProductName)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("mismatch (-want +got):\n%s", diff)
+		}
+	}
+
+	names("Lego Chima", "Lego City")
+	if _, err := db.ExecContext(ctx, `UPDATE products SET date_deleted = $1 WHERE id = $2`, now, chima); err != nil {
+		t.Fatal(err)
+	}
+	names("Lego City")
+}
@@ -0,0 +1,325 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sales_service/internal/event"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Headers sent with every delivery. The event ID stays the same across
+// attempts and replays so receivers can ignore duplicates.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	EventIDHeader   = "X-Webhook-ID"
+)
+
+// maxErrorLength limits how much of a failure is kept on a delivery.
+const maxErrorLength = 500
+
+// Dispatcher moves events from the outbox to the deliveries of the
+// subscriptions interested in them, and makes the deliveries. Several
+// dispatchers may run against the same database; rows are claimed with SKIP
+// LOCKED so each event and delivery is handled by one of them at a time.
+type Dispatcher struct {
+	DB     *sqlx.DB
+	Client *http.Client
+	Log    *log.Logger
+
+	// BatchSize is how many events and deliveries are handled per poll.
+	BatchSize int
+
+	// MaxAttempts is how many times a delivery is tried before it is
+	// marked failed and left for a replay.
+	MaxAttempts int
+
+	// MinBackoff is the wait after the first failed attempt. It doubles with
+	// every attempt up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Lease is how long a claimed delivery is hidden from other dispatchers.
+	// It must be longer than the client timeout.
+	Lease time.Duration
+}
+
+// NewDispatcher creates a dispatcher with the default settings: 8 attempts
+// over about an hour, each request timing out after 10 seconds.
+func NewDispatcher(db *sqlx.DB, log *log.Logger) *Dispatcher {
+	return &Dispatcher{
+		DB:          db,
+		Client:      newClient(),
+		Log:         log,
+		BatchSize:   100,
+		MaxAttempts: 8,
+		MinBackoff:  30 * time.Second,
+		MaxBackoff:  30 * time.Minute,
+		Lease:       time.Minute,
+	}
+}
+
+// newClient creates the client deliveries are made with. It only connects to
+// public addresses, checked once the host is resolved so that a name
+// rebound to a private address after the subscription was created is still
+// refused. Proxies are not used since they would hide the address.
+func newClient() *http.Client {
+	dialer := net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   dialControl,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
+
+// dialControl refuses connections to addresses webhooks may not be sent to.
+func dialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !permitted(ip) {
+		return ErrPrivateURL
+	}
+	return nil
+}
+
+// Run polls every interval until ctx is cancelled. Errors are logged and the
+// next poll tries again.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.Poll(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			d.Log.Printf("webhooks: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll fans out the pending events of the outbox and then makes the
+// deliveries that are due at now.
+func (d *Dispatcher) Poll(ctx context.Context, now time.Time) error {
+	if err := d.fanOut(ctx, now); err != nil {
+		return err
+	}
+	return d.deliver(ctx, now)
+}
+
+// fanOut creates a delivery for every subscription interested in each event
+// not yet dispatched, and marks the events dispatched.
+func (d *Dispatcher) fanOut(ctx context.Context, now time.Time) error {
+	tx, err := d.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	var events []event.Event
+
+	const q1 = `SELECT event_id, org_id, type, payload, date_created, date_dispatched FROM outbox
+	WHERE date_dispatched IS NULL ORDER BY date_created LIMIT $1 FOR UPDATE SKIP LOCKED`
+	if err := tx.SelectContext(ctx, &events, q1, d.BatchSize); err != nil {
+		return errors.Wrap(err, "selecting pending events")
+	}
+	if len(events) == 0 {
+		return nil
+	}
+
+	const q2 = `SELECT subscription_id FROM webhook_subscriptions WHERE org_id = $1 AND $2 = ANY(events)`
+	const q3 = `INSERT INTO webhook_deliveries
+	(delivery_id, subscription_id, event_id, status, next_attempt, date_created)
+	VALUES ($1, $2, $3, $4, $5, $6)`
+
+	ids := make([]string, len(events))
+	for i, e := range events {
+		ids[i] = e.ID
+
+		var subs []string
+		if err := tx.SelectContext(ctx, &subs, q2, e.OrgID, e.Type); err != nil {
+			return errors.Wrap(err, "selecting subscriptions")
+		}
+
+		for _, sub := range subs {
+			if _, err := tx.ExecContext(ctx, q3, uuid.New().String(), sub, e.ID, StatusPending, now.UTC(), now.UTC()); err != nil {
+				return errors.Wrap(err, "inserting delivery")
+			}
+		}
+	}
+
+	const q4 = `UPDATE outbox SET date_dispatched = $1 WHERE event_id = ANY($2)`
+	if _, err := tx.ExecContext(ctx, q4, now.UTC(), pq.Array(ids)); err != nil {
+		return errors.Wrap(err, "marking events dispatched")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing fan out")
+	}
+	return nil
+}
+
+// job is a claimed delivery with everything needed to make it.
+type job struct {
+	DeliveryID string `db:"delivery_id"`
+	Attempts   int    `db:"attempts"`
+	URL        string `db:"url"`
+	Secret     string `db:"secret"`
+	event.Event
+}
+
+// deliver claims the deliveries due at now and makes them one by one. A
+// claim pushes the next attempt back by the lease, so a dispatcher dying in
+// the middle of a batch only delays its deliveries.
+func (d *Dispatcher) deliver(ctx context.Context, now time.Time) error {
+	var ids []string
+
+	const q1 = `UPDATE webhook_deliveries SET next_attempt = $1
+	WHERE delivery_id IN (
+		SELECT delivery_id FROM webhook_deliveries
+		WHERE status = $2 AND next_attempt <= $3
+		ORDER BY next_attempt LIMIT $4 FOR UPDATE SKIP LOCKED)
+	RETURNING delivery_id`
+	if err := d.DB.SelectContext(ctx, &ids, q1, now.Add(d.Lease).UTC(), StatusPending, now.UTC(), d.BatchSize); err != nil {
+		return errors.Wrap(err, "claiming deliveries")
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var jobs []job
+
+	const q2 = `SELECT d.delivery_id, d.attempts, s.url, s.secret,
+	e.event_id, e.org_id, e.type, e.payload, e.date_created, e.date_dispatched
+	FROM webhook_deliveries AS d
+	JOIN webhook_subscriptions AS s ON s.subscription_id = d.subscription_id
+	JOIN outbox AS e ON e.event_id = d.event_id
+	WHERE d.delivery_id = ANY($1)
+	ORDER BY e.date_created`
+	if err := d.DB.SelectContext(ctx, &jobs, q2, pq.Array(ids)); err != nil {
+		return errors.Wrap(err, "selecting deliveries")
+	}
+
+	for _, j := range jobs {
+		code, err := d.send(ctx, j, now)
+		if err := d.record(ctx, j, code, err, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// send makes a single attempt of a delivery. It returns the status code of
+// the response if there was one.
+func (d *Dispatcher) send(ctx context.Context, j job, now time.Time) (*int, error) {
+	body, err := json.Marshal(j.Event)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding event")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, j.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "creating request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, j.Type)
+	req.Header.Set(EventIDHeader, j.ID)
+	req.Header.Set(SignatureHeader, Sign(j.Secret, now.Unix(), body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	code := resp.StatusCode
+	if code < 200 || code > 299 {
+		return &code, fmt.Errorf("unexpected status %d", code)
+	}
+	return &code, nil
+}
+
+// record saves the outcome of an attempt. A failed attempt is retried after
+// a backoff until MaxAttempts is reached, then the delivery is failed.
+func (d *Dispatcher) record(ctx context.Context, j job, code *int, sendErr error, now time.Time) error {
+	attempts := j.Attempts + 1
+
+	if sendErr == nil {
+		const q = `UPDATE webhook_deliveries SET status = $1, attempts = $2, response_code = $3,
+		last_error = '', date_delivered = $4 WHERE delivery_id = $5`
+		if _, err := d.DB.ExecContext(ctx, q, StatusDelivered, attempts, code, now.UTC(), j.DeliveryID); err != nil {
+			return errors.Wrap(err, "recording delivery")
+		}
+		return nil
+	}
+
+	status := StatusPending
+	if attempts >= d.MaxAttempts {
+		status = StatusFailed
+	}
+
+	msg := sendErr.Error()
+	if len(msg) > maxErrorLength {
+		msg = msg[:maxErrorLength]
+	}
+
+	const q = `UPDATE webhook_deliveries SET status = $1, attempts = $2, response_code = $3,
+	last_error = $4, next_attempt = $5 WHERE delivery_id = $6`
+	next := now.Add(d.backoff(attempts)).UTC()
+	if _, err := d.DB.ExecContext(ctx, q, status, attempts, code, msg, next, j.DeliveryID); err != nil {
+		return errors.Wrap(err, "recording failed delivery")
+	}
+	return nil
+}
+
+// backoff gives the wait after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.MinBackoff
+	for i := 1; i < attempts && wait < d.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > d.MaxBackoff {
+		wait = d.MaxBackoff
+	}
+	return wait
+}
+
+// Sign computes the value of the signature header for a request body sent at
+// the given Unix time. Receivers recompute the HMAC-SHA256 of the timestamp,
+// a dot and the raw body with their secret and compare it to v1, and should
+// reject timestamps too far in the past to prevent replays.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
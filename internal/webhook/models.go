@@ -0,0 +1,48 @@
+package webhook
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Subscription registers a URL to be called for events of the given types.
+// The secret signing the requests is never sent back after creation.
+type Subscription struct {
+	ID          string         `db:"subscription_id" json:"id"`
+	OrgID       string         `db:"org_id" json:"org_id"`
+	URL         string         `db:"url" json:"url"`
+	Events      pq.StringArray `db:"events" json:"events"`
+	DateCreated time.Time      `db:"date_created" json:"date_created"`
+	DateUpdated time.Time      `db:"date_updated" json:"date_updated"`
+}
+
+// Created is a newly created subscription along with the secret used to sign
+// its requests. The secret is only available at creation time.
+type Created struct {
+	Subscription
+	Secret string `json:"secret"`
+}
+
+// NewSubscription is what we require from clients when subscribing to
+// events.
+type NewSubscription struct {
+	URL    string   `json:"url" validate:"required,url"`
+	Events []string `json:"events" validate:"required,min=1,dive,required"`
+}
+
+// Delivery is one event to be delivered to one subscription, along with the
+// outcome of the last attempt.
+type Delivery struct {
+	ID             string     `db:"delivery_id" json:"id"`
+	SubscriptionID string     `db:"subscription_id" json:"subscription_id"`
+	EventID        string     `db:"event_id" json:"event_id"`
+	EventType      string     `db:"event_type" json:"event_type"`
+	Status         string     `db:"status" json:"status"`
+	Attempts       int        `db:"attempts" json:"attempts"`
+	NextAttempt    time.Time  `db:"next_attempt" json:"next_attempt"`
+	LastError      string     `db:"last_error" json:"last_error,omitempty"`
+	ResponseCode   *int       `db:"response_code" json:"response_code,omitempty"`
+	DateCreated    time.Time  `db:"date_created" json:"date_created"`
+	DateDelivered  *time.Time `db:"date_delivered" json:"date_delivered,omitempty"`
+}
@@ -0,0 +1,276 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"net"
+	"net/url"
+	"sales_service/internal/event"
+	"sales_service/internal/platform/auth"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// secretPrefix marks webhook signing secrets so they are easy to recognise.
+const secretPrefix = "whsec_"
+
+// Statuses of a delivery.
+const (
+	// StatusPending deliveries are waiting for their next attempt.
+	StatusPending = "pending"
+
+	// StatusDelivered deliveries were accepted by the receiver.
+	StatusDelivered = "delivered"
+
+	// StatusFailed deliveries ran out of attempts. They stay in the dead
+	// letter view until they are replayed.
+	StatusFailed = "failed"
+)
+
+const (
+	// DefaultLimit is the page size used when the caller does not ask for one.
+	DefaultLimit = 50
+
+	// MaxLimit is the largest page size a caller may request.
+	MaxLimit = 500
+)
+
+var (
+	ErrNotFound         = errors.New("webhook not found")
+	ErrInvalidID        = errors.New("invalid webhook ID format")
+	ErrInvalidURL       = errors.New("webhook url must be http or https")
+	ErrUnknownHost      = errors.New("webhook url host cannot be resolved")
+	ErrPrivateURL       = errors.New("webhook url must not point to a loopback, private or link-local address")
+	ErrUnknownEvent     = errors.New("unknown event type")
+	ErrDeliveryNotFound = errors.New("failed delivery not found")
+	ErrInvalidStatus    = errors.New("invalid delivery status")
+)
+
+// selectSubscriptions lists the subscription columns in the order
+// Subscription declares them.
+const selectSubscriptions = `SELECT subscription_id, org_id, url, events, date_created, date_updated FROM webhook_subscriptions`
+
+// selectDeliveries lists the delivery columns in the order Delivery declares
+// them. It must be followed by a WHERE clause on d or s.
+const selectDeliveries = `SELECT d.delivery_id, d.subscription_id, d.event_id, e.type AS event_type,
+	d.status, d.attempts, d.next_attempt, d.last_error, d.response_code, d.date_created, d.date_delivered
+	FROM webhook_deliveries AS d
+	JOIN outbox AS e ON e.event_id = d.event_id
+	JOIN webhook_subscriptions AS s ON s.subscription_id = d.subscription_id`
+
+// permitted reports whether webhooks may be sent to ip. It is a variable so
+// tests can deliver to a local receiver.
+var permitted = public
+
+// public reports whether ip is reachable from the internet, keeping
+// webhooks away from the service's own host and network, and from the cloud
+// metadata endpoint at 169.254.169.254.
+func public(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsMulticast()
+}
+
+// Create subscribes a URL to events of the caller's organization. URLs whose
+// host is or resolves to an address that is not public are refused.
+func Create(ctx context.Context, db *sqlx.DB, claims auth.Claims, ns NewSubscription, now time.Time) (*Created, error) {
+	u, err := url.Parse(ns.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+	if err := checkHost(ctx, u.Hostname()); err != nil {
+		return nil, err
+	}
+	for _, typ := range ns.Events {
+		if !event.Known(typ) {
+			return nil, errors.Wrap(ErrUnknownEvent, typ)
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errors.Wrap(err, "generating webhook secret")
+	}
+
+	s := Created{
+		Subscription: Subscription{
+			ID:          uuid.New().String(),
+			OrgID:       claims.OrgID,
+			URL:         ns.URL,
+			Events:      ns.Events,
+			DateCreated: now.UTC(),
+			DateUpdated: now.UTC(),
+		},
+		Secret: secretPrefix + base64.RawURLEncoding.EncodeToString(b),
+	}
+
+	const q = `INSERT INTO webhook_subscriptions
+	(subscription_id, org_id, url, secret, events, date_created, date_updated)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err = db.ExecContext(ctx, q, s.ID, s.OrgID, s.URL, s.Secret, s.Events, s.DateCreated, s.DateUpdated)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting webhook")
+	}
+
+	return &s, nil
+}
+
+// checkHost returns ErrPrivateURL when host is, or resolves to, an address
+// webhooks may not be sent to. The dispatcher checks again when it connects,
+// since the host may resolve differently by then.
+func checkHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return ErrUnknownHost
+	}
+	for _, a := range addrs {
+		if !permitted(a.IP) {
+			return ErrPrivateURL
+		}
+	}
+	return nil
+}
+
+// List gives every subscription of the caller's organization.
+func List(ctx context.Context, db *sqlx.DB, claims auth.Claims) ([]Subscription, error) {
+	list := []Subscription{}
+
+	const q = selectSubscriptions + ` WHERE ($1::UUID IS NULL OR org_id = $1) ORDER BY date_created`
+	if err := db.SelectContext(ctx, &list, q, claims.OrgScope()); err != nil {
+		return nil, errors.Wrap(err, "selecting webhooks")
+	}
+
+	return list, nil
+}
+
+// Retrieve finds a subscription of the caller's organization.
+func Retrieve(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string) (*Subscription, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var s Subscription
+
+	const q = selectSubscriptions + ` WHERE subscription_id = $1 AND ($2::UUID IS NULL OR org_id = $2)`
+	if err := db.GetContext(ctx, &s, q, id, claims.OrgScope()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting webhook")
+	}
+
+	return &s, nil
+}
+
+// Delete removes a subscription of the caller's organization along with its
+// deliveries.
+func Delete(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `DELETE FROM webhook_subscriptions WHERE subscription_id = $1 AND ($2::UUID IS NULL OR org_id = $2)`
+	res, err := db.ExecContext(ctx, q, id, claims.OrgScope())
+	if err != nil {
+		return errors.Wrap(err, "deleting webhook")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// ListDeliveries gives the latest deliveries of a subscription of the
+// caller's organization, newest first. When status is not empty only
+// deliveries with that status are returned.
+func ListDeliveries(ctx context.Context, db *sqlx.DB, claims auth.Claims, id, status string, limit, offset int) ([]Delivery, error) {
+	if _, err := Retrieve(ctx, db, claims, id); err != nil {
+		return nil, err
+	}
+	if status != "" && status != StatusPending && status != StatusDelivered && status != StatusFailed {
+		return nil, ErrInvalidStatus
+	}
+	if limit <= 0 || limit > MaxLimit {
+		limit = DefaultLimit
+	}
+
+	list := []Delivery{}
+
+	const q = selectDeliveries + ` WHERE d.subscription_id = $1 AND ($2 = '' OR d.status = $2)
+	ORDER BY d.date_created DESC, d.delivery_id LIMIT $3 OFFSET $4`
+	if err := db.SelectContext(ctx, &list, q, id, status, limit, offset); err != nil {
+		return nil, errors.Wrap(err, "selecting deliveries")
+	}
+
+	return list, nil
+}
+
+// ListFailed is the dead letter view: the deliveries of every subscription of
+// the caller's organization that ran out of attempts, newest first.
+func ListFailed(ctx context.Context, db *sqlx.DB, claims auth.Claims, limit, offset int) ([]Delivery, error) {
+	if limit <= 0 || limit > MaxLimit {
+		limit = DefaultLimit
+	}
+
+	list := []Delivery{}
+
+	const q = selectDeliveries + ` WHERE d.status = $1 AND ($2::UUID IS NULL OR s.org_id = $2)
+	ORDER BY d.date_created DESC, d.delivery_id LIMIT $3 OFFSET $4`
+	if err := db.SelectContext(ctx, &list, q, StatusFailed, claims.OrgScope(), limit, offset); err != nil {
+		return nil, errors.Wrap(err, "selecting failed deliveries")
+	}
+
+	return list, nil
+}
+
+// Replay schedules every failed delivery of a subscription of the caller's
+// organization to be attempted again, with a fresh set of attempts. It
+// returns how many deliveries were scheduled.
+func Replay(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string, now time.Time) (int, error) {
+	if _, err := Retrieve(ctx, db, claims, id); err != nil {
+		return 0, err
+	}
+
+	const q = `UPDATE webhook_deliveries SET status = $1, attempts = 0, next_attempt = $2
+	WHERE subscription_id = $3 AND status = $4`
+	res, err := db.ExecContext(ctx, q, StatusPending, now.UTC(), id, StatusFailed)
+	if err != nil {
+		return 0, errors.Wrap(err, "replaying deliveries")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "counting replayed deliveries")
+	}
+
+	return int(n), nil
+}
+
+// ReplayDelivery schedules a single failed delivery of the caller's
+// organization to be attempted again, with a fresh set of attempts.
+func ReplayDelivery(ctx context.Context, db *sqlx.DB, claims auth.Claims, deliveryID string, now time.Time) error {
+	if _, err := uuid.Parse(deliveryID); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `UPDATE webhook_deliveries AS d SET status = $1, attempts = 0, next_attempt = $2
+	FROM webhook_subscriptions AS s
+	WHERE s.subscription_id = d.subscription_id
+	AND d.delivery_id = $3 AND d.status = $4
+	AND ($5::UUID IS NULL OR s.org_id = $5)`
+	res, err := db.ExecContext(ctx, q, StatusPending, now.UTC(), deliveryID, StatusFailed, claims.OrgScope())
+	if err != nil {
+		return errors.Wrap(err, "replaying delivery")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrDeliveryNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sales_service/internal/event"
+	"sales_service/internal/organization"
+	"sales_service/internal/platform/auth"
+	"sales_service/internal/platform/database/databasetest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestWebhooks(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	defer teardown()
+
+	ctx := context.Background()
+
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	claims := auth.NewClaims("a0eebc99-9c0b-4ef8-bb6d-6bb9bd390a03", organization.DefaultID, []string{auth.RoleAdmin}, now, time.Hour)
+
+	// The receiver answers with whatever status is set, and remembers what it
+	// was sent.
+	var (
+		mu       sync.Mutex
+		status   = http.StatusOK
+		received []*http.Request
+		bodies   [][]byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, r)
+		bodies = append(bodies, body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	setStatus := func(code int) {
+		mu.Lock()
+		defer mu.Unlock()
+		status = code
+	}
+	requests := func() []*http.Request {
+		mu.Lock()
+		defer mu.Unlock()
+		return received
+	}
+
+	if _, err := Create(ctx, db, claims, NewSubscription{URL: "ftp://example.com", Events: []string{event.ProductCreated}}, now); err != ErrInvalidURL {
+		t.Fatalf("expected ErrInvalidURL, got %v", err)
+	}
+	for _, u := range []string{"http://127.0.0.1:8080/", "http://[::1]/", "http://10.1.2.3/", "http://192.168.0.1/", "http://169.254.169.254/latest/meta-data/"} {
+		if _, err := Create(ctx, db, claims, NewSubscription{URL: u, Events: []string{event.ProductCreated}}, now); err != ErrPrivateURL {
+			t.Fatalf("%s: expected ErrPrivateURL, got %v", u, err)
+		}
+	}
+
+	// The receiver listens on loopback.
+	permitted = func(net.IP) bool { return true }
+	defer func() { permitted = public }()
+
+	sub, err := Create(ctx, db, claims, NewSubscription{URL: srv.URL, Events: []string{event.ProductCreated}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDispatcher(db, log.New(io.Discard, "", 0))
+	d.MaxAttempts = 2
+
+	// Only the event the webhook subscribed to is delivered.
+	publish(t, db, event.ProductCreated, now)
+	publish(t, db, event.SaleCreated, now)
+
+	if err := d.Poll(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests()) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(requests()))
+	}
+
+	r := requests()[0]
+	if got := r.Header.Get(EventHeader); got != event.ProductCreated {
+		t.Fatalf("expected event %s, got %s", event.ProductCreated, got)
+	}
+	if got, want := r.Header.Get(SignatureHeader), Sign(sub.Secret, now.Unix(), bodies[0]); got != want {
+		t.Fatalf("expected signature %s, got %s", want, got)
+	}
+
+	// A failing receiver is retried after the backoff and then given up on.
+	setStatus(http.StatusInternalServerError)
+	publish(t, db, event.ProductCreated, now)
+
+	if err := d.Poll(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Poll(ctx, now.Add(d.MinBackoff/2)); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests()) != 2 {
+		t.Fatalf("expected no retry before the backoff, got %d requests", len(requests()))
+	}
+	if err := d.Poll(ctx, now.Add(d.MinBackoff)); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests()) != 3 {
+		t.Fatalf("expected a retry after the backoff, got %d requests", len(requests()))
+	}
+
+	failed, err := ListFailed(ctx, db, claims, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0].Attempts != 2 || *failed[0].ResponseCode != http.StatusInternalServerError {
+		t.Fatalf("expected one failed delivery after 2 attempts, got %+v", failed)
+	}
+
+	// A replay delivers it again under the same event ID.
+	setStatus(http.StatusOK)
+
+	n, err := Replay(ctx, db, claims, sub.ID, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 replayed delivery, got %d", n)
+	}
+	if err := d.Poll(ctx, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests()) != 4 {
+		t.Fatalf("expected the replay to be delivered, got %d requests", len(requests()))
+	}
+	if requests()[3].Header.Get(EventIDHeader) != failed[0].EventID {
+		t.Fatalf("expected event %s to be replayed, got %s", failed[0].EventID, requests()[3].Header.Get(EventIDHeader))
+	}
+
+	delivered, err := ListDeliveries(ctx, db, claims, sub.ID, StatusDelivered, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 2 {
+		t.Fatalf("expected 2 delivered deliveries, got %d", len(delivered))
+	}
+}
+
+func TestDialControl(t *testing.T) {
+	for addr, want := range map[string]error{
+		"127.0.0.1:80":         ErrPrivateURL,
+		"10.0.0.7:443":         ErrPrivateURL,
+		"172.16.4.2:443":       ErrPrivateURL,
+		"169.254.169.254:80":   ErrPrivateURL,
+		"[::1]:443":            ErrPrivateURL,
+		"[fe80::1]:443":        ErrPrivateURL,
+		"93.184.216.34:443":    nil,
+		"[2606:4700::1111]:80": nil,
+	} {
+		if err := dialControl("tcp", addr, nil); err != want {
+			t.Errorf("%s: expected %v, got %v", addr, want, err)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := Dispatcher{MinBackoff: time.Second, MaxBackoff: 10 * time.Second}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{20, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := d.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// publish adds an event of the default organization to the outbox.
+func publish(t *testing.T, db *sqlx.DB, typ string, now time.Time) {
+	t.Helper()
+
+	payload := map[string]string{"type": typ}
+	if err := event.Publish(context.Background(), db, organization.DefaultID, typ, payload, now); err != nil {
+		t.Fatal(err)
+	}
+}